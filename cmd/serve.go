@@ -193,6 +193,10 @@ func runServ(c *cli.Context) error {
 		fail("mirror repository is read-only", "")
 	}
 
+	if requestedMode > models.ACCESS_MODE_READ && !repoUser.HasStorageQuotaRemaining(0) {
+		fail("repository owner has exceeded storage quota", "Storage quota exceeded: %s", repoUser.Name)
+	}
+
 	// Allow anonymous clone for public repositories.
 	var (
 		keyID int64
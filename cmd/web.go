@@ -5,6 +5,7 @@
 package cmd
 
 import (
+	"bytes"
 	"crypto/tls"
 	"fmt"
 	"io/ioutil"
@@ -100,6 +101,28 @@ go get -u %[1]s`, c.ImportPath, c.Version(), c.Expected)
 	}
 }
 
+// avatarHeaders sets headers of custom avatars, SVG avatars are served with
+// their image type and must not run scripts or load anything even when
+// opened directly.
+func avatarHeaders(ctx *macaron.Context) {
+	if !strings.HasPrefix(ctx.Req.URL.Path, "/avatars/") {
+		return
+	}
+	f, err := os.Open(path.Join(setting.AvatarUploadPath, path.Base(ctx.Req.URL.Path)))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	ctx.Resp.Header().Set("X-Content-Type-Options", "nosniff")
+	ctx.Resp.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; sandbox")
+	// Saved SVG avatars are sanitized and always start with root element.
+	head := make([]byte, 4)
+	if n, _ := f.Read(head); bytes.Equal(head[:n], []byte("<svg")) {
+		ctx.Resp.Header().Set("Content-Type", "image/svg+xml")
+	}
+}
+
 // newMacaron initializes Macaron instance.
 func newMacaron() *macaron.Macaron {
 	m := macaron.New()
@@ -119,6 +142,7 @@ func newMacaron() *macaron.Macaron {
 			SkipLogging: setting.DisableRouterLog,
 		},
 	))
+	m.Use(avatarHeaders)
 	m.Use(macaron.Static(
 		setting.AvatarUploadPath,
 		macaron.StaticOptions{
@@ -249,6 +273,7 @@ func runWeb(ctx *cli.Context) error {
 		m.Any("/activate", user.Activate)
 		m.Any("/activate_email", user.ActivateEmail)
 		m.Get("/email2user", user.Email2User)
+		m.Get("/avatar/:username", user.DefaultAvatar)
 		m.Get("/forget_password", user.ForgotPasswd)
 		m.Post("/forget_password", user.ForgotPasswdPost)
 		m.Get("/logout", user.SignOut)
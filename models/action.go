@@ -629,3 +629,28 @@ func GetFeeds(ctxUser *User, actorID, offset int64, isProfile bool) ([]*Action,
 	err := sess.Find(&actions)
 	return actions, err
 }
+
+// bucketActionsByDay counts given action creation times by local date in format "2006-01-02",
+// times outside of range [from, to) are ignored.
+func bucketActionsByDay(actions []*Action, from, to time.Time) map[string]int {
+	counts := make(map[string]int)
+	for i := range actions {
+		created := time.Unix(actions[i].CreatedUnix, 0).Local()
+		if created.Before(from) || !created.Before(to) {
+			continue
+		}
+		counts[created.Format("2006-01-02")]++
+	}
+	return counts
+}
+
+// GetUserDailyActionCounts returns number of actions performed by given user
+// on each day in range [from, to), days without any action are omitted.
+func GetUserDailyActionCounts(uid int64, from, to time.Time) (map[string]int, error) {
+	actions := make([]*Action, 0, 100)
+	if err := x.Cols("created_unix").Where("user_id=? AND act_user_id=?", uid, uid).
+		And("created_unix>=? AND created_unix<?", from.Unix(), to.Unix()).Find(&actions); err != nil {
+		return nil, err
+	}
+	return bucketActionsByDay(actions, from, to), nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_bucketActionsByDay(t *testing.T) {
+	Convey("Count actions by day within range", t, func() {
+		from := time.Date(2016, 3, 1, 0, 0, 0, 0, time.Local)
+		to := from.AddDate(0, 0, 3)
+		at := func(day, hour int) *Action {
+			return &Action{CreatedUnix: from.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour).Unix()}
+		}
+
+		actions := []*Action{
+			at(-1, 23), // Before range
+			at(0, 0),
+			at(0, 12),
+			at(2, 23),
+			at(3, 0), // After range
+		}
+		So(bucketActionsByDay(actions, from, to), ShouldResemble, map[string]int{
+			"2016-03-01": 2,
+			"2016-03-03": 1,
+		})
+	})
+}
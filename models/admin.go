@@ -23,6 +23,7 @@ type NoticeType int
 
 const (
 	NOTICE_REPOSITORY NoticeType = iota + 1
+	NOTICE_USER
 )
 
 // Notice represents a system notice for admin.
@@ -6,6 +6,7 @@ package models
 
 import (
 	"fmt"
+	"time"
 )
 
 type ErrNameReserved struct {
@@ -68,6 +69,20 @@ func (err ErrUserNotExist) Error() string {
 	return fmt.Sprintf("user does not exist [uid: %d, name: %s]", err.UID, err.Name)
 }
 
+type ErrUserLocked struct {
+	UID   int64
+	Until time.Time
+}
+
+func IsErrUserLocked(err error) bool {
+	_, ok := err.(ErrUserLocked)
+	return ok
+}
+
+func (err ErrUserLocked) Error() string {
+	return fmt.Sprintf("user is locked because of failed sign in attempts [uid: %d, until: %s]", err.UID, err.Until)
+}
+
 type ErrEmailAlreadyUsed struct {
 	Email string
 }
@@ -120,6 +135,140 @@ func (err ErrReachLimitOfRepo) Error() string {
 	return fmt.Sprintf("user has reached maximum limit of repositories [limit: %d]", err.Limit)
 }
 
+type ErrUserStorageQuotaExceeded struct {
+	UID   int64
+	Quota int64
+}
+
+func IsErrUserStorageQuotaExceeded(err error) bool {
+	_, ok := err.(ErrUserStorageQuotaExceeded)
+	return ok
+}
+
+func (err ErrUserStorageQuotaExceeded) Error() string {
+	return fmt.Sprintf("user has exceeded storage quota [uid: %d, quota: %d]", err.UID, err.Quota)
+}
+
+type ErrUserProfileTooLong struct {
+	Field string
+	Limit int
+}
+
+func IsErrUserProfileTooLong(err error) bool {
+	_, ok := err.(ErrUserProfileTooLong)
+	return ok
+}
+
+func (err ErrUserProfileTooLong) Error() string {
+	return fmt.Sprintf("user profile field is too long [field: %s, limit: %d]", err.Field, err.Limit)
+}
+
+type ErrThemeNotExist struct {
+	Theme string
+}
+
+func IsErrThemeNotExist(err error) bool {
+	_, ok := err.(ErrThemeNotExist)
+	return ok
+}
+
+func (err ErrThemeNotExist) Error() string {
+	return fmt.Sprintf("theme does not exist [theme: %s]", err.Theme)
+}
+
+type ErrImpersonationNotAllowed struct {
+	AdminID  int64
+	TargetID int64
+}
+
+func IsErrImpersonationNotAllowed(err error) bool {
+	_, ok := err.(ErrImpersonationNotAllowed)
+	return ok
+}
+
+func (err ErrImpersonationNotAllowed) Error() string {
+	return fmt.Sprintf("impersonation is not allowed [admin_id: %d, target_id: %d]", err.AdminID, err.TargetID)
+}
+
+type ErrImpersonationTokenNotExist struct {
+	SHA string
+}
+
+func IsErrImpersonationTokenNotExist(err error) bool {
+	_, ok := err.(ErrImpersonationTokenNotExist)
+	return ok
+}
+
+func (err ErrImpersonationTokenNotExist) Error() string {
+	return fmt.Sprintf("impersonation token does not exist or has expired [sha: %s]", err.SHA)
+}
+
+type ErrPasswordTooWeak struct {
+	MinLength int
+}
+
+func IsErrPasswordTooWeak(err error) bool {
+	_, ok := err.(ErrPasswordTooWeak)
+	return ok
+}
+
+func (err ErrPasswordTooWeak) Error() string {
+	return fmt.Sprintf("password is too weak [min_length: %d]", err.MinLength)
+}
+
+type ErrInvalidWebsiteURL struct {
+	URL string
+}
+
+func IsErrInvalidWebsiteURL(err error) bool {
+	_, ok := err.(ErrInvalidWebsiteURL)
+	return ok
+}
+
+func (err ErrInvalidWebsiteURL) Error() string {
+	return fmt.Sprintf("website URL is not valid [url: %s]", err.URL)
+}
+
+type ErrAvatarEmailNotVerified struct {
+	Email string
+}
+
+func IsErrAvatarEmailNotVerified(err error) bool {
+	_, ok := err.(ErrAvatarEmailNotVerified)
+	return ok
+}
+
+func (err ErrAvatarEmailNotVerified) Error() string {
+	return fmt.Sprintf("avatar email is not a verified email of user [email: %s]", err.Email)
+}
+
+type ErrInvalidPhoneNumber struct {
+	Phone string
+}
+
+func IsErrInvalidPhoneNumber(err error) bool {
+	_, ok := err.(ErrInvalidPhoneNumber)
+	return ok
+}
+
+func (err ErrInvalidPhoneNumber) Error() string {
+	return fmt.Sprintf("phone number is not in E.164 format [phone: %s]", err.Phone)
+}
+
+type ErrUserNotConvertible struct {
+	UID    int64
+	Reason string
+}
+
+func IsErrUserNotConvertible(err error) bool {
+	_, ok := err.(ErrUserNotConvertible)
+	return ok
+}
+
+func (err ErrUserNotConvertible) Error() string {
+	return fmt.Sprintf("user cannot be converted to organization [uid: %d, reason: %s]", err.UID, err.Reason)
+}
+
 //  __      __.__ __   .__
 // /  \    /  \__|  | _|__|
 // \   \/\/   /  |  |/ /  |
@@ -312,6 +461,19 @@ func (err ErrLastOrgOwner) Error() string {
 	return fmt.Sprintf("user is the last member of owner team [uid: %d]", err.UID)
 }
 
+type ErrNotOrganization struct {
+	UID int64
+}
+
+func IsErrNotOrganization(err error) bool {
+	_, ok := err.(ErrNotOrganization)
+	return ok
+}
+
+func (err ErrNotOrganization) Error() string {
+	return fmt.Sprintf("user is not an organization [uid: %d]", err.UID)
+}
+
 // __________                           .__  __
 // \______   \ ____ ______   ____  _____|__|/  |_  ___________ ___.__.
 //  |       _// __ \\____ \ /  _ \/  ___/  \   __\/  _ \_  __ <   |  |
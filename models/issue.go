@@ -106,7 +106,7 @@ func (i *Issue) AfterSet(colName string, _ xorm.Cell) {
 		}
 
 	case "poster_id":
-		i.Poster, err = GetUserByID(i.PosterID)
+		i.Poster, err = GetUserByIDIncludingDeleted(i.PosterID)
 		if err != nil {
 			if IsErrUserNotExist(err) {
 				i.PosterID = -1
@@ -1041,6 +1041,15 @@ func UpdateIssueUsersByMentions(issueID int64, uids []int64) error {
 	return nil
 }
 
+// CountPendingMentions returns number of issue-user pairs that given user
+// has been mentioned in but not read yet.
+func CountPendingMentions(uid int64) (int64, error) {
+	return x.Where("uid=?", uid).
+		And("is_mentioned=?", true).
+		And("is_read=?", false).
+		Count(new(IssueUser))
+}
+
 //    _____  .__.__                   __
 //   /     \ |__|  |   ____   _______/  |_  ____   ____   ____
 //  /  \ /  \|  |  | _/ __ \ /  ___/\   __\/  _ \ /    \_/ __ \
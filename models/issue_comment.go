@@ -82,7 +82,7 @@ func (c *Comment) AfterSet(colName string, _ xorm.Cell) {
 		}
 
 	case "poster_id":
-		c.Poster, err = GetUserByID(c.PosterID)
+		c.Poster, err = GetUserByIDIncludingDeleted(c.PosterID)
 		if err != nil {
 			if IsErrUserNotExist(err) {
 				c.PosterID = -1
@@ -0,0 +1,40 @@
+// +build sqlite
+
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CountPendingMentions(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Count mentions that are not read yet", t, func() {
+		alice := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com"}
+		bob := &User{Name: "bob", LowerName: "bob", Email: "bob@example.com"}
+		_, err := x.Insert(alice, bob)
+		So(err, ShouldBeNil)
+
+		So(UpdateIssueMentions(1, []string{"Alice"}), ShouldBeNil)
+		So(UpdateIssueMentions(2, []string{"alice", "bob"}), ShouldBeNil)
+
+		count, err := CountPendingMentions(alice.ID)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+
+		So(UpdateIssueUserByRead(alice.ID, 1), ShouldBeNil)
+		count, err = CountPendingMentions(alice.ID)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 1)
+
+		count, err = CountPendingMentions(bob.ID)
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 1)
+	})
+}
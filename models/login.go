@@ -268,6 +268,51 @@ func DeleteSource(source *LoginSource) error {
 	return err
 }
 
+// GetUsersByLoginSource returns all users that are bound to given login source.
+func GetUsersByLoginSource(sourceID int64) ([]*User, error) {
+	users := make([]*User, 0, 10)
+	return users, x.Where("login_source=?", sourceID).And("is_deleted=?", false).Find(&users)
+}
+
+// GetUserByLoginSourceAndName returns the user bound to given login source with given login name.
+func GetUserByLoginSourceAndName(sourceID int64, loginName string) (*User, error) {
+	if len(loginName) == 0 {
+		return nil, ErrUserNotExist{0, loginName}
+	}
+
+	u := new(User)
+	has, err := x.Where("login_source=?", sourceID).And("login_name=?", loginName).Get(u)
+	if err != nil {
+		return nil, err
+	} else if !has {
+		return nil, ErrUserNotExist{0, loginName}
+	}
+	return u, nil
+}
+
+// GetUsersByLoginType returns all individual users who sign in with given login type,
+// users without login type are considered as local users.
+func GetUsersByLoginType(t LoginType) ([]*User, error) {
+	sess := x.Where("type=?", USER_TYPE_INDIVIDUAL).And("is_deleted=?", false)
+	if t == LOGIN_PLAIN {
+		sess.And("(login_type=? OR login_type=?)", LOGIN_PLAIN, LOGIN_NOTYPE)
+	} else {
+		sess.And("login_type=?", t)
+	}
+
+	users := make([]*User, 0, 10)
+	return users, sess.Asc("id").Find(&users)
+}
+
+// DisableUsersByLoginSource prohibits login of all users that are bound to given login source,
+// it returns number of users affected. It is useful before deactivating or removing a login source
+// so users get a clear message instead of failing to sign in against the source.
+func DisableUsersByLoginSource(sourceID int64) (int, error) {
+	affected, err := x.Where("login_source=?", sourceID).And("prohibit_login=?", false).
+		Cols("prohibit_login").Update(&User{ProhibitLogin: true})
+	return int(affected), err
+}
+
 // .____     ________      _____ __________
 // |    |    \______ \    /  _  \\______   \
 // |    |     |    |  \  /  /_\  \|     ___/
@@ -530,11 +575,27 @@ func UserSignIn(uname, passwd string) (*User, error) {
 	if userExists {
 		switch u.LoginType {
 		case LOGIN_NOTYPE, LOGIN_PLAIN:
-			if u.ValidatePassword(passwd) {
-				return u, nil
+			// Lock is only revealed with correct password, otherwise locked user
+			// looks the same as wrong password or user who does not exist.
+			// Failures during lock are not counted so they cannot extend it.
+			if !u.ValidatePassword(passwd) {
+				if !u.IsLocked() {
+					if err = u.RecordFailedLogin(); err != nil {
+						log.Error(4, "RecordFailedLogin [%d]: %v", u.ID, err)
+					}
+				}
+				return nil, ErrUserNotExist{u.ID, u.Name}
+			} else if u.IsLocked() {
+				return nil, ErrUserLocked{u.ID, u.LockedUntil}
 			}
 
-			return nil, ErrUserNotExist{u.ID, u.Name}
+			if err = u.ResetFailedLogins(); err != nil {
+				log.Error(4, "ResetFailedLogins [%d]: %v", u.ID, err)
+			}
+			if err = UpgradePasswdHash(u, passwd); err != nil {
+				log.Error(4, "UpgradePasswdHash [%d]: %v", u.ID, err)
+			}
+			return u, nil
 
 		default:
 			var source LoginSource
@@ -0,0 +1,158 @@
+// +build sqlite
+
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func Test_GetUsersByLoginType(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Only users of given login type are returned", t, func() {
+		local := &User{Name: "local", LowerName: "local", Email: "local@example.com"}
+		plain := &User{Name: "plain", LowerName: "plain", Email: "plain@example.com", LoginType: LOGIN_PLAIN}
+		ldap := &User{Name: "ldap", LowerName: "ldap", Email: "ldap@example.com", LoginType: LOGIN_LDAP, LoginSource: 1}
+		smtp := &User{Name: "smtp", LowerName: "smtp", Email: "smtp@example.com", LoginType: LOGIN_SMTP, LoginSource: 2}
+		org := &User{Name: "org", LowerName: "org", Email: "org@example.com", LoginType: LOGIN_LDAP, Type: USER_TYPE_ORGANIZATION}
+		_, err := x.Insert(local, plain, ldap, smtp, org)
+		So(err, ShouldBeNil)
+
+		users, err := GetUsersByLoginType(LOGIN_LDAP)
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 1)
+		So(users[0].ID, ShouldEqual, ldap.ID)
+
+		users, err = GetUsersByLoginType(LOGIN_PLAIN)
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 2)
+		So(users[0].ID, ShouldEqual, local.ID)
+		So(users[1].ID, ShouldEqual, plain.ID)
+
+		users, err = GetUsersByLoginType(LOGIN_PAM)
+		So(err, ShouldBeNil)
+		So(users, ShouldBeEmpty)
+	})
+}
+
+func Test_DisableUsersByLoginSource(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Prohibit login of users bound to given login source", t, func() {
+		bound := &User{Name: "bound", LowerName: "bound", Email: "bound@example.com", LoginType: LOGIN_LDAP, LoginSource: 1}
+		prohibited := &User{Name: "prohibited", LowerName: "prohibited", Email: "prohibited@example.com", LoginType: LOGIN_LDAP, LoginSource: 1, ProhibitLogin: true}
+		other := &User{Name: "other", LowerName: "other", Email: "other@example.com", LoginType: LOGIN_SMTP, LoginSource: 2}
+		_, err := x.Insert(bound, prohibited, other)
+		So(err, ShouldBeNil)
+
+		users, err := GetUsersByLoginSource(1)
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 2)
+
+		affected, err := DisableUsersByLoginSource(1)
+		So(err, ShouldBeNil)
+		So(affected, ShouldEqual, 1)
+
+		u, err := GetUserByID(bound.ID)
+		So(err, ShouldBeNil)
+		So(u.ProhibitLogin, ShouldBeTrue)
+		u, err = GetUserByID(other.ID)
+		So(err, ShouldBeNil)
+		So(u.ProhibitLogin, ShouldBeFalse)
+	})
+}
+
+func Test_GetUserByLoginSourceAndName(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Look up user by login source and login name", t, func() {
+		ldap := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com", LoginSource: 1, LoginName: "alice"}
+		smtp := &User{Name: "alice2", LowerName: "alice2", Email: "alice2@example.com", LoginSource: 2, LoginName: "alice"}
+		_, err := x.Insert(ldap, smtp)
+		So(err, ShouldBeNil)
+
+		u, err := GetUserByLoginSourceAndName(1, "alice")
+		So(err, ShouldBeNil)
+		So(u.ID, ShouldEqual, ldap.ID)
+		u, err = GetUserByLoginSourceAndName(2, "alice")
+		So(err, ShouldBeNil)
+		So(u.ID, ShouldEqual, smtp.ID)
+
+		_, err = GetUserByLoginSourceAndName(3, "alice")
+		So(IsErrUserNotExist(err), ShouldBeTrue)
+		_, err = GetUserByLoginSourceAndName(1, "bob")
+		So(IsErrUserNotExist(err), ShouldBeTrue)
+		_, err = GetUserByLoginSourceAndName(1, "")
+		So(IsErrUserNotExist(err), ShouldBeTrue)
+	})
+}
+
+func Test_UserSignIn_Lockout(t *testing.T) {
+	setTestEngine(t)
+	setting.BcryptCost = bcrypt.MinCost
+	setting.LoginMaxFailures = 3
+	setting.LoginLockoutMinutes = 5
+
+	newUser := func(name string) *User {
+		u := &User{Name: name, LowerName: name, Email: name + "@example.com", Passwd: "password"}
+		So(u.EncodePasswd(), ShouldBeNil)
+		_, err := x.Insert(u)
+		So(err, ShouldBeNil)
+		return u
+	}
+	numFailures := func(u *User) int {
+		u, err := GetUserByID(u.ID)
+		So(err, ShouldBeNil)
+		return u.NumFailedLogins
+	}
+
+	Convey("Lock user once failures reach threshold", t, func() {
+		u := newUser("alice")
+		for i := 1; i < setting.LoginMaxFailures; i++ {
+			_, err := UserSignIn("alice", "wrong")
+			So(IsErrUserNotExist(err), ShouldBeTrue)
+		}
+		_, err := UserSignIn("alice", "password")
+		So(err, ShouldBeNil)
+		So(numFailures(u), ShouldEqual, 0)
+
+		for i := 0; i < setting.LoginMaxFailures; i++ {
+			_, err = UserSignIn("alice", "wrong")
+			So(IsErrUserNotExist(err), ShouldBeTrue)
+		}
+		_, err = UserSignIn("alice", "password")
+		So(IsErrUserLocked(err), ShouldBeTrue)
+
+		// Locked user looks the same as wrong password or missing user.
+		_, err = UserSignIn("alice", "wrong")
+		So(IsErrUserNotExist(err), ShouldBeTrue)
+		_, err = UserSignIn("nobody", "wrong")
+		So(IsErrUserNotExist(err), ShouldBeTrue)
+		So(numFailures(u), ShouldEqual, setting.LoginMaxFailures)
+	})
+
+	Convey("Lock expires and successful sign in resets failures", t, func() {
+		u := newUser("bob")
+		for i := 0; i < setting.LoginMaxFailures; i++ {
+			UserSignIn("bob", "wrong")
+		}
+		_, err := UserSignIn("bob", "password")
+		So(IsErrUserLocked(err), ShouldBeTrue)
+
+		_, err = x.Exec("UPDATE `user` SET locked_until_unix=? WHERE id=?", time.Now().Add(-time.Minute).Unix(), u.ID)
+		So(err, ShouldBeNil)
+		_, err = UserSignIn("bob", "password")
+		So(err, ShouldBeNil)
+		So(numFailures(u), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,37 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/Unknwon/com"
+	"github.com/go-xorm/xorm"
+)
+
+// hashAccessTokens replaces plaintext access tokens with their SHA1 hashes.
+func hashAccessTokens(x *xorm.Engine) error {
+	results, err := x.Query("SELECT `id`,`sha1` FROM `access_token`")
+	if err != nil {
+		return fmt.Errorf("select access tokens: %v", err)
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	for _, result := range results {
+		hash := sha1.Sum(result["sha1"])
+		if _, err = sess.Exec("UPDATE `access_token` SET `sha1`=? WHERE `id`=?",
+			hex.EncodeToString(hash[:]), com.StrTo(result["id"]).MustInt64()); err != nil {
+			return fmt.Errorf("update access token: %v", err)
+		}
+	}
+	return sess.Commit()
+}
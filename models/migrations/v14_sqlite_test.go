@@ -0,0 +1,36 @@
+// +build sqlite
+
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package migrations
+
+import (
+	"testing"
+
+	"github.com/go-xorm/xorm"
+	_ "github.com/mattn/go-sqlite3"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_hashAccessTokens(t *testing.T) {
+	Convey("Replace plaintext access tokens with their SHA1 hashes", t, func() {
+		x, err := xorm.NewEngine("sqlite3", "file:gogs_migrations_test?mode=memory&cache=shared")
+		So(err, ShouldBeNil)
+		defer x.Close()
+
+		_, err = x.Exec("CREATE TABLE `access_token` (`id` INTEGER PRIMARY KEY AUTOINCREMENT, `sha1` VARCHAR(40))")
+		So(err, ShouldBeNil)
+		_, err = x.Exec("INSERT INTO `access_token` (`sha1`) VALUES (?), (?)", "abc", "def")
+		So(err, ShouldBeNil)
+
+		So(hashAccessTokens(x), ShouldBeNil)
+
+		results, err := x.Query("SELECT `sha1` FROM `access_token` ORDER BY `id`")
+		So(err, ShouldBeNil)
+		So(results, ShouldHaveLength, 2)
+		So(string(results[0]["sha1"]), ShouldEqual, "a9993e364706816aba3e25717850c26c9cd0d89d")
+		So(string(results[1]["sha1"]), ShouldEqual, "589c22335a381f122d129225f5c0ba3056ed5811")
+	})
+}
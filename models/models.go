@@ -65,7 +65,7 @@ func init() {
 		new(Mirror), new(Release), new(LoginSource), new(Webhook),
 		new(UpdateTask), new(HookTask),
 		new(Team), new(OrgUser), new(TeamUser), new(TeamRepo),
-		new(Notice), new(EmailAddress))
+		new(Notice), new(EmailAddress), new(ImpersonationToken), new(DeletedUserName), new(ExternalLoginUser))
 
 	gonicNames := []string{"SSL"}
 	for _, name := range gonicNames {
@@ -204,8 +204,13 @@ type Statistic struct {
 }
 
 func GetStatistic() (stats Statistic) {
-	stats.Counter.User = CountUsers()
-	stats.Counter.Org = CountOrganizations()
+	if counts, err := CountByType(); err == nil {
+		stats.Counter.User = counts[USER_TYPE_INDIVIDUAL]
+		stats.Counter.Org = counts[USER_TYPE_ORGANIZATION]
+	} else {
+		stats.Counter.User = CountUsers()
+		stats.Counter.Org = CountOrganizations()
+	}
 	stats.Counter.PublicKey, _ = x.Count(new(PublicKey))
 	stats.Counter.Repo = CountRepositories(true)
 	stats.Counter.Watch, _ = x.Count(new(Watch))
@@ -215,7 +220,7 @@ func GetStatistic() (stats Statistic) {
 	stats.Counter.Issue, _ = x.Count(new(Issue))
 	stats.Counter.Comment, _ = x.Count(new(Comment))
 	stats.Counter.Oauth = 0
-	stats.Counter.Follow, _ = x.Count(new(Follow))
+	stats.Counter.Follow, _ = CountTotalFollows()
 	stats.Counter.Mirror, _ = x.Count(new(Mirror))
 	stats.Counter.Release, _ = x.Count(new(Release))
 	stats.Counter.LoginSource = CountLoginSources()
@@ -100,7 +100,7 @@ func CreateOrganization(org, owner *User) (err error) {
 		return err
 	}
 
-	isExist, err := IsUserExist(0, org.Name)
+	isExist, err := IsNameTaken(org.Name)
 	if err != nil {
 		return err
 	} else if isExist {
@@ -163,6 +163,139 @@ func CreateOrganization(org, owner *User) (err error) {
 	return sess.Commit()
 }
 
+// orgConversionBlocker returns the reason why user cannot be converted to organization
+// judged by its own record, it returns empty string if there is none.
+func orgConversionBlocker(u *User) string {
+	switch {
+	case u.IsOrganization():
+		return "already an organization"
+	case u.IsAdmin:
+		return "user is a site admin"
+	case u.LoginType == LOGIN_NOTYPE || u.LoginType == LOGIN_PLAIN:
+		return "user signs in with password"
+	case u.NumFollowers > 0 || u.NumFollowing > 0:
+		return "user has followers or follows others"
+	}
+	return ""
+}
+
+// ConvertToOrganization converts an individual user to organization with an owner team
+// which has access to all repositories of the user, given owner becomes the first member
+// of the team and can then add others. The user is detached from its login source and
+// can no longer sign in after conversion. Users who sign in with password, are site admins,
+// have follow relations, belong to organizations, have SSH keys or collaborate on repositories
+// cannot be converted.
+func ConvertToOrganization(owner, u *User) (err error) {
+	if reason := orgConversionBlocker(u); len(reason) > 0 {
+		return ErrUserNotConvertible{u.ID, reason}
+	} else if owner.ID == u.ID || owner.IsOrganization() {
+		return ErrUserNotConvertible{u.ID, "owner must be another individual user"}
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	for _, c := range []struct {
+		reason string
+		bean   interface{}
+		cond   string
+	}{
+		{"user belongs to organizations", new(OrgUser), "uid=?"},
+		{"user has SSH keys", new(PublicKey), "owner_id=?"},
+		{"user collaborates on repositories", new(Collaboration), "user_id=?"},
+	} {
+		count, err := sess.Where(c.cond, u.ID).Count(c.bean)
+		if err != nil {
+			return fmt.Errorf("count %T: %v", c.bean, err)
+		} else if count > 0 {
+			return ErrUserNotConvertible{u.ID, c.reason}
+		}
+	}
+
+	u.Type = USER_TYPE_ORGANIZATION
+	u.LoginType = LOGIN_NOTYPE
+	u.LoginSource = 0
+	u.LoginName = ""
+	u.Rands = GetUserSalt()
+	u.IsActive = true
+	u.NumTeams = 1
+	u.NumMembers = 1
+	if _, err = sess.Id(u.ID).Cols("type", "login_type", "login_source", "login_name",
+		"rands", "is_active", "num_teams", "num_members").UseBool("is_active").Update(u); err != nil {
+		return fmt.Errorf("update user: %v", err)
+	}
+
+	if _, err = sess.Insert(&OrgUser{
+		Uid:      owner.ID,
+		OrgID:    u.ID,
+		IsOwner:  true,
+		NumTeams: 1,
+	}); err != nil {
+		return fmt.Errorf("insert org-user relation: %v", err)
+	}
+
+	t := &Team{
+		OrgID:      u.ID,
+		LowerName:  strings.ToLower(OWNER_TEAM),
+		Name:       OWNER_TEAM,
+		Authorize:  ACCESS_MODE_OWNER,
+		NumMembers: 1,
+	}
+	if _, err = sess.Insert(t); err != nil {
+		return fmt.Errorf("insert owner team: %v", err)
+	}
+
+	if _, err = sess.Insert(&TeamUser{
+		Uid:    owner.ID,
+		OrgID:  u.ID,
+		TeamID: t.ID,
+	}); err != nil {
+		return fmt.Errorf("insert team-user relation: %v", err)
+	}
+
+	repos := make([]*Repository, 0, u.NumRepos)
+	if err = sess.Where("owner_id=?", u.ID).Find(&repos); err != nil {
+		return fmt.Errorf("find repositories: %v", err)
+	}
+	for _, repo := range repos {
+		if err = t.addRepository(sess, repo); err != nil {
+			return fmt.Errorf("addRepository [%d]: %v", repo.ID, err)
+		}
+	}
+
+	// Alternative emails, access tokens and external logins only belong to individuals.
+	if err = deleteBeans(sess,
+		&EmailAddress{UID: u.ID},
+		&AccessToken{UID: u.ID},
+		&ExternalLoginUser{UID: u.ID},
+	); err != nil {
+		return fmt.Errorf("deleteBeans: %v", err)
+	}
+
+	return sess.Commit()
+}
+
+// validateOrgRename checks if organization can be renamed to given name
+// judged by the name itself.
+func validateOrgRename(org *User, newName string) error {
+	if !org.IsOrganization() {
+		return ErrNotOrganization{org.ID}
+	}
+	return ValidateUserName(newName)
+}
+
+// ChangeOrgName changes name of organization, renames its directory and updates
+// names recorded in pull requests and actions. Change of letter case only is allowed.
+func ChangeOrgName(org *User, newName string) error {
+	if err := validateOrgRename(org, newName); err != nil {
+		return err
+	}
+	return changeName(org, newName)
+}
+
 // GetOrgByName returns organization by given name.
 func GetOrgByName(name string) (*User, error) {
 	if len(name) == 0 {
@@ -217,7 +350,11 @@ func DeleteOrganization(org *User) (err error) {
 		return fmt.Errorf("deleteUser: %v", err)
 	}
 
-	return sess.Commit()
+	if err = sess.Commit(); err != nil {
+		return err
+	}
+	org.removeFiles()
+	return nil
 }
 
 // ________                ____ ___
@@ -295,6 +432,28 @@ func GetOwnedOrgsByUserIDDesc(userID int64, desc string) ([]*User, error) {
 	return getOwnedOrgsByUserID(sess.Desc(desc), userID)
 }
 
+// transferableOrgs returns organizations that have not reached repository creation limit.
+func transferableOrgs(orgs []*User) []*User {
+	results := make([]*User, 0, len(orgs))
+	for _, org := range orgs {
+		if org.CanCreateRepo() {
+			results = append(results, org)
+		}
+	}
+	return results
+}
+
+// GetTransferableOrgsForUser returns organizations that given user can create repositories in,
+// which are candidate destinations to transfer repositories of the user.
+// Only owners can create repositories in an organization.
+func GetTransferableOrgsForUser(uid int64) ([]*User, error) {
+	orgs, err := GetOwnedOrgsByUserID(uid)
+	if err != nil {
+		return nil, fmt.Errorf("GetOwnedOrgsByUserID: %v", err)
+	}
+	return transferableOrgs(orgs), nil
+}
+
 // GetOrgUsersByUserID returns all organization-user relations by user ID.
 func GetOrgUsersByUserID(uid int64, all bool) ([]*OrgUser, error) {
 	ous := make([]*OrgUser, 0, 10)
@@ -314,6 +473,29 @@ func GetOrgUsersByOrgID(orgID int64) ([]*OrgUser, error) {
 	return ous, err
 }
 
+// RecountOrgMembers recalculates number of members of organization.
+func RecountOrgMembers(orgID int64) error {
+	_, err := x.Exec("UPDATE `user` SET num_members=(SELECT COUNT(*) FROM `org_user` WHERE org_id=?) WHERE id=?", orgID, orgID)
+	return err
+}
+
+// RecountOrgTeams recalculates number of teams of organization.
+func RecountOrgTeams(orgID int64) error {
+	_, err := x.Exec("UPDATE `user` SET num_teams=(SELECT COUNT(*) FROM `team` WHERE org_id=?) WHERE id=?", orgID, orgID)
+	return err
+}
+
+// CountOwnedOrganizations returns number of organizations owned by given user.
+func CountOwnedOrganizations(uid int64) (int64, error) {
+	return x.Where("uid=?", uid).And("is_owner=?", true).Count(new(OrgUser))
+}
+
+// CountJoinedOrganizations returns number of organizations given user
+// is member of but does not own.
+func CountJoinedOrganizations(uid int64) (int64, error) {
+	return x.Where("uid=?", uid).And("is_owner=?", false).Count(new(OrgUser))
+}
+
 // ChangeOrgUserStatus changes public or private membership status.
 func ChangeOrgUserStatus(orgID, uid int64, public bool) error {
 	ou := new(OrgUser)
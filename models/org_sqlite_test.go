@@ -0,0 +1,172 @@
+// +build sqlite
+
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CountOrganizationsByRole(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Owned and joined organizations partition all memberships", t, func() {
+		_, err := x.Insert(
+			&OrgUser{Uid: 1, OrgID: 10, IsOwner: true},
+			&OrgUser{Uid: 1, OrgID: 11, IsOwner: true},
+			&OrgUser{Uid: 1, OrgID: 12},
+			&OrgUser{Uid: 2, OrgID: 10},
+		)
+		So(err, ShouldBeNil)
+
+		owned, err := CountOwnedOrganizations(1)
+		So(err, ShouldBeNil)
+		So(owned, ShouldEqual, 2)
+		joined, err := CountJoinedOrganizations(1)
+		So(err, ShouldBeNil)
+		So(joined, ShouldEqual, 1)
+		total, err := x.Where("uid=?", 1).Count(new(OrgUser))
+		So(err, ShouldBeNil)
+		So(owned+joined, ShouldEqual, total)
+
+		owned, err = CountOwnedOrganizations(2)
+		So(err, ShouldBeNil)
+		So(owned, ShouldEqual, 0)
+		joined, err = CountJoinedOrganizations(2)
+		So(err, ShouldBeNil)
+		So(joined, ShouldEqual, 1)
+	})
+}
+
+func Test_RecountOrgMembersAndTeams(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Recount corrupted numbers of members and teams", t, func() {
+		org := &User{Name: "org", LowerName: "org", Type: USER_TYPE_ORGANIZATION, NumMembers: 7, NumTeams: 5}
+		_, err := x.Insert(org)
+		So(err, ShouldBeNil)
+		_, err = x.Insert(
+			&OrgUser{Uid: 1, OrgID: org.ID, IsOwner: true},
+			&OrgUser{Uid: 2, OrgID: org.ID},
+			&OrgUser{Uid: 1, OrgID: org.ID + 1},
+			&Team{OrgID: org.ID, LowerName: "owners", Name: "Owners"},
+			&Team{OrgID: org.ID + 1, LowerName: "owners", Name: "Owners"},
+		)
+		So(err, ShouldBeNil)
+
+		So(RecountOrgMembers(org.ID), ShouldBeNil)
+		So(RecountOrgTeams(org.ID), ShouldBeNil)
+		u, err := GetUserByID(org.ID)
+		So(err, ShouldBeNil)
+		So(u.NumMembers, ShouldEqual, 2)
+		So(u.NumTeams, ShouldEqual, 1)
+	})
+}
+
+func Test_GetUserTeams(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Return teams that user belongs to in given organization", t, func() {
+		core := &Team{OrgID: 1, Name: "Core", LowerName: "core"}
+		docs := &Team{OrgID: 1, Name: "Docs", LowerName: "docs"}
+		infra := &Team{OrgID: 1, Name: "Infra", LowerName: "infra"}
+		other := &Team{OrgID: 2, Name: "Other", LowerName: "other"}
+		_, err := x.Insert(core, docs, infra, other)
+		So(err, ShouldBeNil)
+		_, err = x.Insert(
+			&TeamUser{OrgID: 1, TeamID: core.ID, Uid: 10},
+			&TeamUser{OrgID: 1, TeamID: docs.ID, Uid: 10},
+			&TeamUser{OrgID: 1, TeamID: infra.ID, Uid: 11},
+			&TeamUser{OrgID: 2, TeamID: other.ID, Uid: 10},
+		)
+		So(err, ShouldBeNil)
+
+		teams, err := GetUserTeams(1, 10)
+		So(err, ShouldBeNil)
+		So(teams, ShouldHaveLength, 2)
+		So(teams[0].Name, ShouldEqual, "Core")
+		So(teams[1].Name, ShouldEqual, "Docs")
+
+		teams, err = GetUserTeams(2, 11)
+		So(err, ShouldBeNil)
+		So(teams, ShouldBeEmpty)
+	})
+}
+
+func Test_ChangeOrgName(t *testing.T) {
+	setTestEngine(t)
+	defer setTestDirs(t)()
+
+	Convey("Rename organization with its directory and recorded names", t, func() {
+		org := &User{Name: "gogs", LowerName: "gogs", Type: USER_TYPE_ORGANIZATION}
+		user := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com"}
+		_, err := x.Insert(org, user)
+		So(err, ShouldBeNil)
+		_, err = x.Insert(
+			&Action{RepoUserName: "gogs", RepoName: "gogs"},
+			&PullRequest{HeadUserName: "gogs"},
+		)
+		So(err, ShouldBeNil)
+		So(os.MkdirAll(UserPath("gogs"), os.ModePerm), ShouldBeNil)
+
+		So(ChangeOrgName(org, "gogits"), ShouldBeNil)
+		o, err := GetOrgByName("gogits")
+		So(err, ShouldBeNil)
+		So(o.ID, ShouldEqual, org.ID)
+		_, err = os.Stat(UserPath("gogits"))
+		So(err, ShouldBeNil)
+		has, err := x.Get(&Action{RepoUserName: "gogits"})
+		So(err, ShouldBeNil)
+		So(has, ShouldBeTrue)
+		has, err = x.Get(&PullRequest{HeadUserName: "gogits"})
+		So(err, ShouldBeNil)
+		So(has, ShouldBeTrue)
+
+		So(ChangeOrgName(org, "Gogits"), ShouldBeNil)
+		So(org.Name, ShouldEqual, "Gogits")
+
+		So(IsErrUserAlreadyExist(ChangeOrgName(org, "alice")), ShouldBeTrue)
+		So(org.Name, ShouldEqual, "Gogits")
+		So(IsErrNotOrganization(ChangeOrgName(user, "bob")), ShouldBeTrue)
+	})
+}
+
+func Test_ConvertToOrganization(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Converted organization has given owner in its owner team", t, func() {
+		admin := &User{Name: "admin", LowerName: "admin", Email: "admin@example.com", IsAdmin: true}
+		u := &User{Name: "team", LowerName: "team", Email: "team@example.com", LoginType: LOGIN_LDAP, LoginSource: 1, NumRepos: 1}
+		_, err := x.Insert(admin, u)
+		So(err, ShouldBeNil)
+		repo := &Repository{OwnerID: u.ID, Name: "project", LowerName: "project"}
+		_, err = x.Insert(repo)
+		So(err, ShouldBeNil)
+
+		So(IsErrUserNotConvertible(ConvertToOrganization(u, u)), ShouldBeTrue)
+		So(ConvertToOrganization(admin, u), ShouldBeNil)
+
+		org, err := GetUserByID(u.ID)
+		So(err, ShouldBeNil)
+		So(org.IsOrganization(), ShouldBeTrue)
+		So(org.NumTeams, ShouldEqual, 1)
+		So(org.NumMembers, ShouldEqual, 1)
+		So(org.IsOwnedBy(admin.ID), ShouldBeTrue)
+
+		team, err := org.GetOwnerTeam()
+		So(err, ShouldBeNil)
+		So(team.NumMembers, ShouldEqual, 1)
+		So(team.NumRepos, ShouldEqual, 1)
+		So(team.IsMember(admin.ID), ShouldBeTrue)
+
+		mode, err := AccessLevel(admin, repo)
+		So(err, ShouldBeNil)
+		So(mode, ShouldEqual, ACCESS_MODE_OWNER)
+	})
+}
@@ -270,6 +270,39 @@ func GetTeamByID(teamId int64) (*Team, error) {
 	return getTeamByID(x, teamId)
 }
 
+// parseMention splits mention into owner name and team name,
+// team name is empty if it does not mention a team.
+func parseMention(name string) (owner, team string) {
+	name = strings.TrimPrefix(strings.TrimSpace(name), "@")
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return name, ""
+}
+
+// ResolveMention resolves mention to a user or a team, exactly one of them is returned.
+// Mention in form of "org/team" is the most specific and always resolves to the team,
+// any other mention resolves to the user or organization of the name.
+func ResolveMention(name string) (*User, *Team, error) {
+	owner, teamName := parseMention(name)
+	u, err := GetUserByName(owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(teamName) == 0 {
+		return u, nil, nil
+	}
+
+	if !u.IsOrganization() {
+		return nil, nil, ErrTeamNotExist
+	}
+	t, err := GetTeam(u.ID, teamName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nil, t, nil
+}
+
 // UpdateTeam updates information of team.
 func UpdateTeam(t *Team, authChanged bool) (err error) {
 	if len(t.Name) == 0 {
@@ -404,23 +437,9 @@ func GetTeamMembers(teamID int64) ([]*User, error) {
 }
 
 func getUserTeams(e Engine, orgId, uid int64) ([]*Team, error) {
-	tus := make([]*TeamUser, 0, 5)
-	if err := e.Where("uid=?", uid).And("org_id=?", orgId).Find(&tus); err != nil {
-		return nil, err
-	}
-
-	ts := make([]*Team, len(tus))
-	for i, tu := range tus {
-		t := new(Team)
-		has, err := e.Id(tu.TeamID).Get(t)
-		if err != nil {
-			return nil, err
-		} else if !has {
-			return nil, ErrTeamNotExist
-		}
-		ts[i] = t
-	}
-	return ts, nil
+	ts := make([]*Team, 0, 5)
+	return ts, e.Where("team_user.org_id=?", orgId).And("team_user.uid=?", uid).
+		Join("INNER", "team_user", "team_user.team_id=team.id").Asc("team.id").Find(&ts)
 }
 
 // GetUserTeams returns all teams that user belongs to in given organization.
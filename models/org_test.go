@@ -0,0 +1,62 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func Test_transferableOrgs(t *testing.T) {
+	Convey("Organizations reached creation limit are not transfer targets", t, func() {
+		setting.Repository.MaxCreationLimit = -1
+
+		unlimited := &User{ID: 1, MaxRepoCreation: -1, NumRepos: 100}
+		limited := &User{ID: 2, MaxRepoCreation: 5, NumRepos: 4}
+		full := &User{ID: 3, MaxRepoCreation: 5, NumRepos: 5}
+		So(transferableOrgs([]*User{unlimited, limited, full}), ShouldResemble, []*User{unlimited, limited})
+	})
+}
+
+func Test_parseMention(t *testing.T) {
+	Convey("Team mention is distinguished from user mention", t, func() {
+		owner, team := parseMention("@gogs/owners")
+		So(owner, ShouldEqual, "gogs")
+		So(team, ShouldEqual, "owners")
+
+		owner, team = parseMention("unknwon")
+		So(owner, ShouldEqual, "unknwon")
+		So(team, ShouldBeEmpty)
+	})
+}
+
+func Test_orgConversionBlocker(t *testing.T) {
+	Convey("Only plain individual users without password login can be converted", t, func() {
+		So(orgConversionBlocker(&User{ID: 1, LoginType: LOGIN_LDAP}), ShouldBeEmpty)
+		So(orgConversionBlocker(&User{ID: 1}), ShouldNotBeEmpty)
+		So(orgConversionBlocker(&User{ID: 1, LoginType: LOGIN_PLAIN}), ShouldNotBeEmpty)
+		So(orgConversionBlocker(&User{ID: 1, LoginType: LOGIN_LDAP, Type: USER_TYPE_ORGANIZATION}), ShouldNotBeEmpty)
+		So(orgConversionBlocker(&User{ID: 1, LoginType: LOGIN_LDAP, IsAdmin: true}), ShouldNotBeEmpty)
+		So(orgConversionBlocker(&User{ID: 1, LoginType: LOGIN_LDAP, NumFollowers: 1}), ShouldNotBeEmpty)
+
+		err := ConvertToOrganization(&User{ID: 2}, &User{ID: 1, IsAdmin: true})
+		So(IsErrUserNotConvertible(err), ShouldBeTrue)
+	})
+}
+
+func Test_validateOrgRename(t *testing.T) {
+	Convey("Only organization can be renamed to a legal name", t, func() {
+		org := &User{ID: 1, Name: "gogs", Type: USER_TYPE_ORGANIZATION}
+		So(validateOrgRename(org, "gogits"), ShouldBeNil)
+		So(validateOrgRename(org, "Gogs"), ShouldBeNil)
+		So(validateOrgRename(org, "bad name"), ShouldEqual, ErrUserNameIllegal)
+		So(IsErrNameReserved(validateOrgRename(org, "admin")), ShouldBeTrue)
+
+		So(IsErrNotOrganization(validateOrgRename(&User{ID: 2}, "gogits")), ShouldBeTrue)
+	})
+}
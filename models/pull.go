@@ -109,7 +109,7 @@ func (pr *PullRequest) GetMerger() (err error) {
 		return nil
 	}
 
-	pr.Merger, err = GetUserByID(pr.MergerID)
+	pr.Merger, err = GetUserByIDIncludingDeleted(pr.MergerID)
 	if IsErrUserNotExist(err) {
 		pr.MergerID = -1
 		pr.Merger = NewFakeUser()
@@ -691,6 +691,10 @@ func MigrateRepository(u *User, opts MigrateRepoOptions) (*Repository, error) {
 		return repo, fmt.Errorf("Clone: %v", err)
 	}
 
+	if err = UpdateUserStorageUsage(u.ID); err != nil {
+		log.Error(4, "UpdateUserStorageUsage [%d]: %v", u.ID, err)
+	}
+
 	// Check if repository is empty.
 	_, stderr, err := com.ExecCmdDir(repoPath, "git", "log", "-1")
 	if err != nil {
@@ -971,6 +975,8 @@ func createRepository(e *xorm.Session, u *User, repo *Repository) (err error) {
 func CreateRepository(u *User, opts CreateRepoOptions) (_ *Repository, err error) {
 	if !u.CanCreateRepo() {
 		return nil, ErrReachLimitOfRepo{u.MaxRepoCreation}
+	} else if !u.HasStorageQuotaRemaining(0) {
+		return nil, ErrUserStorageQuotaExceeded{u.ID, u.StorageQuota()}
 	}
 
 	repo := &Repository{
@@ -1165,6 +1171,9 @@ func TransferOwnership(u *User, newOwnerName string, repo *Repository) error {
 		return fmt.Errorf("increase new owner repository count: %v", err)
 	} else if _, err = sess.Exec("UPDATE `user` SET num_repos=num_repos-1 WHERE id=?", owner.ID); err != nil {
 		return fmt.Errorf("decrease old owner repository count: %v", err)
+	} else if _, err = sess.Exec("UPDATE `user` SET profile_repo='' WHERE id=? AND LOWER(profile_repo)=?",
+		owner.ID, repo.LowerName); err != nil {
+		return fmt.Errorf("clear profile repository of old owner: %v", err)
 	}
 
 	if err = watchRepo(sess, newOwner.ID, repo.ID, true); err != nil {
@@ -1194,6 +1203,7 @@ func TransferOwnership(u *User, newOwnerName string, repo *Repository) error {
 
 // ChangeRepositoryName changes all corresponding setting from old repository name to new one.
 func ChangeRepositoryName(u *User, oldRepoName, newRepoName string) (err error) {
+	newName := newRepoName
 	oldRepoName = strings.ToLower(oldRepoName)
 	newRepoName = strings.ToLower(newRepoName)
 	if err = IsUsableRepoName(newRepoName); err != nil {
@@ -1225,6 +1235,14 @@ func ChangeRepositoryName(u *User, oldRepoName, newRepoName string) (err error)
 		RemoveAllWithNotice("Delete repository wiki local copy", repo.LocalWikiPath())
 	}
 
+	// Keep README of renamed repository shown on profile page.
+	if strings.ToLower(u.ProfileRepo) == oldRepoName {
+		u.ProfileRepo = newName
+	}
+	if _, err = x.Exec("UPDATE `user` SET profile_repo=? WHERE id=? AND LOWER(profile_repo)=?",
+		newName, u.ID, oldRepoName); err != nil {
+		return fmt.Errorf("update profile repository: %v", err)
+	}
 	return nil
 }
 
@@ -1404,6 +1422,9 @@ func DeleteRepository(uid, repoID int64) error {
 		}
 	}
 
+	if err = UpdateUserStorageUsage(uid); err != nil {
+		log.Error(4, "UpdateUserStorageUsage [%d]: %v", uid, err)
+	}
 	return nil
 }
 
@@ -1655,6 +1676,7 @@ const (
 	_MIRROR_UPDATE = "mirror_update"
 	_GIT_FSCK      = "git_fsck"
 	_CHECK_REPOs   = "check_repos"
+	_REAP_USERS    = "reap_users"
 )
 
 // MirrorUpdate checks and updates mirror repositories.
@@ -1812,6 +1834,18 @@ func CheckRepoStats() {
 			"UPDATE `issue` SET num_comments=(SELECT COUNT(*) FROM `comment` WHERE issue_id=? AND type=0) WHERE id=?",
 			"issue count 'num_comments'",
 		},
+		// Organization.NumMembers
+		{
+			"SELECT `user`.id FROM `user` WHERE `user`.type=1 AND `user`.num_members!=(SELECT COUNT(*) FROM `org_user` WHERE org_id=`user`.id)",
+			"UPDATE `user` SET num_members=(SELECT COUNT(*) FROM `org_user` WHERE org_id=?) WHERE id=?",
+			"organization count 'num_members'",
+		},
+		// Organization.NumTeams
+		{
+			"SELECT `user`.id FROM `user` WHERE `user`.type=1 AND `user`.num_teams!=(SELECT COUNT(*) FROM `team` WHERE org_id=`user`.id)",
+			"UPDATE `user` SET num_teams=(SELECT COUNT(*) FROM `team` WHERE org_id=?) WHERE id=?",
+			"organization count 'num_teams'",
+		},
 	}
 	for i := range checkers {
 		repoStatsCheck(checkers[i])
@@ -2109,6 +2143,10 @@ func HasForkedRepo(ownerID, repoID int64) (*Repository, bool) {
 }
 
 func ForkRepository(u *User, oldRepo *Repository, name, desc string) (_ *Repository, err error) {
+	if !u.HasStorageQuotaRemaining(0) {
+		return nil, ErrUserStorageQuotaExceeded{u.ID, u.StorageQuota()}
+	}
+
 	repo := &Repository{
 		OwnerID:       u.ID,
 		Owner:         u,
@@ -2154,7 +2192,14 @@ func ForkRepository(u *User, oldRepo *Repository, name, desc string) (_ *Reposit
 		return nil, fmt.Errorf("createUpdateHook: %v", err)
 	}
 
-	return repo, sess.Commit()
+	if err = sess.Commit(); err != nil {
+		return nil, err
+	}
+
+	if err = UpdateUserStorageUsage(u.ID); err != nil {
+		log.Error(4, "UpdateUserStorageUsage [%d]: %v", u.ID, err)
+	}
+	return repo, nil
 }
 
 func (repo *Repository) GetForks() ([]*Repository, error) {
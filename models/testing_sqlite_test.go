@@ -0,0 +1,65 @@
+// +build sqlite
+
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-xorm/core"
+	"github.com/go-xorm/xorm"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+var numTestEngines int
+
+// setTestEngine replaces engine with a new empty in-memory SQLite database
+// that has all tables. Tests using it are only built with tag "sqlite" because
+// the driver requires cgo, run them by "go test -tags sqlite ./models".
+func setTestEngine(t testing.TB) {
+	numTestEngines++
+	engine, err := xorm.NewEngine("sqlite3", fmt.Sprintf("file:gogs_test_%d?mode=memory&cache=shared", numTestEngines))
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+
+	engine.SetMapper(core.GonicMapper{})
+	if err = engine.StoreEngine("InnoDB").Sync2(tables...); err != nil {
+		t.Fatalf("Sync2: %v", err)
+	}
+	x = engine
+}
+
+// countQueries returns number of SQL statements executed by engine during fn.
+func countQueries(fn func()) int {
+	buf := new(bytes.Buffer)
+	x.SetLogger(xorm.NewSimpleLogger(buf))
+	x.ShowSQL(true)
+	defer x.ShowSQL(false)
+
+	fn()
+	return strings.Count(buf.String(), "[sql]")
+}
+
+// setTestDirs points repository and SSH root paths to a new temporary directory,
+// the returned function removes it.
+func setTestDirs(tb testing.TB) func() {
+	root, err := ioutil.TempDir("", "gogs-test")
+	if err != nil {
+		tb.Fatalf("TempDir: %v", err)
+	}
+	setting.RepoRootPath = root
+	setting.SSH.RootPath = root
+	return func() {
+		os.RemoveAll(root)
+	}
+}
@@ -5,6 +5,7 @@
 package models
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/go-xorm/xorm"
@@ -15,10 +16,11 @@ import (
 
 // AccessToken represents a personal access token.
 type AccessToken struct {
-	ID   int64 `xorm:"pk autoincr"`
-	UID  int64 `xorm:"INDEX"`
-	Name string
-	Sha1 string `xorm:"UNIQUE VARCHAR(40)"`
+	ID    int64 `xorm:"pk autoincr"`
+	UID   int64 `xorm:"INDEX"`
+	Name  string
+	Sha1  string `xorm:"UNIQUE VARCHAR(40)"` // SHA1 hash of token
+	Token string `xorm:"-"`                  // Plaintext token, only available on creation
 
 	Created           time.Time `xorm:"-"`
 	CreatedUnix       int64
@@ -47,30 +49,57 @@ func (t *AccessToken) AfterSet(colName string, _ xorm.Cell) {
 	}
 }
 
-// NewAccessToken creates new access token.
+// hashAccessToken returns the hash of token to be stored in database.
+func hashAccessToken(token string) string {
+	return base.EncodeSha1(token)
+}
+
+// generateAccessToken generates a new random plaintext token and its hash.
+func generateAccessToken(t *AccessToken) {
+	t.Token = base.EncodeSha1(gouuid.NewV4().String())
+	t.Sha1 = hashAccessToken(t.Token)
+}
+
+// NewAccessToken creates new access token, only hash of the token is stored
+// and plaintext token is available through t.Token once.
 func NewAccessToken(t *AccessToken) error {
-	t.Sha1 = base.EncodeSha1(gouuid.NewV4().String())
+	generateAccessToken(t)
 	_, err := x.Insert(t)
 	return err
 }
 
-// GetAccessTokenBySHA returns access token by given sha1.
-func GetAccessTokenBySHA(sha string) (*AccessToken, error) {
-	if sha == "" {
+// GetAccessTokenBySHA returns access token by given plaintext token.
+func GetAccessTokenBySHA(token string) (*AccessToken, error) {
+	if token == "" {
 		return nil, ErrAccessTokenEmpty{}
 	}
-	t := &AccessToken{Sha1: sha}
+	t := &AccessToken{Sha1: hashAccessToken(token)}
 	has, err := x.Get(t)
 	if err != nil {
 		return nil, err
 	} else if !has {
-		return nil, ErrAccessTokenNotExist{sha}
+		return nil, ErrAccessTokenNotExist{t.Sha1}
 	}
 	return t, nil
 }
 
-// ListAccessTokens returns a list of access tokens belongs to given user.
-func ListAccessTokens(uid int64) ([]*AccessToken, error) {
+// GetUserByAccessToken returns the owner of given plaintext token
+// and records usage of the token.
+func GetUserByAccessToken(token string) (*User, error) {
+	t, err := GetAccessTokenBySHA(token)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Updated = time.Now()
+	if err = UpdateAccessToken(t); err != nil {
+		return nil, fmt.Errorf("UpdateAccessToken: %v", err)
+	}
+	return GetUserByID(t.UID)
+}
+
+// GetAccessTokens returns a list of access tokens belongs to given user.
+func GetAccessTokens(uid int64) ([]*AccessToken, error) {
 	tokens := make([]*AccessToken, 0, 5)
 	return tokens, x.Where("uid=?", uid).Desc("id").Find(&tokens)
 }
@@ -86,3 +115,9 @@ func DeleteAccessTokenByID(id int64) error {
 	_, err := x.Id(id).Delete(new(AccessToken))
 	return err
 }
+
+// DeleteAccessToken deletes access token by given ID only if it belongs to given user.
+func DeleteAccessToken(uid, id int64) error {
+	_, err := x.Where("id=?", id).And("uid=?", uid).Delete(new(AccessToken))
+	return err
+}
@@ -0,0 +1,44 @@
+// +build sqlite
+
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_AccessTokenRoundTrip(t *testing.T) {
+	setTestEngine(t)
+	defer setTestDirs(t)()
+
+	Convey("Look up user by plaintext token that is only stored hashed", t, func() {
+		u := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com"}
+		_, err := x.Insert(u)
+		So(err, ShouldBeNil)
+
+		token := &AccessToken{UID: u.ID, Name: "ci"}
+		So(NewAccessToken(token), ShouldBeNil)
+		So(token.Token, ShouldNotBeEmpty)
+
+		stored := new(AccessToken)
+		has, err := x.Id(token.ID).Get(stored)
+		So(err, ShouldBeNil)
+		So(has, ShouldBeTrue)
+		So(stored.Sha1, ShouldNotEqual, token.Token)
+
+		owner, err := GetUserByAccessToken(token.Token)
+		So(err, ShouldBeNil)
+		So(owner.ID, ShouldEqual, u.ID)
+		_, err = GetUserByAccessToken(stored.Sha1)
+		So(IsErrAccessTokenNotExist(err), ShouldBeTrue)
+
+		So(DeleteUser(u), ShouldBeNil)
+		_, err = GetAccessTokenBySHA(token.Token)
+		So(IsErrAccessTokenNotExist(err), ShouldBeTrue)
+	})
+}
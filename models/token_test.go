@@ -0,0 +1,25 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_generateAccessToken(t *testing.T) {
+	Convey("Only hash of plaintext token is stored", t, func() {
+		token := new(AccessToken)
+		generateAccessToken(token)
+		So(token.Token, ShouldHaveLength, 40)
+		So(token.Sha1, ShouldNotEqual, token.Token)
+		So(token.Sha1, ShouldEqual, hashAccessToken(token.Token))
+
+		other := new(AccessToken)
+		generateAccessToken(other)
+		So(other.Token, ShouldNotEqual, token.Token)
+	})
+}
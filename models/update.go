@@ -113,6 +113,10 @@ func PushUpdate(opts PushUpdateOptions) (err error) {
 		return fmt.Errorf("GetRepositoryByName: %v", err)
 	}
 
+	if err = UpdateUserStorageUsage(repoUser.ID); err != nil {
+		log.Error(4, "UpdateUserStorageUsage [%d]: %v", repoUser.ID, err)
+	}
+
 	// Push tags.
 	if strings.HasPrefix(opts.RefName, "refs/tags/") {
 		tag, err := gitRepo.GetTag(git.RefEndName(opts.RefName))
@@ -8,21 +8,36 @@ import (
 	"bytes"
 	"container/list"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/hex"
+	"encoding/xml"
 	"errors"
 	"fmt"
 	"image"
+	"image/draw"
+	"image/gif"
 	_ "image/jpeg"
 	"image/png"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 	"unicode/utf8"
 
 	"github.com/Unknwon/com"
+	"github.com/go-macaron/binding"
 	"github.com/go-xorm/xorm"
 	"github.com/nfnt/resize"
+	"golang.org/x/crypto/bcrypt"
 
 	"github.com/gogits/git-module"
 
@@ -56,7 +71,8 @@ type User struct {
 	LowerName string `xorm:"UNIQUE NOT NULL"`
 	Name      string `xorm:"UNIQUE NOT NULL"`
 	FullName  string
-	// Email is the primary email address (to be used for communication)
+	// Email is the primary email address (to be used for communication),
+	// it is always lower-cased on write so uniqueness check is case-insensitive.
 	Email       string `xorm:"NOT NULL"`
 	Passwd      string `xorm:"NOT NULL"`
 	LoginType   LoginType
@@ -70,14 +86,33 @@ type User struct {
 	Website     string
 	Rands       string `xorm:"VARCHAR(10)"`
 	Salt        string `xorm:"VARCHAR(10)"`
+	// Empty means legacy PBKDF2 hash, see PASSWD_HASH_BCRYPT
+	PasswdHashAlgo string
+	// SessionEpoch is increased whenever all existing sessions should be invalidated
+	SessionEpoch int `xorm:"NOT NULL DEFAULT 0"`
+	// Maximum session lifetime in seconds, 0 means use global default
+	SessionMaxLifetime int `xorm:"NOT NULL DEFAULT 0"`
 
 	Created     time.Time `xorm:"-"`
 	CreatedUnix int64
 	Updated     time.Time `xorm:"-"`
 	UpdatedUnix int64
+	// Zero means the user has never signed in
+	LastLogin     time.Time `xorm:"-"`
+	LastLoginUnix int64     `xorm:"INDEX"`
+	// Consecutive failed sign in attempts, see RecordFailedLogin
+	NumFailedLogins int       `xorm:"NOT NULL DEFAULT 0"`
+	LockedUntil     time.Time `xorm:"-"`
+	LockedUntilUnix int64     `xorm:"NOT NULL DEFAULT 0"`
+	// Soft-deleted user keeps its row until reaped, see MarkUserDeleted
+	IsDeleted   bool      `xorm:"NOT NULL DEFAULT false"`
+	Deleted     time.Time `xorm:"-"`
+	DeletedUnix int64     `xorm:"INDEX NOT NULL DEFAULT 0"`
 
 	// Remember visibility choice for convenience, true for private
 	LastRepoVisibility bool
+	// Personal default visibility of new repository, true for private
+	DefaultRepoPrivate bool
 	// Maximum repository creation limit, -1 means use gloabl default
 	MaxRepoCreation int `xorm:"NOT NULL DEFAULT -1"`
 
@@ -87,6 +122,23 @@ type User struct {
 	AllowGitHook     bool
 	AllowImportLocal bool // Allow migrate repository by local path
 	ProhibitLogin    bool
+	// Restricted user is only visible to admins and members of same organizations
+	IsRestricted bool
+	// Force user to change password after next sign in
+	MustChangePassword bool
+
+	// Privacy
+	HideEmail      bool // Use no-reply address in Git commits made through web
+	HideEmailOnWeb bool // Do not show primary email on profile pages
+
+	// Empty means use instance default, see UserThemes
+	Theme string
+
+	// Name of public repository whose README is shown on profile page
+	ProfileRepo string
+
+	// Phone number for SMS verification, encrypted with secret key, see SetUserPhone
+	Phone string `xorm:"TEXT"`
 
 	// Avatar
 	Avatar          string `xorm:"VARCHAR(2048) NOT NULL"`
@@ -99,12 +151,19 @@ type User struct {
 	NumStars     int
 	NumRepos     int
 
+	// Storage, in bytes
+	UsedStorage int64 `xorm:"NOT NULL DEFAULT 0"` // Cached value, see UpdateUserStorageUsage
+	MaxStorage  int64 `xorm:"NOT NULL DEFAULT 0"` // 0 means use global default
+
 	// For organization
 	Description string
 	NumTeams    int
 	NumMembers  int
 	Teams       []*Team `xorm:"-"`
 	Members     []*User `xorm:"-"`
+
+	// MatchedEmail is the e-mail address matched by SearchUsersByEmailFragment.
+	MatchedEmail string `xorm:"-"`
 }
 
 func (u *User) BeforeInsert() {
@@ -127,6 +186,18 @@ func (u *User) AfterSet(colName string, _ xorm.Cell) {
 		u.Created = time.Unix(u.CreatedUnix, 0).Local()
 	case "updated_unix":
 		u.Updated = time.Unix(u.UpdatedUnix, 0).Local()
+	case "last_login_unix":
+		if u.LastLoginUnix > 0 {
+			u.LastLogin = time.Unix(u.LastLoginUnix, 0).Local()
+		}
+	case "locked_until_unix":
+		if u.LockedUntilUnix > 0 {
+			u.LockedUntil = time.Unix(u.LockedUntilUnix, 0).Local()
+		}
+	case "deleted_unix":
+		if u.DeletedUnix > 0 {
+			u.Deleted = time.Unix(u.DeletedUnix, 0).Local()
+		}
 	}
 }
 
@@ -168,6 +239,34 @@ func (u *User) CanImportLocal() bool {
 	return u.IsAdmin || u.AllowImportLocal
 }
 
+// UserPermissions is a snapshot of effective permissions of a user.
+type UserPermissions struct {
+	IsAdmin               bool
+	IsRestricted          bool
+	IsSuspended           bool // Login is prohibited
+	CanCreateRepo         bool
+	CanCreateOrganization bool
+	CanEditGitHook        bool
+	CanImportLocal        bool
+}
+
+// Permissions returns effective permissions of user computed at the time of call,
+// suspended users cannot create anything.
+func (u *User) Permissions() UserPermissions {
+	perms := UserPermissions{
+		IsAdmin:        u.IsAdmin,
+		IsRestricted:   u.IsRestricted,
+		IsSuspended:    u.ProhibitLogin,
+		CanEditGitHook: u.CanEditGitHook(),
+		CanImportLocal: u.CanImportLocal(),
+	}
+	if !perms.IsSuspended {
+		perms.CanCreateRepo = u.CanCreateRepo()
+		perms.CanCreateOrganization = !u.IsOrganization()
+	}
+	return perms
+}
+
 // DashboardLink returns the user dashboard page link.
 func (u *User) DashboardLink() string {
 	if u.IsOrganization() {
@@ -202,6 +301,21 @@ func (u *User) CustomAvatarPath() string {
 	return filepath.Join(setting.AvatarUploadPath, com.ToStr(u.ID))
 }
 
+// CustomAvatarDataURL returns custom avatar of user embedded as base64 data URL,
+// so it can be included in self-contained exports. It returns empty string
+// if user does not use custom avatar.
+func (u *User) CustomAvatarDataURL() (string, error) {
+	if !u.UseCustomAvatar {
+		return "", nil
+	}
+
+	data, err := ioutil.ReadFile(u.CustomAvatarPath())
+	if err != nil {
+		return "", fmt.Errorf("ReadFile: %v", err)
+	}
+	return "data:" + http.DetectContentType(data) + ";base64," + base64.StdEncoding.EncodeToString(data), nil
+}
+
 // GenerateRandomAvatar generates a random avatar for user.
 func (u *User) GenerateRandomAvatar() error {
 	seed := u.Email
@@ -216,22 +330,103 @@ func (u *User) GenerateRandomAvatar() error {
 	if err = os.MkdirAll(filepath.Dir(u.CustomAvatarPath()), os.ModePerm); err != nil {
 		return fmt.Errorf("MkdirAll: %v", err)
 	}
-	fw, err := os.Create(u.CustomAvatarPath())
-	if err != nil {
-		return fmt.Errorf("Create: %v", err)
+	if err = writeAvatarFile(u.CustomAvatarPath(), func(w io.Writer) error {
+		return png.Encode(w, img)
+	}); err != nil {
+		return fmt.Errorf("writeAvatarFile: %v", err)
+	}
+
+	log.Info("New random avatar created: %d", u.ID)
+	return nil
+}
+
+// nameInitials returns upper-cased first letters of first and last word of name.
+func nameInitials(name string) string {
+	words := strings.Fields(name)
+	if len(words) == 0 {
+		return ""
 	}
-	defer fw.Close()
 
-	if err = png.Encode(fw, img); err != nil {
-		return fmt.Errorf("Encode: %v", err)
+	initials := []rune{[]rune(words[0])[0]}
+	if len(words) > 1 {
+		initials = append(initials, []rune(words[len(words)-1])[0])
 	}
+	return strings.ToUpper(string(initials))
+}
 
-	log.Info("New random avatar created: %d", u.ID)
+// GenerateInitialsAvatar returns PNG image of initials of user's display name
+// on a background color derived from user ID.
+func GenerateInitialsAvatar(u *User) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := png.Encode(buf, avatar.InitialsImage(nameInitials(u.DisplayName()), u.ID)); err != nil {
+		return nil, fmt.Errorf("encode: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// generateDefaultAvatar generates avatar of user when Gravatar is disabled,
+// it is the initials avatar if configured and random avatar otherwise.
+func (u *User) generateDefaultAvatar() error {
+	if !setting.InitialsAvatar {
+		return u.GenerateRandomAvatar()
+	}
+
+	data, err := GenerateInitialsAvatar(u)
+	if err != nil {
+		return fmt.Errorf("GenerateInitialsAvatar: %v", err)
+	}
+	if err = os.MkdirAll(filepath.Dir(u.CustomAvatarPath()), os.ModePerm); err != nil {
+		return fmt.Errorf("MkdirAll: %v", err)
+	}
+	if err = writeAvatarFile(u.CustomAvatarPath(), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}); err != nil {
+		return fmt.Errorf("writeAvatarFile: %v", err)
+	}
+
+	log.Info("New initials avatar created: %d", u.ID)
 	return nil
 }
 
+// writeAvatarFile writes avatar file through a temporary file in the same directory
+// and renames it into place, so readers never see a partially written file.
+func writeAvatarFile(path string, write func(io.Writer) error) (err error) {
+	fw, err := ioutil.TempFile(filepath.Dir(path), ".tmp-avatar-")
+	if err != nil {
+		return fmt.Errorf("TempFile: %v", err)
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(fw.Name())
+		}
+	}()
+
+	if err = write(fw); err != nil {
+		fw.Close()
+		return err
+	} else if err = fw.Close(); err != nil {
+		return err
+	} else if err = os.Chmod(fw.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(fw.Name(), path)
+}
+
+const _BUILTIN_DEFAULT_AVATAR = "/img/avatar_default.png"
+
+// DefaultAvatarLink returns relative link of default avatar configured for the instance,
+// it falls back to built-in default avatar when none is configured. Configured avatar
+// that does not exist is dropped when settings are loaded.
+func DefaultAvatarLink() string {
+	if len(setting.DefaultAvatar) > 0 {
+		return setting.DefaultAvatar
+	}
+	return _BUILTIN_DEFAULT_AVATAR
+}
+
 func (u *User) RelAvatarLink() string {
-	defaultImgUrl := "/img/avatar_default.png"
+	defaultImgUrl := DefaultAvatarLink()
 	if u.ID == -1 {
 		return defaultImgUrl
 	}
@@ -244,14 +439,20 @@ func (u *User) RelAvatarLink() string {
 		return "/avatars/" + com.ToStr(u.ID)
 	case setting.DisableGravatar, setting.OfflineMode:
 		if !com.IsExist(u.CustomAvatarPath()) {
-			if err := u.GenerateRandomAvatar(); err != nil {
-				log.Error(3, "GenerateRandomAvatar: %v", err)
+			if err := u.generateDefaultAvatar(); err != nil {
+				log.Error(3, "generateDefaultAvatar: %v", err)
 			}
 		}
 
 		return "/avatars/" + com.ToStr(u.ID)
 	}
-	return setting.GravatarSource + u.Avatar
+	return setting.GravatarSource + u.Avatar + "?d=" + url.QueryEscape(AvatarDefaultURL(u))
+}
+
+// AvatarDefaultURL returns absolute URL of default avatar of user served by this instance,
+// which is passed to Gravatar as fallback so users without Gravatar get distinct avatars.
+func AvatarDefaultURL(u *User) string {
+	return strings.TrimSuffix(setting.AppUrl, "/") + "/user/avatar/" + u.LowerName
 }
 
 // AvatarLink returns user avatar link.
@@ -263,6 +464,15 @@ func (u *User) AvatarLink() string {
 	return link
 }
 
+// SizedAvatarLink returns user avatar link with requested size.
+func (u *User) SizedAvatarLink(size int) string {
+	link := u.AvatarLink()
+	if strings.Contains(link, "?") {
+		return link + "&s=" + com.ToStr(size)
+	}
+	return link + "?s=" + com.ToStr(size)
+}
+
 // User.GetFollwoers returns range of user's followers.
 func (u *User) GetFollowers(page int) ([]*User, error) {
 	users := make([]*User, 0, ItemsPerPage)
@@ -291,38 +501,291 @@ func (u *User) GetFollowing(page int) ([]*User, error) {
 	return users, sess.Find(&users)
 }
 
+// GetMutualFollows returns range of users who follow and are followed by given user.
+func GetMutualFollows(uid int64, page, pageSize int) ([]*User, error) {
+	users := make([]*User, 0, pageSize)
+	sess := x.Limit(pageSize, (page-1)*pageSize).Where("follow.user_id=?", uid).
+		And("follow.follow_id IN (SELECT user_id FROM follow WHERE follow_id=?)", uid)
+	if setting.UsePostgreSQL {
+		sess = sess.Join("INNER", "follow", `"user".id=follow.follow_id`)
+	} else {
+		sess = sess.Join("INNER", "follow", "user.id=follow.follow_id")
+	}
+	return users, sess.Asc("follow.id").Find(&users)
+}
+
+// NoReplyEmail returns the no-reply address of user.
+func (u *User) NoReplyEmail() string {
+	return u.LowerName + "@" + setting.Service.NoReplyAddress
+}
+
+// noReplyUserName returns user name of given no-reply address,
+// and false if the address does not belong to no-reply domain.
+func noReplyUserName(email string) (string, bool) {
+	if len(setting.Service.NoReplyAddress) == 0 {
+		return "", false
+	}
+	suffix := "@" + strings.ToLower(setting.Service.NoReplyAddress)
+	if !strings.HasSuffix(strings.ToLower(email), suffix) {
+		return "", false
+	}
+	name := email[:len(email)-len(suffix)]
+	return name, len(name) > 0
+}
+
+// GitEmail returns the e-mail address to be used in Git commits,
+// it is the no-reply address when user chooses to hide e-mail.
+func (u *User) GitEmail() string {
+	if u.HideEmail {
+		return u.NoReplyEmail()
+	}
+	return u.Email
+}
+
+// WebEmail returns the e-mail address to be displayed on web pages,
+// it returns empty string when user chooses to hide e-mail on web.
+func (u *User) WebEmail() string {
+	if u.HideEmailOnWeb {
+		return ""
+	}
+	return u.Email
+}
+
+// CanViewEmail returns true if viewer is allowed to see primary e-mail of target user,
+// viewer can be nil for anonymous visitor.
+func CanViewEmail(viewer *User, target *User) bool {
+	if !target.HideEmailOnWeb {
+		return true
+	}
+	return viewer != nil && (viewer.ID == target.ID || viewer.IsAdmin)
+}
+
+// PublicUser represents information of a user that is safe to be shown to others.
+type PublicUser struct {
+	ID             int64     `json:"id"`
+	Name           string    `json:"username"`
+	FullName       string    `json:"full_name"`
+	Email          string    `json:"email,omitempty"`
+	AvatarURL      string    `json:"avatar_url"`
+	Website        string    `json:"website"`
+	Location       string    `json:"location"`
+	Description    string    `json:"description"`
+	IsOrganization bool      `json:"is_organization"`
+	NumFollowers   int       `json:"followers"`
+	NumFollowing   int       `json:"following"`
+	NumStars       int       `json:"stars"`
+	NumRepos       int       `json:"repos"`
+	Created        time.Time `json:"created"`
+}
+
+// PublicProfile returns public information of user for given viewer,
+// e-mail is only included when viewer is allowed to see it.
+// Viewer can be nil for anonymous visitor.
+func (u *User) PublicProfile(viewer *User) *PublicUser {
+	p := &PublicUser{
+		ID:             u.ID,
+		Name:           u.Name,
+		FullName:       u.FullName,
+		AvatarURL:      u.AvatarLink(),
+		Website:        u.Website,
+		Location:       u.Location,
+		Description:    u.Description,
+		IsOrganization: u.IsOrganization(),
+		NumFollowers:   u.NumFollowers,
+		NumFollowing:   u.NumFollowing,
+		NumStars:       u.NumStars,
+		NumRepos:       u.NumRepos,
+		Created:        u.Created,
+	}
+	if CanViewEmail(viewer, u) {
+		p.Email = u.Email
+	}
+	return p
+}
+
+// hasSharedOrg returns true if two users are members of at least one same organization.
+func hasSharedOrg(e Engine, uid1, uid2 int64) (bool, error) {
+	return e.Where("uid=?", uid1).
+		And("org_id IN (SELECT org_id FROM `org_user` WHERE uid=?)", uid2).Get(new(OrgUser))
+}
+
+// CanUserSeeUser returns true if viewer is allowed to see target user,
+// viewer can be nil for anonymous visitor. Everyone can see users who are
+// not restricted, restricted users are only visible to admins and members
+// of same organizations.
+func CanUserSeeUser(viewer, target *User) bool {
+	if !target.IsRestricted {
+		return true
+	} else if viewer == nil {
+		return false
+	} else if viewer.ID == target.ID || viewer.IsAdmin {
+		return true
+	}
+
+	has, err := hasSharedOrg(x, viewer.ID, target.ID)
+	if err != nil {
+		log.Error(4, "hasSharedOrg [%d, %d]: %v", viewer.ID, target.ID, err)
+		return false
+	}
+	return has
+}
+
 // NewGitSig generates and returns the signature of given user.
 func (u *User) NewGitSig() *git.Signature {
 	return &git.Signature{
 		Name:  u.Name,
-		Email: u.Email,
+		Email: u.GitEmail(),
 		When:  time.Now(),
 	}
 }
 
-// EncodePasswd encodes password to safe format.
-func (u *User) EncodePasswd() {
-	newPasswd := base.PBKDF2([]byte(u.Passwd), []byte(u.Salt), 10000, 50, sha256.New)
-	u.Passwd = fmt.Sprintf("%x", newPasswd)
+const (
+	PASSWD_HASH_PBKDF2 = "" // Legacy, kept for users who have not signed in since bcrypt is introduced
+	PASSWD_HASH_BCRYPT = "bcrypt"
+)
+
+// encodePasswdPBKDF2 returns legacy PBKDF2 hash of given password.
+func encodePasswdPBKDF2(passwd, salt string) string {
+	return fmt.Sprintf("%x", base.PBKDF2([]byte(passwd), []byte(salt), 10000, 50, sha256.New))
+}
+
+// EncodePasswd encodes password to safe format, user is left unchanged on error.
+// Columns returned by passwdCols must be saved together.
+func (u *User) EncodePasswd() error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(u.Passwd), setting.BcryptCost)
+	if err != nil {
+		return fmt.Errorf("GenerateFromPassword: %v", err)
+	}
+	u.Passwd = string(hash)
+	u.PasswdHashAlgo = PASSWD_HASH_BCRYPT
+	return nil
+}
+
+// passwdCols returns columns that must be saved together whenever password
+// of user is encoded, followed by given extra columns.
+func passwdCols(extraCols ...string) []string {
+	return append([]string{"passwd", "salt", "passwd_hash_algo"}, extraCols...)
 }
 
 // ValidatePassword checks if given password matches the one belongs to the user.
 func (u *User) ValidatePassword(passwd string) bool {
-	newUser := &User{Passwd: passwd, Salt: u.Salt}
-	newUser.EncodePasswd()
-	return u.Passwd == newUser.Passwd
+	if u.PasswdHashAlgo == PASSWD_HASH_BCRYPT {
+		return bcrypt.CompareHashAndPassword([]byte(u.Passwd), []byte(passwd)) == nil
+	}
+	return subtle.ConstantTimeCompare([]byte(u.Passwd), []byte(encodePasswdPBKDF2(passwd, u.Salt))) == 1
+}
+
+// upgradePasswdHash re-encodes given valid password with bcrypt if user still has
+// a legacy hash. It returns true if password hash has been changed.
+func (u *User) upgradePasswdHash(passwd string) (bool, error) {
+	if u.PasswdHashAlgo == PASSWD_HASH_BCRYPT {
+		return false, nil
+	}
+
+	legacy := u.Passwd
+	u.Passwd = passwd
+	if err := u.EncodePasswd(); err != nil {
+		u.Passwd = legacy
+		return false, err
+	}
+	return true, nil
+}
+
+// UpgradePasswdHash re-encodes given valid password of user with bcrypt
+// and saves it if user still has a legacy hash.
+func UpgradePasswdHash(u *User, passwd string) error {
+	if upgraded, err := u.upgradePasswdHash(passwd); err != nil || !upgraded {
+		return err
+	}
+	_, err := x.Id(u.ID).Cols(passwdCols()...).Update(u)
+	return err
 }
 
 // UploadAvatar saves custom avatar for user.
 // FIXME: split uploads to different subdirs in case we have massive users.
 func (u *User) UploadAvatar(data []byte) error {
+	// Animation would be lost by resizing, keep original image as it is.
+	if IsAnimatedGIF(data) {
+		return u.saveAvatarBytes(data)
+	} else if IsSVGImage(data) {
+		sanitized, err := SanitizeSVGAvatar(data)
+		if err != nil {
+			return fmt.Errorf("SanitizeSVGAvatar: %v", err)
+		}
+		return u.saveAvatarBytes(sanitized)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("Decode: %v", err)
+	}
+
+	return u.saveAvatar(cropImage(img, centerSquare(img.Bounds())))
+}
+
+// UploadAvatarCropped saves custom avatar for user from given area of image,
+// the area is clamped to be a square within the image.
+func UploadAvatarCropped(u *User, data []byte, crop image.Rectangle) error {
 	img, _, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
 		return fmt.Errorf("Decode: %v", err)
 	}
 
+	return u.saveAvatar(cropImage(img, clampCrop(crop, img.Bounds())))
+}
+
+// centerSquare returns the largest square in the center of given bounds.
+func centerSquare(b image.Rectangle) image.Rectangle {
+	size := b.Dx()
+	if b.Dy() < size {
+		size = b.Dy()
+	}
+	min := b.Min.Add(image.Pt((b.Dx()-size)/2, (b.Dy()-size)/2))
+	return image.Rectangle{Min: min, Max: min.Add(image.Pt(size, size))}
+}
+
+// clampCrop returns the square part of crop area that lies within given bounds,
+// it falls back to center square when crop area is out of bounds.
+func clampCrop(crop, b image.Rectangle) image.Rectangle {
+	r := crop.Canon().Intersect(b)
+	if r.Empty() {
+		return centerSquare(b)
+	}
+
+	size := r.Dx()
+	if r.Dy() < size {
+		size = r.Dy()
+	}
+	return image.Rectangle{Min: r.Min, Max: r.Min.Add(image.Pt(size, size))}
+}
+
+// cropImage returns given area of image.
+func cropImage(img image.Image, r image.Rectangle) image.Image {
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(r)
+	}
+
+	m := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+	draw.Draw(m, m.Bounds(), img, r.Min, draw.Src)
+	return m
+}
+
+// avatarWorkingPool makes sure avatar changes of the same user are performed one at a time,
+// so database update and file write of one change never interleave with another.
+var avatarWorkingPool = &workingPool{
+	pool:  make(map[string]*sync.Mutex),
+	count: make(map[string]int),
+}
+
+// saveAvatar resizes and saves given image as custom avatar of user.
+func (u *User) saveAvatar(img image.Image) (err error) {
 	m := resize.Resize(avatar.AVATAR_SIZE, avatar.AVATAR_SIZE, img, resize.NearestNeighbor)
 
+	avatarWorkingPool.CheckIn(com.ToStr(u.ID))
+	defer avatarWorkingPool.CheckOut(com.ToStr(u.ID))
+
 	sess := x.NewSession()
 	defer sessionRelease(sess)
 	if err = sess.Begin(); err != nil {
@@ -335,22 +798,304 @@ func (u *User) UploadAvatar(data []byte) error {
 	}
 
 	os.MkdirAll(setting.AvatarUploadPath, os.ModePerm)
-	fw, err := os.Create(u.CustomAvatarPath())
+	if err = writeAvatarFile(u.CustomAvatarPath(), func(w io.Writer) error {
+		return png.Encode(w, m)
+	}); err != nil {
+		return fmt.Errorf("writeAvatarFile: %v", err)
+	}
+
+	return sess.Commit()
+}
+
+// IsAnimatedGIF returns true if given data is a GIF image with more than one frame.
+func IsAnimatedGIF(data []byte) bool {
+	if !bytes.HasPrefix(data, []byte("GIF8")) {
+		return false
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	return err == nil && len(g.Image) > 1
+}
+
+// safeSVGElements are elements allowed in SVG avatars,
+// any other element is removed along with its children.
+var safeSVGElements = map[string]bool{
+	"svg":            true,
+	"g":              true,
+	"defs":           true,
+	"title":          true,
+	"desc":           true,
+	"symbol":         true,
+	"use":            true,
+	"path":           true,
+	"rect":           true,
+	"circle":         true,
+	"ellipse":        true,
+	"line":           true,
+	"polyline":       true,
+	"polygon":        true,
+	"text":           true,
+	"tspan":          true,
+	"lineargradient": true,
+	"radialgradient": true,
+	"stop":           true,
+	"clippath":       true,
+	"mask":           true,
+}
+
+// safeSVGAttrs are presentation and geometry attributes allowed in SVG avatars.
+var safeSVGAttrs = map[string]bool{
+	"id": true, "version": true, "viewbox": true, "preserveaspectratio": true,
+	"width": true, "height": true, "x": true, "y": true, "dx": true, "dy": true,
+	"x1": true, "y1": true, "x2": true, "y2": true, "cx": true, "cy": true,
+	"r": true, "rx": true, "ry": true, "fx": true, "fy": true, "d": true, "points": true,
+	"transform": true, "opacity": true, "display": true, "visibility": true,
+	"fill": true, "fill-opacity": true, "fill-rule": true,
+	"stroke": true, "stroke-width": true, "stroke-opacity": true, "stroke-linecap": true,
+	"stroke-linejoin": true, "stroke-miterlimit": true, "stroke-dasharray": true, "stroke-dashoffset": true,
+	"clip-path": true, "clip-rule": true, "clippathunits": true, "mask": true, "maskunits": true,
+	"offset": true, "stop-color": true, "stop-opacity": true,
+	"gradientunits": true, "gradienttransform": true, "spreadmethod": true,
+	"font-family": true, "font-size": true, "font-weight": true, "font-style": true, "text-anchor": true,
+}
+
+// sanitizeSVGAttr returns attribute to be written back and true if it is allowed
+// in SVG avatars. Only local references are allowed, so nothing is loaded from
+// other locations.
+func sanitizeSVGAttr(attr xml.Attr) (xml.Attr, bool) {
+	space := strings.ToLower(attr.Name.Space)
+	name := strings.ToLower(attr.Name.Local)
+	value := strings.ToLower(strings.TrimSpace(attr.Value))
+	if strings.Contains(value, "javascript:") {
+		return attr, false
+	}
+
+	switch {
+	case space == "" && name == "xmlns":
+		return xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: attr.Value}, value == "http://www.w3.org/2000/svg"
+	case space == "xmlns" && name == "xlink":
+		return xml.Attr{Name: xml.Name{Local: "xmlns:xlink"}, Value: attr.Value}, value == "http://www.w3.org/1999/xlink"
+	case (space == "" || space == "xlink") && name == "href":
+		return xml.Attr{Name: xml.Name{Local: "xlink:href"}, Value: attr.Value}, strings.HasPrefix(value, "#")
+	case space != "" || !safeSVGAttrs[name]:
+		return attr, false
+	case strings.Contains(value, "url(") && !strings.HasPrefix(value, "url(#"):
+		return attr, false
+	}
+	return xml.Attr{Name: xml.Name{Local: attr.Name.Local}, Value: attr.Value}, true
+}
+
+// IsSVGImage returns true if root element of given data is an SVG element.
+func IsSVGImage(data []byte) bool {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := dec.RawToken()
+		if err != nil {
+			return false
+		}
+		if t, ok := tok.(xml.StartElement); ok {
+			return len(t.Name.Space) == 0 && t.Name.Local == "svg"
+		}
+	}
+}
+
+// SanitizeSVGAvatar returns SVG data which contains only allowed elements and attributes,
+// so scripts, event handlers, styles and references to external resources are removed.
+// It returns error if root element of data is not an SVG element.
+func SanitizeSVGAvatar(data []byte) ([]byte, error) {
+	if !IsSVGImage(data) {
+		return nil, errors.New("not an SVG image")
+	}
+
+	var (
+		dec       = xml.NewDecoder(bytes.NewReader(data))
+		buf       = new(bytes.Buffer)
+		enc       = xml.NewEncoder(buf)
+		depth     int
+		skipDepth int
+		closed    bool
+	)
+	for {
+		tok, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("RawToken: %v", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if closed {
+				return nil, errors.New("content after root element")
+			}
+			depth++
+			if skipDepth > 0 || len(t.Name.Space) > 0 || !safeSVGElements[strings.ToLower(t.Name.Local)] {
+				skipDepth++
+				continue
+			}
+
+			attrs := make([]xml.Attr, 0, len(t.Attr))
+			for _, attr := range t.Attr {
+				if attr, ok := sanitizeSVGAttr(attr); ok {
+					attrs = append(attrs, attr)
+				}
+			}
+			tok = xml.StartElement{Name: xml.Name{Local: t.Name.Local}, Attr: attrs}
+		case xml.EndElement:
+			depth--
+			closed = depth == 0
+			if skipDepth > 0 {
+				skipDepth--
+				continue
+			}
+			tok = xml.EndElement{Name: xml.Name{Local: t.Name.Local}}
+		case xml.CharData:
+			// Text outside of root element or in removed elements is dropped.
+			if depth == 0 || skipDepth > 0 {
+				continue
+			}
+		default:
+			// Comments, document type and processing instructions are never needed to render.
+			continue
+		}
+
+		if err = enc.EncodeToken(xml.CopyToken(tok)); err != nil {
+			return nil, fmt.Errorf("EncodeToken: %v", err)
+		}
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, fmt.Errorf("Flush: %v", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// SetAvatarBytes saves given image data as custom avatar of user without
+// any processing, the image must be in given format and has exactly size of avatar.
+func SetAvatarBytes(u *User, data []byte, format string) error {
+	cfg, imgFormat, err := image.DecodeConfig(bytes.NewReader(data))
 	if err != nil {
-		return fmt.Errorf("Create: %v", err)
+		return fmt.Errorf("DecodeConfig: %v", err)
+	} else if imgFormat != format {
+		return fmt.Errorf("image format mismatch: expect %s but got %s", format, imgFormat)
+	} else if cfg.Width != avatar.AVATAR_SIZE || cfg.Height != avatar.AVATAR_SIZE {
+		return fmt.Errorf("image size must be %dx%d but got %dx%d",
+			avatar.AVATAR_SIZE, avatar.AVATAR_SIZE, cfg.Width, cfg.Height)
+	}
+
+	return u.saveAvatarBytes(data)
+}
+
+// saveAvatarBytes saves given data as custom avatar of user as is.
+func (u *User) saveAvatarBytes(data []byte) (err error) {
+	avatarWorkingPool.CheckIn(com.ToStr(u.ID))
+	defer avatarWorkingPool.CheckOut(com.ToStr(u.ID))
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
 	}
-	defer fw.Close()
 
-	if err = png.Encode(fw, m); err != nil {
-		return fmt.Errorf("Encode: %v", err)
+	u.UseCustomAvatar = true
+	if err = updateUser(sess, u); err != nil {
+		return fmt.Errorf("updateUser: %v", err)
+	}
+
+	os.MkdirAll(setting.AvatarUploadPath, os.ModePerm)
+	if err = writeAvatarFile(u.CustomAvatarPath(), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}); err != nil {
+		return fmt.Errorf("writeAvatarFile: %v", err)
 	}
 
 	return sess.Commit()
 }
 
+const (
+	_AVATAR_FETCH_TIMEOUT  = 10 * time.Second
+	_AVATAR_FETCH_MAX_SIZE = 1 << 20
+)
+
+var privateIPNets []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{
+		"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8",
+		"169.254.0.0/16", "172.16.0.0/12", "192.168.0.0/16",
+		"::/128", "::1/128", "fc00::/7", "fe80::/10",
+	} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		privateIPNets = append(privateIPNets, ipnet)
+	}
+}
+
+// isPrivateIP returns true if given IP is in loopback, link-local or private network.
+func isPrivateIP(ip net.IP) bool {
+	for _, ipnet := range privateIPNets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialPublicOnly resolves given address and refuses to connect to private network,
+// it is checked on every dial so redirects to private network are refused as well.
+func dialPublicOnly(network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	} else if len(ips) == 0 {
+		return nil, fmt.Errorf("no IP address found for host: %s", host)
+	}
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return nil, fmt.Errorf("host resolves to private network: %s", host)
+		}
+	}
+	return net.DialTimeout(network, net.JoinHostPort(ips[0].String(), port), _AVATAR_FETCH_TIMEOUT)
+}
+
+// UploadAvatarFromURL fetches image from given URL and saves it as custom avatar for user.
+func UploadAvatarFromURL(u *User, url string) error {
+	client := &http.Client{
+		Timeout:   _AVATAR_FETCH_TIMEOUT,
+		Transport: &http.Transport{Dial: dialPublicOnly},
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	} else if !strings.HasPrefix(resp.Header.Get("Content-Type"), "image/") {
+		return fmt.Errorf("content type is not an image: %s", resp.Header.Get("Content-Type"))
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, _AVATAR_FETCH_MAX_SIZE+1))
+	if err != nil {
+		return fmt.Errorf("ReadAll: %v", err)
+	} else if len(data) > _AVATAR_FETCH_MAX_SIZE {
+		return fmt.Errorf("image exceeds maximum size of %d bytes", _AVATAR_FETCH_MAX_SIZE)
+	} else if _, ok := base.IsImageFile(data); !ok {
+		return errors.New("fetched content is not an image")
+	}
+
+	return u.UploadAvatar(data)
+}
+
 // DeleteAvatar deletes the user's custom avatar.
 func (u *User) DeleteAvatar() error {
 	log.Trace("DeleteAvatar[%d]: %s", u.ID, u.CustomAvatarPath())
+	avatarWorkingPool.CheckIn(com.ToStr(u.ID))
+	defer avatarWorkingPool.CheckOut(com.ToStr(u.ID))
+
 	os.Remove(u.CustomAvatarPath())
 
 	u.UseCustomAvatar = false
@@ -408,14 +1153,37 @@ func (u *User) GetRepositories(page, pageSize int) (err error) {
 	return err
 }
 
-// GetRepositories returns mirror repositories that user owns, including private repositories.
-func (u *User) GetMirrorRepositories() ([]*Repository, error) {
-	return GetUserMirrorRepositories(u.ID)
+// canViewPrivateRepos returns true if viewer can see all private repositories
+// of owner, viewer can be nil for anonymous visitor.
+// Note: members of organization only see private repositories their teams
+// have access to, which is not covered here.
+func canViewPrivateRepos(viewer, owner *User) bool {
+	return viewer != nil && (viewer.ID == owner.ID || viewer.IsAdmin)
 }
 
-// GetOwnedOrganizations returns all organizations that user owns.
-func (u *User) GetOwnedOrganizations() (err error) {
-	u.OwnedOrgs, err = GetOwnedOrgsByUserID(u.ID)
+// GetUserWithRepositories returns user by given name with given page of repositories
+// that viewer is allowed to see, ordered by last update.
+func GetUserWithRepositories(name string, viewer *User, page, pageSize int) (*User, error) {
+	u, err := GetUserByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Repos, err = GetUserRepositories(u.ID, canViewPrivateRepos(viewer, u), page, pageSize)
+	if err != nil {
+		return nil, fmt.Errorf("GetUserRepositories: %v", err)
+	}
+	return u, nil
+}
+
+// GetRepositories returns mirror repositories that user owns, including private repositories.
+func (u *User) GetMirrorRepositories() ([]*Repository, error) {
+	return GetUserMirrorRepositories(u.ID)
+}
+
+// GetOwnedOrganizations returns all organizations that user owns.
+func (u *User) GetOwnedOrganizations() (err error) {
+	u.OwnedOrgs, err = GetOwnedOrgsByUserID(u.ID)
 	return err
 }
 
@@ -460,6 +1228,65 @@ func IsUserExist(uid int64, name string) (bool, error) {
 	return x.Where("id!=?", uid).Get(&User{LowerName: strings.ToLower(name)})
 }
 
+// IsNameTaken returns true if given name has been used by any user or organization,
+// they share the same namespace. It should be checked before creating either of them.
+func IsNameTaken(name string) (bool, error) {
+	isExist, err := IsUserExist(0, name)
+	if err != nil || isExist {
+		return isExist, err
+	}
+	return IsNameRecentlyDeleted(name)
+}
+
+// DeletedUserName represents name of a deleted or renamed user or organization,
+// it prevents the name from being taken over right after deletion or rename.
+type DeletedUserName struct {
+	ID          int64     `xorm:"pk autoincr"`
+	LowerName   string    `xorm:"UNIQUE NOT NULL"`
+	DeletedAt   time.Time `xorm:"-"`
+	DeletedUnix int64
+}
+
+func (n *DeletedUserName) AfterSet(colName string, _ xorm.Cell) {
+	switch colName {
+	case "deleted_unix":
+		n.DeletedAt = time.Unix(n.DeletedUnix, 0).Local()
+	}
+}
+
+// inCooldown returns true if the name cannot be used again at given time.
+func (n *DeletedUserName) inCooldown(now time.Time, cooldown time.Duration) bool {
+	return cooldown > 0 && now.Before(time.Unix(n.DeletedUnix, 0).Add(cooldown))
+}
+
+func recordDeletedUserName(e Engine, name string) error {
+	lowerName := strings.ToLower(name)
+	if _, err := e.Delete(&DeletedUserName{LowerName: lowerName}); err != nil {
+		return err
+	}
+	_, err := e.Insert(&DeletedUserName{
+		LowerName:   lowerName,
+		DeletedUnix: time.Now().Unix(),
+	})
+	return err
+}
+
+// IsNameRecentlyDeleted returns true if given name belonged to a user or organization
+// deleted or renamed within configured cooldown period.
+func IsNameRecentlyDeleted(name string) (bool, error) {
+	cooldown := time.Duration(setting.Service.DeletedUserNameCooldown) * time.Minute
+	if cooldown <= 0 || len(name) == 0 {
+		return false, nil
+	}
+
+	n := &DeletedUserName{LowerName: strings.ToLower(name)}
+	has, err := x.Get(n)
+	if err != nil || !has {
+		return false, err
+	}
+	return n.inCooldown(time.Now(), cooldown), nil
+}
+
 // GetUserSalt returns a ramdom user salt token.
 func GetUserSalt() string {
 	return base.GetRandomString(10)
@@ -508,13 +1335,153 @@ func IsUsableUsername(name string) error {
 	return isUsableName(reversedUsernames, reversedUserPatterns, name)
 }
 
+// ValidateUserName checks if given name is legal and usable as a user name.
+// Trailing dot is not allowed because some databases and file systems
+// ignore it in comparison.
+func ValidateUserName(name string) error {
+	if utf8.RuneCountInString(name) > 35 || binding.AlphaDashDotPattern.MatchString(name) ||
+		strings.HasSuffix(name, ".") {
+		return ErrUserNameIllegal
+	}
+	return IsUsableUsername(name)
+}
+
+// IsUserNameAvailable checks if given name is legal, usable and not taken by
+// any user or organization, names in deletion cooldown are reported as taken.
+// Reason is not empty when name is not available.
+func IsUserNameAvailable(name string) (available bool, reason string, err error) {
+	if err = ValidateUserName(name); err != nil {
+		switch {
+		case err == ErrNameEmpty:
+			return false, "empty", nil
+		case err == ErrUserNameIllegal:
+			return false, "illegal", nil
+		case IsErrNameReserved(err):
+			return false, "reserved", nil
+		case IsErrNamePatternNotAllowed(err):
+			return false, "pattern_not_allowed", nil
+		}
+		return false, "", err
+	}
+
+	isExist, err := IsNameTaken(name)
+	if err != nil {
+		return false, "", err
+	} else if isExist {
+		return false, "taken", nil
+	}
+
+	confusable, err := isNameConfusable(x, name)
+	if err != nil {
+		return false, "", err
+	} else if confusable {
+		return false, "confusable", nil
+	}
+	return true, "", nil
+}
+
+// confusableReplacements maps characters and sequences that look alike in most fonts
+// to a single form, they are applied in order.
+var confusableReplacements = [][2]string{
+	{"rn", "m"},
+	{"vv", "w"},
+	{"0", "o"},
+	{"1", "l"},
+	{"i", "l"},
+	{"_", "-"},
+}
+
+// nameSkeleton returns form of name that is shared by all names looking alike.
+func nameSkeleton(name string) string {
+	name = strings.ToLower(name)
+	for _, r := range confusableReplacements {
+		name = strings.Replace(name, r[0], r[1], -1)
+	}
+	return name
+}
+
+// isNameConfusable returns true if given name is not used but looks like
+// name of an existing user or organization.
+func isNameConfusable(e Engine, name string) (bool, error) {
+	col := "lower_name"
+	for _, r := range confusableReplacements {
+		col = fmt.Sprintf("REPLACE(%s, '%s', '%s')", col, r[0], r[1])
+	}
+	return e.Where(col+"=?", nameSkeleton(name)).And("lower_name!=?", strings.ToLower(name)).Get(new(User))
+}
+
 // CreateUser creates record of a new user.
 func CreateUser(u *User) (err error) {
-	if err = IsUsableUsername(u.Name); err != nil {
+	if err = prepareNewUser(u); err != nil {
+		return err
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	if _, err = sess.Insert(u); err != nil {
+		return err
+	} else if err = os.MkdirAll(UserPath(u.Name), os.ModePerm); err != nil {
+		return err
+	}
+
+	return sess.Commit()
+}
+
+// CreateUserWithKey creates record of a new user together with its first public key
+// in one transaction, nothing is left behind if the key is invalid or has been used.
+func CreateUserWithKey(u *User, keyContent, keyTitle string) (err error) {
+	keyContent, err = CheckPublicKeyString(keyContent)
+	if err != nil {
+		return err
+	} else if err = checkKeyContent(keyContent); err != nil {
+		return err
+	}
+
+	if err = prepareNewUser(u); err != nil {
+		return err
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	if _, err = sess.Insert(u); err != nil {
+		return err
+	} else if err = os.MkdirAll(UserPath(u.Name), os.ModePerm); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(UserPath(u.Name))
+		}
+	}()
+
+	if err = addKey(sess, &PublicKey{
+		OwnerID: u.ID,
+		Name:    keyTitle,
+		Content: keyContent,
+		Mode:    ACCESS_MODE_WRITE,
+		Type:    KEY_TYPE_USER,
+	}); err != nil {
+		return fmt.Errorf("addKey: %v", err)
+	}
+
+	return sess.Commit()
+}
+
+// prepareNewUser validates and fills initial values of a new user before insert.
+func prepareNewUser(u *User) (err error) {
+	if err = ValidateUserName(u.Name); err != nil {
 		return err
 	}
 
-	isExist, err := IsUserExist(0, u.Name)
+	isExist, err := IsNameTaken(u.Name)
 	if err != nil {
 		return err
 	} else if isExist {
@@ -522,7 +1489,7 @@ func CreateUser(u *User) (err error) {
 	}
 
 	u.Email = strings.ToLower(u.Email)
-	isExist, err = IsEmailUsed(u.Email)
+	isExist, err = IsEmailUsedByType(u.Email, u.Type)
 	if err != nil {
 		return err
 	} else if isExist {
@@ -534,38 +1501,307 @@ func CreateUser(u *User) (err error) {
 	u.Avatar = base.HashEmail(u.AvatarEmail)
 	u.Rands = GetUserSalt()
 	u.Salt = GetUserSalt()
-	u.EncodePasswd()
+	if err = u.EncodePasswd(); err != nil {
+		return err
+	}
 	u.MaxRepoCreation = -1
+	return nil
+}
+
+func countUsers(e Engine) int64 {
+	count, _ := e.Where("type=0").And("is_deleted=?", false).Count(new(User))
+	return count
+}
+
+// CountUsers returns number of users.
+func CountUsers() int64 {
+	return countUsers(x)
+}
+
+// UpdateUserLastLogin records current time as last sign in time of user.
+func UpdateUserLastLogin(u *User) error {
+	now := time.Now()
+	u.LastLoginUnix = now.Unix()
+	u.LastLogin = now
+	_, err := x.Exec("UPDATE `user` SET last_login_unix=? WHERE id=?", u.LastLoginUnix, u.ID)
+	return err
+}
+
+// _MAX_LOCKOUT_DURATION is the upper bound of escalated lockout duration.
+const _MAX_LOCKOUT_DURATION = 24 * time.Hour
+
+// lockoutDuration returns how long an account is locked after given number of
+// consecutive failed sign in attempts. The account is locked once number of failures
+// reaches threshold, and duration doubles with every further failure.
+func lockoutDuration(numFailures int) time.Duration {
+	if setting.LoginMaxFailures <= 0 || numFailures < setting.LoginMaxFailures {
+		return 0
+	}
+
+	duration := time.Duration(setting.LoginLockoutMinutes) * time.Minute
+	for i := setting.LoginMaxFailures; i < numFailures; i++ {
+		duration *= 2
+		if duration >= _MAX_LOCKOUT_DURATION {
+			return _MAX_LOCKOUT_DURATION
+		}
+	}
+	return duration
+}
+
+// IsLocked returns true if user is temporarily locked because of failed sign in attempts.
+func (u *User) IsLocked() bool {
+	return u.LockedUntilUnix > time.Now().Unix()
+}
+
+// lockForFailures locks user from given time for duration decided by
+// current number of failed sign in attempts, if needed.
+func (u *User) lockForFailures(now time.Time) {
+	if duration := lockoutDuration(u.NumFailedLogins); duration > 0 {
+		u.LockedUntil = now.Add(duration)
+		u.LockedUntilUnix = u.LockedUntil.Unix()
+	}
+}
+
+// recordFailedLogin increases number of failed sign in attempts at given time
+// and locks user if needed.
+func (u *User) recordFailedLogin(now time.Time) {
+	u.NumFailedLogins++
+	u.lockForFailures(now)
+}
 
+// RecordFailedLogin records a failed sign in attempt of user,
+// and locks user for escalating duration when there are too many of them.
+// Counter is increased in database, so concurrent attempts are all counted.
+func (u *User) RecordFailedLogin() (err error) {
 	sess := x.NewSession()
 	defer sessionRelease(sess)
 	if err = sess.Begin(); err != nil {
 		return err
 	}
 
-	if _, err = sess.Insert(u); err != nil {
-		return err
-	} else if err = os.MkdirAll(UserPath(u.Name), os.ModePerm); err != nil {
-		return err
+	if _, err = sess.Exec("UPDATE `user` SET num_failed_logins = num_failed_logins + 1 WHERE id=?", u.ID); err != nil {
+		return fmt.Errorf("increase failed logins: %v", err)
+	}
+	stored := new(User)
+	if _, err = sess.Id(u.ID).Cols("num_failed_logins").Get(stored); err != nil {
+		return fmt.Errorf("get failed logins: %v", err)
 	}
 
+	u.NumFailedLogins = stored.NumFailedLogins
+	u.lockForFailures(time.Now())
+	if _, err = sess.Exec("UPDATE `user` SET locked_until_unix=? WHERE id=?", u.LockedUntilUnix, u.ID); err != nil {
+		return fmt.Errorf("update lock: %v", err)
+	}
 	return sess.Commit()
 }
 
-func countUsers(e Engine) int64 {
-	count, _ := e.Where("type=0").Count(new(User))
-	return count
+func (u *User) resetFailedLogins(e Engine) error {
+	if u.NumFailedLogins == 0 && u.LockedUntilUnix == 0 {
+		return nil
+	}
+
+	u.NumFailedLogins = 0
+	u.LockedUntil = time.Time{}
+	u.LockedUntilUnix = 0
+	_, err := e.Exec("UPDATE `user` SET num_failed_logins=0, locked_until_unix=0 WHERE id=?", u.ID)
+	return err
 }
 
-// CountUsers returns number of users.
-func CountUsers() int64 {
-	return countUsers(x)
+// ResetFailedLogins clears failed sign in attempts and lock of user.
+func (u *User) ResetFailedLogins() error {
+	return u.resetFailedLogins(x)
+}
+
+// GetUsersNeverLoggedIn returns active individual users who have never signed in.
+// Note: sign in time is not recorded for users before it was introduced,
+// so they are also returned until they sign in again.
+func GetUsersNeverLoggedIn() ([]*User, error) {
+	users := make([]*User, 0, 10)
+	return users, x.Where("type=?", USER_TYPE_INDIVIDUAL).And("is_deleted=?", false).
+		And("is_active=?", true).And("last_login_unix=0").Asc("id").Find(&users)
+}
+
+// CountByType returns number of users of each type in a single query.
+func CountByType() (map[UserType]int64, error) {
+	results, err := x.Query("SELECT type, COUNT(*) AS num FROM `user` WHERE is_deleted=? GROUP BY type", false)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[UserType]int64{
+		USER_TYPE_INDIVIDUAL:   0,
+		USER_TYPE_ORGANIZATION: 0,
+	}
+	for _, result := range results {
+		counts[UserType(com.StrTo(result["type"]).MustInt())] = com.StrTo(result["num"]).MustInt64()
+	}
+	return counts, nil
+}
+
+// RepoCountTiers are ranges of number of owned repositories used by GetUserRepoCountDistribution.
+var RepoCountTiers = []string{"0", "1-5", "6-20", "21+"}
+
+// repoCountTier returns the tier of given number of repositories.
+func repoCountTier(numRepos int) string {
+	switch {
+	case numRepos <= 0:
+		return RepoCountTiers[0]
+	case numRepos <= 5:
+		return RepoCountTiers[1]
+	case numRepos <= 20:
+		return RepoCountTiers[2]
+	}
+	return RepoCountTiers[3]
+}
+
+// bucketRepoCounts sums numbers of users of each number of repositories into tiers.
+func bucketRepoCounts(numUsers map[int]int64) map[string]int64 {
+	tiers := make(map[string]int64, len(RepoCountTiers))
+	for _, tier := range RepoCountTiers {
+		tiers[tier] = 0
+	}
+	for numRepos, num := range numUsers {
+		tiers[repoCountTier(numRepos)] += num
+	}
+	return tiers
+}
+
+// GetUserRepoCountDistribution returns number of individual users in each tier of
+// number of owned repositories, all tiers in RepoCountTiers are present.
+func GetUserRepoCountDistribution() (map[string]int64, error) {
+	results, err := x.Query("SELECT num_repos, COUNT(*) AS num FROM `user` WHERE type=? AND is_deleted=? GROUP BY num_repos",
+		USER_TYPE_INDIVIDUAL, false)
+	if err != nil {
+		return nil, err
+	}
+
+	numUsers := make(map[int]int64, len(results))
+	for _, result := range results {
+		numUsers[com.StrTo(result["num_repos"]).MustInt()] += com.StrTo(result["num"]).MustInt64()
+	}
+	return bucketRepoCounts(numUsers), nil
 }
 
 // Users returns number of users in given page.
 func Users(page, pageSize int) ([]*User, error) {
 	users := make([]*User, 0, pageSize)
-	return users, x.Limit(pageSize, (page-1)*pageSize).Where("type=0").Asc("id").Find(&users)
+	return users, x.Limit(pageSize, (page-1)*pageSize).Where("type=0").And("is_deleted=?", false).
+		Asc("id").Find(&users)
+}
+
+// GetMostFollowedUsers returns given number of active individual users
+// ordered by number of followers descending.
+// Note: it relies on the counter column num_followers being accurate.
+func GetMostFollowedUsers(limit int) ([]*User, error) {
+	users := make([]*User, 0, limit)
+	return users, x.Limit(limit).Where("type=?", USER_TYPE_INDIVIDUAL).And("is_deleted=?", false).
+		And("is_active=?", true).And("prohibit_login=?", false).
+		Desc("num_followers").Asc("id").Find(&users)
+}
+
+// GetRecentlyRegisteredUsers returns newest registered individual users,
+// including inactive ones, for admin review.
+func GetRecentlyRegisteredUsers(limit int) ([]*User, error) {
+	users := make([]*User, 0, limit)
+	return users, x.Limit(limit).Where("type=?", USER_TYPE_INDIVIDUAL).And("is_deleted=?", false).
+		Desc("created_unix").Desc("id").Find(&users)
+}
+
+// GetUsersWithUnverifiedPrimaryEmail returns individual users who have not activated
+// their primary e-mail and registered longer than given duration ago.
+func GetUsersWithUnverifiedPrimaryEmail(olderThan time.Duration) ([]*User, error) {
+	users := make([]*User, 0, 10)
+	return users, x.Where("type=?", USER_TYPE_INDIVIDUAL).And("is_deleted=?", false).
+		And("is_active=?", false).
+		And("created_unix<?", time.Now().Add(-olderThan).Unix()).
+		Asc("id").Find(&users)
+}
+
+// BotHeuristics represents criteria of suspected bot accounts, a user must match
+// all criteria that are set. Zero value of a criterion means it is not set.
+type BotHeuristics struct {
+	CreatedWithin  time.Duration // Registered within this duration
+	MinRepos       int           // Owns at least this number of repositories
+	NoCustomAvatar bool          // Does not use custom avatar
+	EmailDomains   []string      // Primary email is on one of these domains, e.g. disposable ones
+}
+
+// IsSet returns true if at least one criterion is set.
+func (opts BotHeuristics) IsSet() bool {
+	return opts.CreatedWithin > 0 || opts.MinRepos > 0 || opts.NoCustomAvatar || len(opts.EmailDomains) > 0
+}
+
+// isSuspectedBot returns true if user matches all criteria that are set.
+func isSuspectedBot(u *User, opts BotHeuristics) bool {
+	if u.IsOrganization() || u.IsAdmin || !opts.IsSet() {
+		return false
+	}
+
+	if opts.CreatedWithin > 0 && u.CreatedUnix < time.Now().Add(-opts.CreatedWithin).Unix() {
+		return false
+	} else if opts.MinRepos > 0 && u.NumRepos < opts.MinRepos {
+		return false
+	} else if opts.NoCustomAvatar && u.UseCustomAvatar {
+		return false
+	}
+
+	if len(opts.EmailDomains) == 0 {
+		return true
+	}
+	email := strings.ToLower(u.Email)
+	for _, domain := range opts.EmailDomains {
+		if strings.HasSuffix(email, "@"+normalizeEmailDomain(domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// FindSuspectedBotAccounts returns individual users who match given heuristics,
+// admins are never returned. It does not change anything.
+func FindSuspectedBotAccounts(opts BotHeuristics) ([]*User, error) {
+	if !opts.IsSet() {
+		return []*User{}, nil
+	}
+
+	sess := x.Where("type=?", USER_TYPE_INDIVIDUAL).And("is_deleted=?", false).And("is_admin=?", false)
+	if opts.CreatedWithin > 0 {
+		sess.And("created_unix>=?", time.Now().Add(-opts.CreatedWithin).Unix())
+	}
+	if opts.MinRepos > 0 {
+		sess.And("num_repos>=?", opts.MinRepos)
+	}
+	if opts.NoCustomAvatar {
+		sess.And("use_custom_avatar=?", false)
+	}
+
+	candidates := make([]*User, 0, 10)
+	if err := sess.Asc("id").Find(&candidates); err != nil {
+		return nil, err
+	}
+
+	users := make([]*User, 0, len(candidates))
+	for _, u := range candidates {
+		if isSuspectedBot(u, opts) {
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
+// GetUserJoinRank returns 1-based rank of given user among all individual users
+// ordered by registration time, organizations are not counted.
+func GetUserJoinRank(uid int64) (int64, error) {
+	u, err := GetUserByID(uid)
+	if err != nil {
+		return 0, err
+	} else if u.IsOrganization() {
+		return 0, ErrUserNotExist{uid, ""}
+	}
+
+	return x.Where("type=?", USER_TYPE_INDIVIDUAL).And("is_deleted=?", false).
+		And("(created_unix<? OR (created_unix=? AND id<=?))", u.CreatedUnix, u.CreatedUnix, u.ID).
+		Count(new(User))
 }
 
 // get user by erify code
@@ -621,67 +1857,622 @@ func VerifyActiveEmailCode(code, email string) *EmailAddress {
 	return nil
 }
 
-// ChangeUserName changes all corresponding setting from old user name to new one.
-func ChangeUserName(u *User, newUserName string) (err error) {
-	if err = IsUsableUsername(newUserName); err != nil {
-		return err
+// matchActivationCode returns the email among candidates that given code
+// was generated for and whether the code is still valid.
+func matchActivationCode(u *User, code string, emails []string) (string, bool) {
+	if len(code) <= base.TimeLimitCodeLength {
+		return "", false
 	}
 
-	isExist, err := IsUserExist(0, newUserName)
-	if err != nil {
-		return err
-	} else if isExist {
-		return ErrUserAlreadyExist{newUserName}
+	prefix := code[:base.TimeLimitCodeLength]
+	for _, email := range emails {
+		data := com.ToStr(u.ID) + email + u.LowerName + u.Passwd + u.Rands
+		if base.VerifyTimeLimitCode(data, setting.Service.ActiveCodeLives, prefix) {
+			return email, true
+		}
 	}
+	return "", false
+}
 
-	if err = ChangeUsernameInPullRequests(u.Name, newUserName); err != nil {
-		return fmt.Errorf("ChangeUsernameInPullRequests: %v", err)
+// InspectActivationCode returns the user and email that given activation code
+// targets, and whether the code currently validates. It never activates anything.
+// Email is empty when the code does not validate against any email of the user.
+func InspectActivationCode(code string) (*User, string, bool) {
+	u := getVerifyUser(code)
+	if u == nil {
+		return nil, "", false
 	}
 
-	// Delete all local copies of repository wiki that user owns.
-	if err = x.Where("owner_id=?", u.ID).Iterate(new(Repository), func(idx int, bean interface{}) error {
-		repo := bean.(*Repository)
-		RemoveAllWithNotice("Delete repository wiki local copy", repo.LocalWikiPath())
-		return nil
-	}); err != nil {
-		return fmt.Errorf("Delete repository wiki local copy: %v", err)
+	emails := make([]*EmailAddress, 0, 5)
+	if err := x.Where("uid=?", u.ID).Find(&emails); err != nil {
+		log.Error(4, "InspectActivationCode: %v", err)
+	}
+	candidates := make([]string, 1, len(emails)+1)
+	candidates[0] = u.Email
+	for i := range emails {
+		candidates = append(candidates, emails[i].Email)
 	}
 
-	return os.Rename(UserPath(u.Name), UserPath(newUserName))
+	email, valid := matchActivationCode(u, code, candidates)
+	return u, email, valid
 }
 
-func updateUser(e Engine, u *User) error {
-	// Organization does not need email
-	if !u.IsOrganization() {
-		u.Email = strings.ToLower(u.Email)
-		has, err := e.Where("id!=?", u.ID).And("type=?", u.Type).And("email=?", u.Email).Get(new(User))
+// changeName changes name of user or organization, renames its directory and
+// updates names recorded in pull requests and actions. Change of letter case only
+// is allowed. Names of given user are restored when it fails.
+func changeName(u *User, newName string) (err error) {
+	if strings.ToLower(newName) != u.LowerName {
+		isExist, err := IsNameTaken(newName)
 		if err != nil {
-			return err
-		} else if has {
-			return ErrEmailAlreadyUsed{u.Email}
+			return fmt.Errorf("IsNameTaken: %v", err)
+		} else if isExist {
+			return ErrUserAlreadyExist{newName}
 		}
+	}
 
-		if len(u.AvatarEmail) == 0 {
-			u.AvatarEmail = u.Email
+	oldName, oldLowerName, oldLoginName := u.Name, u.LowerName, u.LoginName
+	defer func() {
+		if err != nil {
+			u.Name, u.LowerName, u.LoginName = oldName, oldLowerName, oldLoginName
 		}
-		u.Avatar = base.HashEmail(u.AvatarEmail)
-	}
+	}()
 
-	u.LowerName = strings.ToLower(u.Name)
-	u.Location = base.TruncateString(u.Location, 255)
-	u.Website = base.TruncateString(u.Website, 255)
-	u.Description = base.TruncateString(u.Description, 255)
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
 
-	u.FullName = markdown.Sanitizer.Sanitize(u.FullName)
-	_, err := e.Id(u.ID).AllCols().Update(u)
-	return err
-}
+	// Login name of external sources usually mirrors user name, keep them in sync.
+	// Otherwise it is left alone because external identity may differ intentionally.
+	if len(u.LoginName) > 0 && u.LoginName == u.Name {
+		u.LoginName = newName
+	}
+	u.Name = newName
+	u.LowerName = strings.ToLower(newName)
+	if _, err = sess.Id(u.ID).Cols("name", "lower_name", "login_name").Update(u); err != nil {
+		return fmt.Errorf("update name: %v", err)
+	} else if _, err = sess.Exec("UPDATE `pull_request` SET head_user_name=? WHERE head_user_name=?",
+		u.LowerName, oldLowerName); err != nil {
+		return fmt.Errorf("update pull requests: %v", err)
+	} else if _, err = sess.Exec("UPDATE `action` SET repo_user_name=? WHERE repo_user_name=?",
+		newName, oldName); err != nil {
+		return fmt.Errorf("update actions: %v", err)
+	}
+
+	// Old name is held like name of a deleted user, so links to it cannot be taken over.
+	if u.LowerName != oldLowerName {
+		if err = recordDeletedUserName(sess, oldName); err != nil {
+			return fmt.Errorf("recordDeletedUserName: %v", err)
+		}
+	}
+
+	// Delete all local copies of repository wiki that user owns.
+	if err = sess.Where("owner_id=?", u.ID).Iterate(new(Repository), func(idx int, bean interface{}) error {
+		RemoveAllWithNotice("Delete repository wiki local copy", bean.(*Repository).LocalWikiPath())
+		return nil
+	}); err != nil {
+		return fmt.Errorf("delete repository wiki local copy: %v", err)
+	}
+
+	if UserPath(oldName) != UserPath(newName) {
+		if err = os.Rename(UserPath(oldName), UserPath(newName)); err != nil {
+			return fmt.Errorf("rename directory: %v", err)
+		}
+	}
+
+	if err = sess.Commit(); err != nil {
+		if UserPath(oldName) != UserPath(newName) {
+			os.Rename(UserPath(newName), UserPath(oldName))
+		}
+		return fmt.Errorf("Commit: %v", err)
+	}
+	return nil
+}
+
+// ChangeUserName changes all corresponding setting from old user name to new one.
+func ChangeUserName(u *User, newUserName string) (err error) {
+	if err = ValidateUserName(newUserName); err != nil {
+		return err
+	}
+	return changeName(u, newUserName)
+}
+
+// TrimUserNames removes trailing dots and spaces from names of existing users
+// and organizations, it returns number of names changed. Names that become
+// unusable or taken after trimming are left alone for admin to resolve.
+func TrimUserNames() (int, error) {
+	users := make([]*User, 0, 10)
+	if err := x.Where("name LIKE ? OR name LIKE ?", "%.", "% ").Find(&users); err != nil {
+		return 0, fmt.Errorf("find users: %v", err)
+	}
+
+	count := 0
+	for _, u := range users {
+		newName := strings.TrimRight(u.Name, ". ")
+		if err := ChangeUserName(u, newName); err != nil {
+			if err == ErrNameEmpty || err == ErrUserNameIllegal || IsErrNameReserved(err) ||
+				IsErrNamePatternNotAllowed(err) || IsErrUserAlreadyExist(err) {
+				log.Warn("Cannot trim user name '%s': %v", u.Name, err)
+				continue
+			}
+			return count, fmt.Errorf("ChangeUserName [%d]: %v", u.ID, err)
+		}
+		count++
+	}
+	return count, nil
+}
+
+const _MAX_PROFILE_FIELD_LENGTH = 255
+
+// ValidateUserProfile checks if profile fields of user exceed their length limits.
+// UpdateUser silently truncates those fields, so callers that want to warn
+// the user instead of losing data should call this method first.
+func ValidateUserProfile(u *User) error {
+	for _, field := range []struct {
+		name, value string
+	}{
+		{"location", u.Location},
+		{"website", u.Website},
+		{"description", u.Description},
+	} {
+		if len(field.value) > _MAX_PROFILE_FIELD_LENGTH {
+			return ErrUserProfileTooLong{field.name, _MAX_PROFILE_FIELD_LENGTH}
+		}
+	}
+	return nil
+}
+
+// NormalizeWebsiteURL returns website URL with "https://" scheme added when scheme is missing,
+// URLs with schemes other than HTTP(S) are rejected. Empty URL is kept as-is.
+func NormalizeWebsiteURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if len(raw) == 0 {
+		return "", nil
+	}
+
+	if !strings.Contains(raw, "://") {
+		// Colon before path is either a port or an opaque scheme like "javascript:".
+		host := raw
+		if i := strings.IndexAny(raw, "/?#"); i >= 0 {
+			host = raw[:i]
+		}
+		if i := strings.Index(host, ":"); i >= 0 && (i+1 == len(host) || host[i+1] < '0' || host[i+1] > '9') {
+			return "", ErrInvalidWebsiteURL{raw}
+		}
+		raw = "https://" + raw
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return "", ErrInvalidWebsiteURL{raw}
+	}
+	return u.String(), nil
+}
+
+// isVerifiedAvatarEmail returns true if avatar email of user is its primary email
+// or one of given activated email addresses.
+func isVerifiedAvatarEmail(u *User, activated []*EmailAddress) bool {
+	if strings.EqualFold(u.AvatarEmail, u.Email) {
+		return true
+	}
+	for i := range activated {
+		if activated[i].IsActivated && strings.EqualFold(u.AvatarEmail, activated[i].Email) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckAvatarEmail returns error if avatar email of user is not verified
+// when it is required by setting. It should be called only when avatar email
+// is being changed, so a value set before it is required stays usable.
+func CheckAvatarEmail(u *User) error {
+	return checkAvatarEmail(x, u)
+}
+
+func checkAvatarEmail(e Engine, u *User) error {
+	if !setting.RequireVerifiedAvatarEmail || strings.EqualFold(u.AvatarEmail, u.Email) {
+		return nil
+	}
+
+	activated := make([]*EmailAddress, 0, 5)
+	if err := e.Where("uid=?", u.ID).And("is_activated=?", true).Find(&activated); err != nil {
+		return fmt.Errorf("find activated emails: %v", err)
+	} else if !isVerifiedAvatarEmail(u, activated) {
+		return ErrAvatarEmailNotVerified{u.AvatarEmail}
+	}
+	return nil
+}
+
+func updateUser(e Engine, u *User) error {
+	// Organization does not need email
+	if !u.IsOrganization() {
+		u.Email = strings.ToLower(u.Email)
+		has, err := e.Where("id!=?", u.ID).And("type=?", u.Type).And("email=?", u.Email).Get(new(User))
+		if err != nil {
+			return err
+		} else if has {
+			return ErrEmailAlreadyUsed{u.Email}
+		}
+
+		if len(u.AvatarEmail) == 0 {
+			u.AvatarEmail = u.Email
+		}
+		u.Avatar = base.HashEmail(u.AvatarEmail)
+	}
+
+	u.LowerName = strings.ToLower(u.Name)
+	u.Location = base.TruncateString(u.Location, _MAX_PROFILE_FIELD_LENGTH)
+	u.Website = base.TruncateString(u.Website, _MAX_PROFILE_FIELD_LENGTH)
+	u.Description = base.TruncateString(u.Description, _MAX_PROFILE_FIELD_LENGTH)
+
+	u.FullName = markdown.Sanitizer.Sanitize(u.FullName)
+	_, err := e.Id(u.ID).AllCols().Update(u)
+	return err
+}
 
 // UpdateUser updates user's information.
 func UpdateUser(u *User) error {
 	return updateUser(x, u)
 }
 
+// UpdateUserProfile updates user's information edited by user or admin,
+// website URL is normalized and must be a valid HTTP(S) URL.
+// Other changes should use UpdateUser so existing values never block them.
+func UpdateUserProfile(u *User) error {
+	website, err := NormalizeWebsiteURL(u.Website)
+	if err != nil {
+		return err
+	}
+	u.Website = website
+	return updateUser(x, u)
+}
+
+// SetHideEmailForAll sets option of hiding e-mail in Git commits for all users,
+// it returns number of users changed. It is meant to be used once when
+// default privacy setting of instance is changed.
+func SetHideEmailForAll(hide bool) (int, error) {
+	sess := x.Where("type=?", USER_TYPE_INDIVIDUAL)
+	if hide {
+		// Column could be NULL for users created before it is added.
+		sess.And("(hide_email=? OR hide_email IS NULL)", false)
+	} else {
+		sess.And("hide_email=?", true)
+	}
+	affected, err := sess.Cols("hide_email").UseBool("hide_email").Update(&User{HideEmail: hide})
+	return int(affected), err
+}
+
+// SetMustChangePassword sets whether user must change password after next sign in.
+func SetMustChangePassword(u *User, must bool) error {
+	u.MustChangePassword = must
+	_, err := x.Id(u.ID).Cols("must_change_password").UseBool("must_change_password").Update(u)
+	return err
+}
+
+// UserThemes is the list of UI themes that users can choose from,
+// every theme must have its stylesheet shipped in public/css.
+var UserThemes = []string{"gogs"}
+
+func isValidTheme(theme string) bool {
+	if len(theme) == 0 {
+		return true
+	}
+	for i := range UserThemes {
+		if UserThemes[i] == theme {
+			return true
+		}
+	}
+	return false
+}
+
+// SetUserTheme sets UI theme preference of user, empty theme means instance default.
+func SetUserTheme(u *User, theme string) error {
+	if !isValidTheme(theme) {
+		return ErrThemeNotExist{theme}
+	}
+
+	u.Theme = theme
+	_, err := x.Id(u.ID).Cols("theme").Update(u)
+	return err
+}
+
+// SetAllowGitHook sets whether user can edit Git hooks. Git hooks are executed
+// on the server, so it must only be called on behalf of site admins.
+func SetAllowGitHook(u *User, allow bool) error {
+	u.AllowGitHook = allow
+	_, err := x.Id(u.ID).Cols("allow_git_hook").UseBool("allow_git_hook").Update(u)
+	return err
+}
+
+// GetUsersAllowedGitHooks returns all users who are explicitly allowed to edit Git hooks,
+// admins can always edit Git hooks and are not included unless the flag is set.
+func GetUsersAllowedGitHooks() ([]*User, error) {
+	users := make([]*User, 0, 10)
+	return users, x.Where("allow_git_hook=?", true).And("is_deleted=?", false).Asc("id").Find(&users)
+}
+
+var e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// encryptPhone validates given phone number is in E.164 format
+// and returns it encrypted. Empty number is returned as is.
+func encryptPhone(phone string) (string, error) {
+	if len(phone) == 0 {
+		return "", nil
+	} else if !e164Pattern.MatchString(phone) {
+		return "", ErrInvalidPhoneNumber{phone}
+	}
+	return base.EncryptSecret(phone)
+}
+
+// PhoneNumber returns decrypted phone number of user.
+func (u *User) PhoneNumber() (string, error) {
+	if len(u.Phone) == 0 {
+		return "", nil
+	}
+	return base.DecryptSecret(u.Phone)
+}
+
+// SetUserPhone sets phone number of user used for SMS verification,
+// the number must be in E.164 format and is stored encrypted. Empty number clears it.
+func SetUserPhone(u *User, phone string) (err error) {
+	encrypted, err := encryptPhone(strings.Replace(phone, " ", "", -1))
+	if err != nil {
+		return err
+	}
+
+	u.Phone = encrypted
+	_, err = x.Id(u.ID).Cols("phone").Update(u)
+	return err
+}
+
+// SetProfileRepo sets repository whose README is shown on profile page of user,
+// the repository must be public and owned by the user. Empty name clears it.
+func SetProfileRepo(u *User, repoName string) error {
+	if len(repoName) > 0 {
+		repo, err := GetRepositoryByName(u.ID, repoName)
+		if err != nil {
+			return err
+		} else if repo.IsPrivate {
+			return ErrRepoNotExist{repo.ID, u.ID, repoName}
+		}
+		repoName = repo.Name
+	}
+
+	u.ProfileRepo = repoName
+	_, err := x.Id(u.ID).Cols("profile_repo").Update(u)
+	return err
+}
+
+// SetDefaultRepoVisibility sets personal default visibility of new repository.
+func SetDefaultRepoVisibility(u *User, private bool) error {
+	u.DefaultRepoPrivate = private
+	_, err := x.Id(u.ID).Cols("default_repo_private").UseBool("default_repo_private").Update(u)
+	return err
+}
+
+// EffectiveSessionLifetime returns maximum session lifetime of user,
+// per-user setting takes precedence over global default.
+func EffectiveSessionLifetime(u *User) time.Duration {
+	if u.SessionMaxLifetime > 0 {
+		return time.Duration(u.SessionMaxLifetime) * time.Second
+	}
+	return time.Duration(setting.SessionConfig.Maxlifetime) * time.Second
+}
+
+// InvalidateUserSessions rotates rands and increases session epoch of given user,
+// so all existing sessions and remember cookies are no longer valid.
+func InvalidateUserSessions(uid int64) error {
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err := sess.Begin(); err != nil {
+		return err
+	}
+
+	if _, err := sess.Id(uid).Cols("rands").Update(&User{Rands: GetUserSalt()}); err != nil {
+		return fmt.Errorf("update rands: %v", err)
+	} else if _, err = sess.Exec("UPDATE `user` SET session_epoch = session_epoch + 1 WHERE id = ?", uid); err != nil {
+		return fmt.Errorf("increase session epoch: %v", err)
+	}
+
+	return sess.Commit()
+}
+
+// IsValidSessionEpoch returns true if session signed in at given epoch of user
+// has not been invalidated since, e.g. by change or reset of password.
+func (u *User) IsValidSessionEpoch(epoch int) bool {
+	return epoch == u.SessionEpoch
+}
+
+const _MIN_PASSWORD_LENGTH = 6
+
+// validatePasswordStrength returns error if given password is too weak to be used.
+func validatePasswordStrength(passwd string) error {
+	if utf8.RuneCountInString(strings.TrimSpace(passwd)) < _MIN_PASSWORD_LENGTH {
+		return ErrPasswordTooWeak{_MIN_PASSWORD_LENGTH}
+	}
+	return nil
+}
+
+// resetPassword encodes new password with a new salt, rotates rands
+// and requires user to change password after next sign in.
+func (u *User) resetPassword(passwd string) error {
+	u.Passwd = passwd
+	u.Salt = GetUserSalt()
+	if err := u.EncodePasswd(); err != nil {
+		return err
+	}
+	u.Rands = GetUserSalt()
+	u.MustChangePassword = true
+	return nil
+}
+
+// AdminResetPassword sets new password of user on behalf of a site admin,
+// the user must change password after next sign in and all existing sessions are invalidated.
+func AdminResetPassword(uid int64, newPasswd string) (err error) {
+	if err = validatePasswordStrength(newPasswd); err != nil {
+		return err
+	}
+
+	u, err := GetUserByID(uid)
+	if err != nil {
+		return err
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	if err = u.resetPassword(newPasswd); err != nil {
+		return err
+	} else if _, err = sess.Id(u.ID).Cols(passwdCols("rands", "must_change_password")...).
+		UseBool("must_change_password").Update(u); err != nil {
+		return fmt.Errorf("update password: %v", err)
+	} else if _, err = sess.Exec("UPDATE `user` SET session_epoch = session_epoch + 1 WHERE id = ?", u.ID); err != nil {
+		return fmt.Errorf("increase session epoch: %v", err)
+	} else if err = u.resetFailedLogins(sess); err != nil {
+		return fmt.Errorf("reset failed logins: %v", err)
+	} else if _, err = sess.Insert(&Notice{
+		Type:        NOTICE_USER,
+		Description: fmt.Sprintf("Password of user '%s' has been reset by admin", u.Name),
+	}); err != nil {
+		return fmt.Errorf("insert notice: %v", err)
+	}
+
+	return sess.Commit()
+}
+
+// RotateAllUserRands gives every user new random rands and increases session epoch,
+// it returns number of users changed. Password hashes are not touched,
+// but all outstanding codes and sessions become invalid. All users are changed
+// in a single transaction so a failure leaves no user half rotated.
+func RotateAllUserRands() (rotated int, err error) {
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return 0, err
+	}
+
+	const batchSize = 100
+	for start := 0; ; start += batchSize {
+		users := make([]*User, 0, batchSize)
+		if err = sess.Cols("id").Limit(batchSize, start).Asc("id").Find(&users); err != nil {
+			return 0, fmt.Errorf("find users: %v", err)
+		}
+
+		for _, u := range users {
+			if _, err = sess.Exec("UPDATE `user` SET rands = ?, session_epoch = session_epoch + 1 WHERE id = ?",
+				GetUserSalt(), u.ID); err != nil {
+				return 0, fmt.Errorf("rotate rands [%d]: %v", u.ID, err)
+			}
+			rotated++
+		}
+
+		if len(users) < batchSize {
+			break
+		}
+	}
+
+	if err = sess.Commit(); err != nil {
+		return 0, err
+	}
+	return rotated, nil
+}
+
+// avatarHash returns avatar hash of user computed by current scheme.
+func (u *User) avatarHash() string {
+	if len(u.AvatarEmail) == 0 {
+		return base.HashEmail(u.Email)
+	}
+	return base.HashEmail(u.AvatarEmail)
+}
+
+// RefreshAllAvatarHashes recomputes stored avatar hashes of all users,
+// it returns number of users updated.
+func RefreshAllAvatarHashes() (updated int, err error) {
+	const batchSize = 100
+	for start := 0; ; start += batchSize {
+		users := make([]*User, 0, batchSize)
+		if err = x.Cols("id", "email", "avatar_email", "avatar").
+			Limit(batchSize, start).Asc("id").Find(&users); err != nil {
+			return updated, fmt.Errorf("find users: %v", err)
+		}
+
+		for _, u := range users {
+			hash := u.avatarHash()
+			if u.Avatar == hash {
+				continue
+			}
+			if _, err = x.Id(u.ID).Cols("avatar").Update(&User{Avatar: hash}); err != nil {
+				return updated, fmt.Errorf("update avatar [%d]: %v", u.ID, err)
+			}
+			updated++
+		}
+
+		if len(users) < batchSize {
+			return updated, nil
+		}
+	}
+}
+
+// RepairLowerNames recomputes lower names of users that do not match their names,
+// e.g. rows inserted by migrations, it returns number of users fixed.
+func RepairLowerNames() (fixed int, err error) {
+	const batchSize = 100
+	for start := 0; ; start += batchSize {
+		users := make([]*User, 0, batchSize)
+		if err = x.Cols("id", "name", "lower_name").
+			Limit(batchSize, start).Asc("id").Find(&users); err != nil {
+			return fixed, fmt.Errorf("find users: %v", err)
+		}
+
+		for _, u := range users {
+			lowerName := strings.ToLower(u.Name)
+			if u.LowerName == lowerName {
+				continue
+			}
+			if _, err = x.Id(u.ID).Cols("lower_name").Update(&User{LowerName: lowerName}); err != nil {
+				return fixed, fmt.Errorf("update lower name [%d]: %v", u.ID, err)
+			}
+			fixed++
+		}
+
+		if len(users) < batchSize {
+			return fixed, nil
+		}
+	}
+}
+
+// HashGroup represents a group of users sharing the same password hash and salt.
+type HashGroup struct {
+	Users []*User
+}
+
+// FindUsersWithSharedPasswordHash returns groups of users whose password hash
+// and salt are identical, which usually indicates a problem of data import.
+func FindUsersWithSharedPasswordHash() ([]HashGroup, error) {
+	results, err := x.Query("SELECT passwd, salt FROM `user` WHERE type=? AND is_deleted=? AND passwd!='' GROUP BY passwd, salt HAVING COUNT(*)>1",
+		USER_TYPE_INDIVIDUAL, false)
+	if err != nil {
+		return nil, fmt.Errorf("find shared hashes: %v", err)
+	}
+
+	groups := make([]HashGroup, 0, len(results))
+	for _, result := range results {
+		users := make([]*User, 0, 2)
+		if err = x.Where("type=?", USER_TYPE_INDIVIDUAL).And("is_deleted=?", false).
+			And("passwd=?", string(result["passwd"])).
+			And("salt=?", string(result["salt"])).Asc("id").Find(&users); err != nil {
+			return nil, fmt.Errorf("find users: %v", err)
+		}
+		groups = append(groups, HashGroup{users})
+	}
+	return groups, nil
+}
+
 // deleteBeans deletes all given beans, beans should contain delete conditions.
 func deleteBeans(e Engine, beans ...interface{}) (err error) {
 	for i := range beans {
@@ -693,10 +2484,9 @@ func deleteBeans(e Engine, beans ...interface{}) (err error) {
 }
 
 // FIXME: need some kind of mechanism to record failure. HINT: system notice
-func deleteUser(e *xorm.Session, u *User) error {
-	// Note: A user owns any repository or belongs to any organization
-	//	cannot perform delete operation.
-
+// checkUserDeletable returns error if user owns any repository or
+// belongs to any organization, which cannot be deleted.
+func checkUserDeletable(e Engine, u *User) error {
 	// Check ownership of repository.
 	count, err := getRepositoryCount(e, u)
 	if err != nil {
@@ -712,6 +2502,15 @@ func deleteUser(e *xorm.Session, u *User) error {
 	} else if count > 0 {
 		return ErrUserHasOrgs{UID: u.ID}
 	}
+	return nil
+}
+
+func deleteUser(e *xorm.Session, u *User) (err error) {
+	// Note: A user owns any repository or belongs to any organization
+	//	cannot perform delete operation.
+	if err = checkUserDeletable(e, u); err != nil {
+		return err
+	}
 
 	// ***** START: Watch *****
 	watches := make([]*Watch, 0, 10)
@@ -737,17 +2536,9 @@ func deleteUser(e *xorm.Session, u *User) error {
 	}
 	// ***** END: Star *****
 
-	// ***** START: Follow *****
-	followers := make([]*Follow, 0, 10)
-	if err = e.Find(&followers, &Follow{UserID: u.ID}); err != nil {
-		return fmt.Errorf("get all followers: %v", err)
-	}
-	for i := range followers {
-		if _, err = e.Exec("UPDATE `user` SET num_followers=num_followers-1 WHERE id=?", followers[i].UserID); err != nil {
-			return fmt.Errorf("decrease user follower number[%d]: %v", followers[i].UserID, err)
-		}
+	if err = purgeUserFollows(e, u.ID); err != nil {
+		return fmt.Errorf("purgeUserFollows: %v", err)
 	}
-	// ***** END: Follow *****
 
 	if err = deleteBeans(e,
 		&AccessToken{UID: u.ID},
@@ -755,10 +2546,10 @@ func deleteUser(e *xorm.Session, u *User) error {
 		&Access{UserID: u.ID},
 		&Watch{UserID: u.ID},
 		&Star{UID: u.ID},
-		&Follow{FollowID: u.ID},
 		&Action{UserID: u.ID},
 		&IssueUser{UID: u.ID},
 		&EmailAddress{UID: u.ID},
+		&ExternalLoginUser{UID: u.ID},
 	); err != nil {
 		return fmt.Errorf("deleteBeans: %v", err)
 	}
@@ -786,15 +2577,22 @@ func deleteUser(e *xorm.Session, u *User) error {
 	if _, err = e.Id(u.ID).Delete(new(User)); err != nil {
 		return fmt.Errorf("Delete: %v", err)
 	}
+	// Name of soft-deleted user has been recorded when it was marked.
+	if !u.IsDeleted {
+		if err = recordDeletedUserName(e, u.Name); err != nil {
+			return fmt.Errorf("recordDeletedUserName: %v", err)
+		}
+	}
 
-	// FIXME: system notice
-	// Note: There are something just cannot be roll back,
-	//	so just keep error logs of those operations.
-
+	return nil
+}
+
+// removeFiles removes repository directory and custom avatar of deleted user.
+// Files cannot be rolled back, so it must only be called after deletion is committed.
+func (u *User) removeFiles() {
+	// FIXME: system notice
 	os.RemoveAll(UserPath(u.Name))
 	os.Remove(u.CustomAvatarPath())
-
-	return nil
 }
 
 // DeleteUser completely and permanently deletes everything of a user,
@@ -811,37 +2609,748 @@ func DeleteUser(u *User) (err error) {
 		return err
 	}
 
-	if err = sess.Commit(); err != nil {
-		return err
+	if err = sess.Commit(); err != nil {
+		return err
+	}
+	u.removeFiles()
+
+	return RewriteAllPublicKeys()
+}
+
+// deletedUserName returns name given to soft-deleted user to free its original name,
+// it is never a legal user name so it cannot be taken by others.
+func deletedUserName(uid int64) string {
+	return fmt.Sprintf("deleted~%d", uid)
+}
+
+// markDeleted sets fields of user to soft-deleted state at given time.
+func (u *User) markDeleted(now time.Time) {
+	u.IsDeleted = true
+	u.Deleted = now
+	u.DeletedUnix = now.Unix()
+	u.Name = deletedUserName(u.ID)
+	u.LowerName = u.Name
+	u.Email = ""
+	u.AvatarEmail = ""
+	u.Passwd = ""
+	u.Rands = GetUserSalt()
+	u.IsActive = false
+	u.ProhibitLogin = true
+	u.SessionEpoch++
+}
+
+// isReapable returns true if soft-deleted user has been retained
+// for given duration at given time.
+func (u *User) isReapable(now time.Time, retention time.Duration) bool {
+	return u.IsDeleted && !now.Before(time.Unix(u.DeletedUnix, 0).Add(retention))
+}
+
+// MarkUserDeleted soft-deletes a user. The account is hidden and cannot sign in,
+// its name and emails are freed, but the row is kept so issues and comments
+// are still attributed to it until ReapDeletedUsers deletes it permanently.
+func MarkUserDeleted(u *User) (err error) {
+	if u.IsDeleted {
+		return nil
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	if err = checkUserDeletable(sess, u); err != nil {
+		return err
+	}
+
+	if err = purgeUserFollows(sess, u.ID); err != nil {
+		return fmt.Errorf("purgeUserFollows: %v", err)
+	} else if err = deleteBeans(sess,
+		&AccessToken{UID: u.ID},
+		&EmailAddress{UID: u.ID},
+		&ExternalLoginUser{UID: u.ID},
+	); err != nil {
+		return fmt.Errorf("deleteBeans: %v", err)
+	}
+
+	keys := make([]*PublicKey, 0, 10)
+	if err = sess.Find(&keys, &PublicKey{OwnerID: u.ID}); err != nil {
+		return fmt.Errorf("get all public keys: %v", err)
+	}
+	keyIDs := make([]int64, len(keys))
+	for i := range keys {
+		keyIDs[i] = keys[i].ID
+	}
+	if err = deletePublicKeys(sess, keyIDs...); err != nil {
+		return fmt.Errorf("deletePublicKeys: %v", err)
+	}
+
+	oldName := u.Name
+	if err = recordDeletedUserName(sess, oldName); err != nil {
+		return fmt.Errorf("recordDeletedUserName: %v", err)
+	}
+
+	u.markDeleted(time.Now())
+	if _, err = sess.Id(u.ID).Cols(passwdCols("is_deleted", "deleted_unix", "name", "lower_name", "email", "avatar_email",
+		"rands", "is_active", "prohibit_login", "session_epoch")...).
+		UseBool("is_deleted", "is_active", "prohibit_login").Update(u); err != nil {
+		return fmt.Errorf("update user: %v", err)
+	}
+
+	if err = sess.Commit(); err != nil {
+		return err
+	}
+
+	os.RemoveAll(UserPath(oldName))
+	return RewriteAllPublicKeys()
+}
+
+// DeleteUserAccount deletes user on behalf of user or admin, the user is
+// soft-deleted when it is enabled, otherwise it is deleted permanently.
+func DeleteUserAccount(u *User) error {
+	if setting.Service.SoftDeleteUsers {
+		return MarkUserDeleted(u)
+	}
+	return DeleteUser(u)
+}
+
+// ReapDeletedUsers permanently deletes users that have been soft-deleted for longer
+// than retention period, it returns number of users deleted.
+func ReapDeletedUsers() (int, error) {
+	retention := time.Duration(setting.Service.DeletedUserRetentionDays) * 24 * time.Hour
+	users := make([]*User, 0, 10)
+	if err := x.Where("is_deleted=?", true).And("deleted_unix<=?", time.Now().Add(-retention).Unix()).
+		Find(&users); err != nil {
+		return 0, fmt.Errorf("find deleted users: %v", err)
+	}
+
+	reaped := 0
+	now := time.Now()
+	for _, u := range users {
+		if !u.isReapable(now, retention) {
+			continue
+		}
+		if err := DeleteUser(u); err != nil {
+			return reaped, fmt.Errorf("DeleteUser [%d]: %v", u.ID, err)
+		}
+		reaped++
+	}
+	return reaped, nil
+}
+
+// ReapDeletedUsersTask permanently deletes users whose retention period has passed,
+// it is meant to be run by cron.
+func ReapDeletedUsersTask() {
+	if taskStatusPool.IsRunning(_REAP_USERS) {
+		return
+	}
+	taskStatusPool.Start(_REAP_USERS)
+	defer taskStatusPool.Stop(_REAP_USERS)
+
+	log.Trace("Doing: ReapDeletedUsers")
+
+	if reaped, err := ReapDeletedUsers(); err != nil {
+		log.Error(4, "ReapDeletedUsers: %v", err)
+	} else {
+		log.Trace("ReapDeletedUsers: %d users deleted", reaped)
+	}
+}
+
+// DeletionImpact represents numbers of records that are affected by deleting a user.
+type DeletionImpact struct {
+	NumRepos     int64
+	NumOrgs      int64
+	NumFollowers int64
+	NumFollowing int64
+	NumIssues    int64 // Kept and shown as posted by deleted user
+	NumComments  int64 // Kept and shown as posted by deleted user
+	NumStars     int64
+	NumKeys      int64
+}
+
+// CanDelete returns true if the user can be deleted,
+// a user owns any repository or belongs to any organization cannot be deleted.
+func (impact *DeletionImpact) CanDelete() bool {
+	return impact.NumRepos == 0 && impact.NumOrgs == 0
+}
+
+// UserDeletionImpact returns numbers of records that are affected by deleting given user.
+func UserDeletionImpact(uid int64) (_ *DeletionImpact, err error) {
+	impact := new(DeletionImpact)
+	for _, c := range []struct {
+		count *int64
+		cond  string
+		bean  interface{}
+	}{
+		{&impact.NumRepos, "owner_id=?", new(Repository)},
+		{&impact.NumOrgs, "uid=?", new(OrgUser)},
+		{&impact.NumFollowers, "follow_id=?", new(Follow)},
+		{&impact.NumFollowing, "user_id=?", new(Follow)},
+		{&impact.NumIssues, "poster_id=?", new(Issue)},
+		{&impact.NumComments, "poster_id=?", new(Comment)},
+		{&impact.NumStars, "uid=?", new(Star)},
+		{&impact.NumKeys, "owner_id=?", new(PublicKey)},
+	} {
+		if *c.count, err = x.Where(c.cond, uid).Count(c.bean); err != nil {
+			return nil, fmt.Errorf("count %T: %v", c.bean, err)
+		}
+	}
+	return impact, nil
+}
+
+// DeleteInactivateUsers deletes all inactivate users and email addresses.
+func DeleteInactivateUsers() (err error) {
+	users := make([]*User, 0, 10)
+	// Soft-deleted users are inactive as well but permanently deleted by ReapDeletedUsers
+	// only after retention period.
+	if err = x.Where("is_active = ?", false).And("is_deleted = ?", false).Find(&users); err != nil {
+		return fmt.Errorf("get all inactive users: %v", err)
+	}
+	// FIXME: should only update authorized_keys file once after all deletions.
+	for _, u := range users {
+		if err = DeleteUser(u); err != nil {
+			// Ignore users that were set inactive by admin.
+			if IsErrUserOwnRepos(err) || IsErrUserHasOrgs(err) {
+				continue
+			}
+			return err
+		}
+	}
+
+	_, err = x.Where("is_activated = ?", false).Delete(new(EmailAddress))
+	return err
+}
+
+// mergeOrgMemberships moves organization and team memberships of merged user to kept user.
+func mergeOrgMemberships(e *xorm.Session, keep, merge *User) error {
+	teamUsers := make([]*TeamUser, 0, 10)
+	if err := e.Where("uid=?", merge.ID).Find(&teamUsers); err != nil {
+		return fmt.Errorf("get team-users: %v", err)
+	}
+	for _, tu := range teamUsers {
+		if isTeamMember(e, tu.OrgID, tu.TeamID, keep.ID) {
+			if _, err := e.Id(tu.ID).Delete(new(TeamUser)); err != nil {
+				return fmt.Errorf("delete team-user: %v", err)
+			} else if _, err = e.Exec("UPDATE `team` SET num_members=num_members-1 WHERE id=?", tu.TeamID); err != nil {
+				return fmt.Errorf("decrease team member number[%d]: %v", tu.TeamID, err)
+			}
+		} else if _, err := e.Exec("UPDATE `team_user` SET uid=? WHERE id=?", keep.ID, tu.ID); err != nil {
+			return fmt.Errorf("move team-user: %v", err)
+		}
+	}
+
+	orgUsers := make([]*OrgUser, 0, 10)
+	if err := e.Where("uid=?", merge.ID).Find(&orgUsers); err != nil {
+		return fmt.Errorf("get org-users: %v", err)
+	}
+	for _, ou := range orgUsers {
+		keepOU := new(OrgUser)
+		has, err := e.Where("uid=?", keep.ID).And("org_id=?", ou.OrgID).Get(keepOU)
+		if err != nil {
+			return fmt.Errorf("get org-user: %v", err)
+		}
+
+		if has {
+			if _, err = e.Id(ou.ID).Delete(new(OrgUser)); err != nil {
+				return fmt.Errorf("delete org-user: %v", err)
+			} else if _, err = e.Exec("UPDATE `user` SET num_members=num_members-1 WHERE id=?", ou.OrgID); err != nil {
+				return fmt.Errorf("decrease organization member number[%d]: %v", ou.OrgID, err)
+			}
+			keepOU.IsOwner = keepOU.IsOwner || ou.IsOwner
+			keepOU.IsPublic = keepOU.IsPublic || ou.IsPublic
+		} else {
+			keepOU = ou
+			keepOU.Uid = keep.ID
+		}
+
+		numTeams, err := e.Where("uid=?", keep.ID).And("org_id=?", ou.OrgID).Count(new(TeamUser))
+		if err != nil {
+			return fmt.Errorf("count team-users: %v", err)
+		}
+		keepOU.NumTeams = int(numTeams)
+		if _, err = e.Id(keepOU.ID).AllCols().Update(keepOU); err != nil {
+			return fmt.Errorf("update org-user: %v", err)
+		}
+
+		// Give access to team repositories.
+		teams, err := getUserTeams(e, ou.OrgID, keep.ID)
+		if err != nil {
+			return fmt.Errorf("getUserTeams: %v", err)
+		}
+		for _, t := range teams {
+			if err = t.getRepositories(e); err != nil {
+				return fmt.Errorf("getRepositories: %v", err)
+			}
+			for _, repo := range t.Repos {
+				if err = repo.recalculateTeamAccesses(e, 0); err != nil {
+					return fmt.Errorf("recalculateTeamAccesses: %v", err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// planMergeFollows returns follow relations of merged user that should be pointed
+// to kept user and those should be deleted, given follows must include all relations
+// of both users. A relation is deleted if it would become a duplicate or a self-follow.
+func planMergeFollows(follows []*Follow, keepUID, mergeUID int64) (moves, deletes []*Follow) {
+	type pair struct{ userID, followID int64 }
+	existing := make(map[pair]bool, len(follows))
+	for _, f := range follows {
+		if f.UserID != mergeUID && f.FollowID != mergeUID {
+			existing[pair{f.UserID, f.FollowID}] = true
+		}
+	}
+
+	for _, f := range follows {
+		if f.UserID != mergeUID && f.FollowID != mergeUID {
+			continue
+		}
+
+		// Point the relation to kept user.
+		p := pair{f.UserID, f.FollowID}
+		if p.userID == mergeUID {
+			p.userID = keepUID
+		}
+		if p.followID == mergeUID {
+			p.followID = keepUID
+		}
+
+		if p.userID == p.followID || existing[p] {
+			deletes = append(deletes, f)
+			continue
+		}
+		existing[p] = true
+		moves = append(moves, &Follow{ID: f.ID, UserID: p.userID, FollowID: p.followID})
+	}
+	return moves, deletes
+}
+
+// MergeFollows moves follow relations of merged user to kept user within given session
+// without creating duplicates or self-follows, and recalculates affected counters.
+func MergeFollows(keepUID, mergeUID int64, sess *xorm.Session) error {
+	follows := make([]*Follow, 0, 10)
+	if err := sess.Where("user_id=? OR user_id=?", keepUID, mergeUID).
+		Or("follow_id=? OR follow_id=?", keepUID, mergeUID).Find(&follows); err != nil {
+		return fmt.Errorf("get follows: %v", err)
+	}
+
+	moves, deletes := planMergeFollows(follows, keepUID, mergeUID)
+	for _, f := range deletes {
+		if _, err := sess.Id(f.ID).Delete(new(Follow)); err != nil {
+			return fmt.Errorf("delete follow: %v", err)
+		}
+
+		// Counters of kept user are recalculated below.
+		var err error
+		if f.UserID == mergeUID && f.FollowID != keepUID {
+			_, err = sess.Exec("UPDATE `user` SET num_followers=num_followers-1 WHERE id=?", f.FollowID)
+		} else if f.FollowID == mergeUID && f.UserID != keepUID {
+			_, err = sess.Exec("UPDATE `user` SET num_following=num_following-1 WHERE id=?", f.UserID)
+		}
+		if err != nil {
+			return fmt.Errorf("decrease follow counters: %v", err)
+		}
+	}
+	for _, f := range moves {
+		if _, err := sess.Exec("UPDATE `follow` SET user_id=?, follow_id=? WHERE id=?", f.UserID, f.FollowID, f.ID); err != nil {
+			return fmt.Errorf("move follow: %v", err)
+		}
+	}
+
+	if _, err := sess.Exec("UPDATE `user` SET num_followers=(SELECT COUNT(*) FROM `follow` WHERE follow_id=?) WHERE id=?", keepUID, keepUID); err != nil {
+		return fmt.Errorf("recalculate follower number: %v", err)
+	} else if _, err = sess.Exec("UPDATE `user` SET num_following=(SELECT COUNT(*) FROM `follow` WHERE user_id=?) WHERE id=?", keepUID, keepUID); err != nil {
+		return fmt.Errorf("recalculate following number: %v", err)
+	}
+	return nil
+}
+
+// mergeStars moves stars of merged user to kept user without creating duplicates.
+func mergeStars(e *xorm.Session, keep, merge *User) error {
+	stars := make([]*Star, 0, 10)
+	if err := e.Find(&stars, &Star{UID: merge.ID}); err != nil {
+		return fmt.Errorf("get stars: %v", err)
+	}
+	for _, star := range stars {
+		has, err := e.Get(&Star{UID: keep.ID, RepoID: star.RepoID})
+		if err != nil {
+			return fmt.Errorf("get star: %v", err)
+		} else if !has {
+			if _, err = e.Exec("UPDATE `star` SET uid=? WHERE id=?", keep.ID, star.ID); err != nil {
+				return fmt.Errorf("move star: %v", err)
+			}
+			continue
+		}
+
+		if _, err = e.Id(star.ID).Delete(new(Star)); err != nil {
+			return fmt.Errorf("delete star: %v", err)
+		} else if _, err = e.Exec("UPDATE `repository` SET num_stars=num_stars-1 WHERE id=?", star.RepoID); err != nil {
+			return fmt.Errorf("decrease repository star number[%d]: %v", star.RepoID, err)
+		}
+	}
+
+	if _, err := e.Exec("UPDATE `user` SET num_stars=(SELECT COUNT(*) FROM `star` WHERE uid=?) WHERE id=?", keep.ID, keep.ID); err != nil {
+		return fmt.Errorf("recalculate star number: %v", err)
+	}
+	return nil
+}
+
+// planMergeCollaborations returns collaborations of merged user that should be moved
+// to kept user, those should be deleted and collaborations of kept user whose mode
+// should be raised. A collaboration is deleted if kept user owns the repository
+// or already collaborates on it, kept user gets the higher mode of both.
+func planMergeCollaborations(keepCollabs, mergeCollabs []*Collaboration, ownedRepoIDs map[int64]bool) (moves, deletes, raises []*Collaboration) {
+	existing := make(map[int64]*Collaboration, len(keepCollabs))
+	for _, c := range keepCollabs {
+		existing[c.RepoID] = c
+	}
+
+	for _, c := range mergeCollabs {
+		if ownedRepoIDs[c.RepoID] {
+			deletes = append(deletes, c)
+			continue
+		}
+
+		keepCollab, has := existing[c.RepoID]
+		if !has {
+			moves = append(moves, c)
+			continue
+		}
+		deletes = append(deletes, c)
+		if c.Mode > keepCollab.Mode {
+			raises = append(raises, &Collaboration{ID: keepCollab.ID, RepoID: c.RepoID, UserID: keepCollab.UserID, Mode: c.Mode})
+		}
+	}
+	return moves, deletes, raises
+}
+
+// mergeCollaborations moves collaborations of merged user to kept user
+// without creating duplicates, and recalculates accesses of affected repositories.
+func mergeCollaborations(e *xorm.Session, keep, merge *User) error {
+	keepCollabs := make([]*Collaboration, 0, 10)
+	if err := e.Find(&keepCollabs, &Collaboration{UserID: keep.ID}); err != nil {
+		return fmt.Errorf("get collaborations of kept user: %v", err)
+	}
+	mergeCollabs := make([]*Collaboration, 0, 10)
+	if err := e.Find(&mergeCollabs, &Collaboration{UserID: merge.ID}); err != nil {
+		return fmt.Errorf("get collaborations of merged user: %v", err)
+	}
+	ownedRepos := make([]*Repository, 0, keep.NumRepos)
+	if err := e.Cols("id").Where("owner_id=?", keep.ID).Find(&ownedRepos); err != nil {
+		return fmt.Errorf("get repositories of kept user: %v", err)
+	}
+	ownedRepoIDs := make(map[int64]bool, len(ownedRepos))
+	for _, repo := range ownedRepos {
+		ownedRepoIDs[repo.ID] = true
+	}
+
+	moves, deletes, raises := planMergeCollaborations(keepCollabs, mergeCollabs, ownedRepoIDs)
+	for _, c := range deletes {
+		if _, err := e.Id(c.ID).Delete(new(Collaboration)); err != nil {
+			return fmt.Errorf("delete collaboration: %v", err)
+		}
+	}
+	for _, c := range moves {
+		if _, err := e.Exec("UPDATE `collaboration` SET user_id=? WHERE id=?", keep.ID, c.ID); err != nil {
+			return fmt.Errorf("move collaboration: %v", err)
+		}
+	}
+	for _, c := range raises {
+		if _, err := e.Exec("UPDATE `collaboration` SET mode=? WHERE id=?", c.Mode, c.ID); err != nil {
+			return fmt.Errorf("raise collaboration mode: %v", err)
+		}
+	}
+
+	for _, c := range mergeCollabs {
+		repo, err := getRepositoryByID(e, c.RepoID)
+		if err != nil {
+			return fmt.Errorf("getRepositoryByID [%d]: %v", c.RepoID, err)
+		} else if err = repo.recalculateAccesses(e); err != nil {
+			return fmt.Errorf("recalculateAccesses [%d]: %v", repo.ID, err)
+		}
+	}
+	return nil
+}
+
+// planMergeWatches returns watches of merged user that should be moved to kept user
+// and those should be deleted because kept user already watches the repository.
+func planMergeWatches(keepWatches, mergeWatches []*Watch) (moves, deletes []*Watch) {
+	watching := make(map[int64]bool, len(keepWatches))
+	for _, w := range keepWatches {
+		watching[w.RepoID] = true
+	}
+
+	for _, w := range mergeWatches {
+		if watching[w.RepoID] {
+			deletes = append(deletes, w)
+			continue
+		}
+		moves = append(moves, w)
+	}
+	return moves, deletes
+}
+
+// mergeWatches moves watches of merged user to kept user without creating duplicates.
+func mergeWatches(e *xorm.Session, keep, merge *User) error {
+	keepWatches := make([]*Watch, 0, 10)
+	if err := e.Find(&keepWatches, &Watch{UserID: keep.ID}); err != nil {
+		return fmt.Errorf("get watches of kept user: %v", err)
+	}
+	mergeWatches := make([]*Watch, 0, 10)
+	if err := e.Find(&mergeWatches, &Watch{UserID: merge.ID}); err != nil {
+		return fmt.Errorf("get watches of merged user: %v", err)
+	}
+
+	moves, deletes := planMergeWatches(keepWatches, mergeWatches)
+	for _, w := range deletes {
+		if _, err := e.Id(w.ID).Delete(new(Watch)); err != nil {
+			return fmt.Errorf("delete watch: %v", err)
+		} else if _, err = e.Exec("UPDATE `repository` SET num_watches=num_watches-1 WHERE id=?", w.RepoID); err != nil {
+			return fmt.Errorf("decrease repository watch number[%d]: %v", w.RepoID, err)
+		}
+	}
+	for _, w := range moves {
+		if _, err := e.Exec("UPDATE `watch` SET user_id=? WHERE id=?", keep.ID, w.ID); err != nil {
+			return fmt.Errorf("move watch: %v", err)
+		}
+	}
+	return nil
+}
+
+// MergeUsers moves repositories, organization memberships, collaborations, watches,
+// follows, stars, e-mail addresses and public keys of merged user to kept user, and then
+// deletes merged user. Nothing is changed when both users own a repository
+// with the same name.
+func MergeUsers(keepUID, mergeUID int64) (err error) {
+	if keepUID == mergeUID {
+		return fmt.Errorf("cannot merge user into itself [uid: %d]", keepUID)
+	}
+
+	keep, err := GetUserByID(keepUID)
+	if err != nil {
+		return fmt.Errorf("GetUserByID [%d]: %v", keepUID, err)
+	}
+	merge, err := GetUserByID(mergeUID)
+	if err != nil {
+		return fmt.Errorf("GetUserByID [%d]: %v", mergeUID, err)
+	}
+	if keep.IsOrganization() || merge.IsOrganization() {
+		return fmt.Errorf("cannot merge organizations [keep: %d, merge: %d]", keepUID, mergeUID)
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	repos := make([]*Repository, 0, merge.NumRepos)
+	if err = sess.Where("owner_id=?", merge.ID).Find(&repos); err != nil {
+		return fmt.Errorf("get repositories: %v", err)
+	}
+	for _, repo := range repos {
+		has, err := isRepositoryExist(sess, keep, repo.Name)
+		if err != nil {
+			return fmt.Errorf("isRepositoryExist: %v", err)
+		} else if has {
+			return ErrRepoAlreadyExist{keep.Name, repo.Name}
+		}
+	}
+
+	// ***** START: Repository *****
+	for _, repo := range repos {
+		repo.OwnerID = keep.ID
+		repo.Owner = keep
+		if _, err = sess.Id(repo.ID).Cols("owner_id").Update(repo); err != nil {
+			return fmt.Errorf("update owner: %v", err)
+		} else if _, err = sess.Delete(&Collaboration{RepoID: repo.ID, UserID: keep.ID}); err != nil {
+			return fmt.Errorf("remove collaborator: %v", err)
+		} else if err = repo.recalculateAccesses(sess); err != nil {
+			return fmt.Errorf("recalculateAccesses: %v", err)
+		} else if err = watchRepo(sess, keep.ID, repo.ID, true); err != nil {
+			return fmt.Errorf("watchRepo: %v", err)
+		}
+	}
+	if _, err = sess.Exec("UPDATE `user` SET num_repos=num_repos+? WHERE id=?", len(repos), keep.ID); err != nil {
+		return fmt.Errorf("increase repository number: %v", err)
+	}
+	// ***** END: Repository *****
+
+	if err = mergeOrgMemberships(sess, keep, merge); err != nil {
+		return fmt.Errorf("mergeOrgMemberships: %v", err)
+	} else if err = MergeFollows(keep.ID, merge.ID, sess); err != nil {
+		return fmt.Errorf("MergeFollows: %v", err)
+	} else if err = mergeStars(sess, keep, merge); err != nil {
+		return fmt.Errorf("mergeStars: %v", err)
+	} else if err = mergeCollaborations(sess, keep, merge); err != nil {
+		return fmt.Errorf("mergeCollaborations: %v", err)
+	} else if err = mergeWatches(sess, keep, merge); err != nil {
+		return fmt.Errorf("mergeWatches: %v", err)
+	}
+
+	// ***** START: EmailAddress *****
+	if _, err = sess.Exec("UPDATE `email_address` SET uid=? WHERE uid=?", keep.ID, merge.ID); err != nil {
+		return fmt.Errorf("move email addresses: %v", err)
+	}
+	used, err := isEmailUsed(sess, merge.Email)
+	if err != nil {
+		return fmt.Errorf("isEmailUsed: %v", err)
+	} else if !used && merge.Email != keep.Email {
+		if _, err = sess.Insert(&EmailAddress{
+			UID:         keep.ID,
+			Email:       merge.Email,
+			IsActivated: merge.IsActive,
+		}); err != nil {
+			return fmt.Errorf("insert email address: %v", err)
+		}
+	}
+	// ***** END: EmailAddress *****
+
+	if _, err = sess.Exec("UPDATE `public_key` SET owner_id=? WHERE owner_id=?", keep.ID, merge.ID); err != nil {
+		return fmt.Errorf("move public keys: %v", err)
+	}
+
+	// Repository directories have to be moved before merged user directory is removed.
+	os.MkdirAll(UserPath(keep.Name), os.ModePerm)
+	moved := make([]*Repository, 0, len(repos))
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, repo := range moved {
+			os.Rename(RepoPath(keep.Name, repo.Name), RepoPath(merge.Name, repo.Name))
+			if com.IsExist(WikiPath(keep.Name, repo.Name)) {
+				os.Rename(WikiPath(keep.Name, repo.Name), WikiPath(merge.Name, repo.Name))
+			}
+		}
+	}()
+	for _, repo := range repos {
+		if err = os.Rename(RepoPath(merge.Name, repo.Name), RepoPath(keep.Name, repo.Name)); err != nil {
+			return fmt.Errorf("rename repository directory: %v", err)
+		}
+		moved = append(moved, repo)
+		RemoveAllWithNotice("Delete repository local copy", repo.LocalCopyPath())
+
+		wikiPath := WikiPath(merge.Name, repo.Name)
+		if com.IsExist(wikiPath) {
+			RemoveAllWithNotice("Delete repository wiki local copy", repo.LocalWikiPath())
+			if err = os.Rename(wikiPath, WikiPath(keep.Name, repo.Name)); err != nil {
+				return fmt.Errorf("rename repository wiki: %v", err)
+			}
+		}
+	}
+
+	if err = deleteUser(sess, merge); err != nil {
+		return fmt.Errorf("deleteUser: %v", err)
+	}
+
+	if err = sess.Commit(); err != nil {
+		return err
+	}
+	merge.removeFiles()
+	return nil
+}
+
+// UserPath returns the path absolute path of user repositories.
+func UserPath(userName string) string {
+	return filepath.Join(setting.RepoRootPath, strings.ToLower(userName))
+}
+
+// usersWithMissingDir returns users whose directory does not exist.
+func usersWithMissingDir(users []*User) []*User {
+	missing := make([]*User, 0, 5)
+	for _, u := range users {
+		if !com.IsDir(UserPath(u.Name)) {
+			missing = append(missing, u)
+		}
 	}
-
-	return RewriteAllPublicKeys()
+	return missing
 }
 
-// DeleteInactivateUsers deletes all inactivate users and email addresses.
-func DeleteInactivateUsers() (err error) {
+// FindUsersWithMissingDir returns users who own repositories in database
+// but whose directory does not exist under repository root path.
+func FindUsersWithMissingDir() ([]*User, error) {
 	users := make([]*User, 0, 10)
-	if err = x.Where("is_active = ?", false).Find(&users); err != nil {
-		return fmt.Errorf("get all inactive users: %v", err)
+	if err := x.Where("id IN (SELECT owner_id FROM repository)").Asc("id").Find(&users); err != nil {
+		return nil, fmt.Errorf("find repository owners: %v", err)
 	}
-	// FIXME: should only update authorized_keys file once after all deletions.
-	for _, u := range users {
-		if err = DeleteUser(u); err != nil {
-			// Ignore users that were set inactive by admin.
-			if IsErrUserOwnRepos(err) || IsErrUserHasOrgs(err) {
-				continue
+	return usersWithMissingDir(users), nil
+}
+
+// dirSize returns total size of all files under given path,
+// nonexistent path has size of zero.
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
 			}
 			return err
 		}
+		if !fi.IsDir() {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// ComputeUserStorageUsage returns disk usage in bytes of all repositories
+// and custom avatar of given user.
+func ComputeUserStorageUsage(uid int64) (int64, error) {
+	u, err := GetUserByID(uid)
+	if err != nil {
+		return 0, err
 	}
 
-	_, err = x.Where("is_activated = ?", false).Delete(new(EmailAddress))
+	size, err := dirSize(UserPath(u.Name))
+	if err != nil {
+		return 0, fmt.Errorf("dirSize: %v", err)
+	}
+	if u.UseCustomAvatar {
+		if fi, err := os.Stat(u.CustomAvatarPath()); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size, nil
+}
+
+// UpdateUserStorageUsage computes and caches disk usage of given user.
+func UpdateUserStorageUsage(uid int64) error {
+	size, err := ComputeUserStorageUsage(uid)
+	if err != nil {
+		return fmt.Errorf("ComputeUserStorageUsage: %v", err)
+	}
+	_, err = x.Id(uid).Cols("used_storage").Update(&User{UsedStorage: size})
 	return err
 }
 
-// UserPath returns the path absolute path of user repositories.
-func UserPath(userName string) string {
-	return filepath.Join(setting.RepoRootPath, strings.ToLower(userName))
+// StorageQuota returns effective storage quota in bytes of user, 0 means no limit.
+func (u *User) StorageQuota() int64 {
+	if u.MaxStorage > 0 {
+		return u.MaxStorage
+	}
+	return setting.Repository.MaxStorage
+}
+
+// HasStorageQuotaRemaining returns true if user can use additional bytes of storage
+// without exceeding quota, it is based on cached storage usage.
+func (u *User) HasStorageQuotaRemaining(additional int64) bool {
+	quota := u.StorageQuota()
+	return quota <= 0 || u.UsedStorage+additional <= quota
+}
+
+// HasStorageQuotaRemaining returns true if user with given ID can use additional
+// bytes of storage without exceeding quota.
+func HasStorageQuotaRemaining(uid int64, additional int64) (bool, error) {
+	u, err := GetUserByID(uid)
+	if err != nil {
+		return false, err
+	}
+	return u.HasStorageQuotaRemaining(additional), nil
 }
 
 func GetUserByKeyID(keyID int64) (*User, error) {
@@ -856,6 +3365,22 @@ func GetUserByKeyID(keyID int64) (*User, error) {
 }
 
 func getUserByID(e Engine, id int64) (*User, error) {
+	u, err := getUserByIDIncludingDeleted(e, id)
+	if err != nil {
+		return nil, err
+	} else if u.IsDeleted {
+		return nil, ErrUserNotExist{id, ""}
+	}
+	return u, nil
+}
+
+// GetUserByID returns the user object by given ID if exists,
+// soft-deleted users are treated as not exist.
+func GetUserByID(id int64) (*User, error) {
+	return getUserByID(x, id)
+}
+
+func getUserByIDIncludingDeleted(e Engine, id int64) (*User, error) {
 	u := new(User)
 	has, err := e.Id(id).Get(u)
 	if err != nil {
@@ -866,9 +3391,10 @@ func getUserByID(e Engine, id int64) (*User, error) {
 	return u, nil
 }
 
-// GetUserByID returns the user object by given ID if exists.
-func GetUserByID(id int64) (*User, error) {
-	return getUserByID(x, id)
+// GetUserByIDIncludingDeleted returns the user object by given ID if exists,
+// including soft-deleted users. It is meant for attribution of existing content.
+func GetUserByIDIncludingDeleted(id int64) (*User, error) {
+	return getUserByIDIncludingDeleted(x, id)
 }
 
 // GetAssigneeByID returns the user with write access of repository by given ID.
@@ -888,7 +3414,7 @@ func GetUserByName(name string) (*User, error) {
 		return nil, ErrUserNotExist{0, name}
 	}
 	u := &User{LowerName: strings.ToLower(name)}
-	has, err := x.Get(u)
+	has, err := x.Where("is_deleted=?", false).Get(u)
 	if err != nil {
 		return nil, err
 	} else if !has {
@@ -897,32 +3423,82 @@ func GetUserByName(name string) (*User, error) {
 	return u, nil
 }
 
-// GetUserEmailsByNames returns a list of e-mails corresponds to names.
-func GetUserEmailsByNames(names []string) []string {
-	mails := make([]string, 0, len(names))
+// orderUsersByNames returns users in order of given names, names that do not match
+// any user are skipped and duplicated names result in duplicated users.
+func orderUsersByNames(names []string, users []*User) []*User {
+	userByName := make(map[string]*User, len(users))
+	for i := range users {
+		userByName[users[i].LowerName] = users[i]
+	}
+
+	ordered := make([]*User, 0, len(names))
 	for _, name := range names {
-		u, err := GetUserByName(name)
-		if err != nil {
-			continue
+		if u, ok := userByName[strings.ToLower(name)]; ok {
+			ordered = append(ordered, u)
+		}
+	}
+	return ordered
+}
+
+// getUsersByNames returns users in order of given names with a single query.
+func getUsersByNames(names []string) []*User {
+	lowerNames := make([]string, 0, len(names))
+	for _, name := range names {
+		if len(name) > 0 {
+			lowerNames = append(lowerNames, strings.ToLower(name))
 		}
-		mails = append(mails, u.Email)
+	}
+	if len(lowerNames) == 0 {
+		return nil
+	}
+
+	users := make([]*User, 0, len(lowerNames))
+	if err := x.Where("is_deleted=?", false).In("lower_name", lowerNames).Find(&users); err != nil {
+		log.Error(4, "getUsersByNames: %v", err)
+		return nil
+	}
+	return orderUsersByNames(names, users)
+}
+
+// GetUserEmailsByNames returns a list of e-mails corresponds to names.
+func GetUserEmailsByNames(names []string) []string {
+	users := getUsersByNames(names)
+	mails := make([]string, 0, len(users))
+	for i := range users {
+		mails = append(mails, users[i].Email)
 	}
 	return mails
 }
 
 // GetUserIDsByNames returns a slice of ids corresponds to names.
 func GetUserIDsByNames(names []string) []int64 {
-	ids := make([]int64, 0, len(names))
-	for _, name := range names {
-		u, err := GetUserByName(name)
-		if err != nil {
-			continue
-		}
-		ids = append(ids, u.ID)
+	users := getUsersByNames(names)
+	ids := make([]int64, 0, len(users))
+	for i := range users {
+		ids = append(ids, users[i].ID)
 	}
 	return ids
 }
 
+// UserBrief is a minimal projection of user for rendering long lists.
+type UserBrief struct {
+	ID       int64
+	Name     string
+	FullName string
+	Avatar   string
+}
+
+// GetUserBriefsByIDs returns brief information of users with given IDs,
+// only columns needed by UserBrief are loaded.
+func GetUserBriefsByIDs(ids []int64) ([]*UserBrief, error) {
+	briefs := make([]*UserBrief, 0, len(ids))
+	if len(ids) == 0 {
+		return briefs, nil
+	}
+	return briefs, x.Table("user").Cols("id", "name", "full_name", "avatar").
+		In("id", base.Int64sToStrings(ids)).Asc("id").Find(&briefs)
+}
+
 // UserCommit represents a commit with validation of user.
 type UserCommit struct {
 	User *User
@@ -938,6 +3514,75 @@ func ValidateCommitWithEmail(c *git.Commit) *User {
 	return u
 }
 
+// IsCommitAuthorVerified checks if author's e-mail of commit is a verified e-mail
+// of a user, which is either activated primary e-mail or activated alternative e-mail.
+// The matched user is returned even if the e-mail is not verified.
+func IsCommitAuthorVerified(c *git.Commit) (bool, *User) {
+	email := strings.ToLower(c.Author.Email)
+	if len(email) == 0 {
+		return false, nil
+	}
+
+	u := &User{Email: email}
+	has, err := x.Get(u)
+	if err != nil {
+		log.Error(4, "get user by primary email '%s': %v", email, err)
+		return false, nil
+	} else if has {
+		return u.IsActive, u
+	}
+
+	emailAddress := &EmailAddress{Email: email}
+	has, err = x.Get(emailAddress)
+	if err != nil {
+		log.Error(4, "get email address '%s': %v", email, err)
+		return false, nil
+	} else if !has {
+		return false, nil
+	}
+
+	u, err = GetUserByID(emailAddress.UID)
+	if err != nil {
+		log.Error(4, "GetUserByID [%d]: %v", emailAddress.UID, err)
+		return false, nil
+	}
+	return emailAddress.IsActivated, u
+}
+
+var coAuthorPattern = regexp.MustCompile(`(?im)^\s*co-authored-by:.*<([^>\s]+)>\s*$`)
+
+// parseCoAuthorEmails returns e-mails of co-authors in "Co-authored-by" trailers of commit message.
+func parseCoAuthorEmails(message string) []string {
+	matches := coAuthorPattern.FindAllStringSubmatch(message, -1)
+	emails := make([]string, 0, len(matches))
+	for i := range matches {
+		emails = append(emails, matches[i][1])
+	}
+	return emails
+}
+
+// ResolveCoAuthors returns users of co-authors in "Co-authored-by" trailers of commit message,
+// co-authors cannot be resolved to any user are skipped.
+func ResolveCoAuthors(c *git.Commit) ([]*User, error) {
+	emails := parseCoAuthorEmails(c.Message())
+	users := make([]*User, 0, len(emails))
+	found := make(map[int64]bool, len(emails))
+	for _, email := range emails {
+		u, err := GetUserByEmail(email)
+		if err != nil {
+			if IsErrUserNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("GetUserByEmail [%s]: %v", email, err)
+		}
+		if !found[u.ID] {
+			found[u.ID] = true
+			users = append(users, u)
+		}
+	}
+	return users, nil
+}
+
 // ValidateCommitsWithEmails checks if authors' e-mails of commits are corresponding to users.
 func ValidateCommitsWithEmails(oldCommits *list.List) *list.List {
 	var (
@@ -965,6 +3610,163 @@ func ValidateCommitsWithEmails(oldCommits *list.List) *list.List {
 	return newCommits
 }
 
+// VerifiedUserCommit represents a commit with its author and whether
+// author's e-mail is a verified e-mail of the user.
+type VerifiedUserCommit struct {
+	User     *User
+	Verified bool
+	*git.Commit
+}
+
+// emailVerification represents the owner of an e-mail and whether it is verified.
+type emailVerification struct {
+	User     *User
+	Verified bool
+}
+
+// getEmailVerifications returns owners and verification status of given e-mails,
+// keys are lower-cased and unknown e-mails are omitted.
+func getEmailVerifications(emails []string) (map[string]emailVerification, error) {
+	results := make(map[string]emailVerification, len(emails))
+	if len(emails) == 0 {
+		return results, nil
+	}
+
+	users := make([]*User, 0, len(emails))
+	if err := x.In("email", emails).Find(&users); err != nil {
+		return nil, fmt.Errorf("find users: %v", err)
+	}
+	for _, u := range users {
+		results[strings.ToLower(u.Email)] = emailVerification{u, u.IsActive}
+	}
+
+	addresses := make([]*EmailAddress, 0, len(emails))
+	if err := x.In("email", emails).Find(&addresses); err != nil {
+		return nil, fmt.Errorf("find email addresses: %v", err)
+	}
+	uids := make([]int64, 0, len(addresses))
+	for _, address := range addresses {
+		if _, ok := results[strings.ToLower(address.Email)]; !ok {
+			uids = append(uids, address.UID)
+		}
+	}
+	if len(uids) == 0 {
+		return results, nil
+	}
+
+	owners := make([]*User, 0, len(uids))
+	if err := x.In("id", base.Int64sToStrings(uids)).Find(&owners); err != nil {
+		return nil, fmt.Errorf("find owners: %v", err)
+	}
+	ownerByID := make(map[int64]*User, len(owners))
+	for _, u := range owners {
+		ownerByID[u.ID] = u
+	}
+	for _, address := range addresses {
+		email := strings.ToLower(address.Email)
+		if _, ok := results[email]; ok {
+			continue
+		}
+		if u, ok := ownerByID[address.UID]; ok {
+			results[email] = emailVerification{u, address.IsActivated}
+		}
+	}
+	return results, nil
+}
+
+// attributeCommits converts commits into VerifiedUserCommit with resolved e-mails.
+func attributeCommits(oldCommits *list.List, resolved map[string]emailVerification) *list.List {
+	newCommits := list.New()
+	for e := oldCommits.Front(); e != nil; e = e.Next() {
+		c := e.Value.(*git.Commit)
+		v := resolved[strings.ToLower(c.Author.Email)]
+		newCommits.PushBack(VerifiedUserCommit{
+			User:     v.User,
+			Verified: v.Verified,
+			Commit:   c,
+		})
+	}
+	return newCommits
+}
+
+// ValidateCommitsWithVerification checks if authors' e-mails of commits are corresponding to users
+// and whether they are verified, all e-mails are resolved with a fixed number of queries.
+func ValidateCommitsWithVerification(oldCommits *list.List) *list.List {
+	emails := make([]string, 0, oldCommits.Len())
+	seen := make(map[string]bool, oldCommits.Len())
+	for e := oldCommits.Front(); e != nil; e = e.Next() {
+		email := strings.ToLower(e.Value.(*git.Commit).Author.Email)
+		if len(email) > 0 && !seen[email] {
+			seen[email] = true
+			emails = append(emails, email)
+		}
+	}
+
+	resolved, err := getEmailVerifications(emails)
+	if err != nil {
+		log.Error(4, "getEmailVerifications: %v", err)
+		resolved = map[string]emailVerification{}
+	}
+	return attributeCommits(oldCommits, resolved)
+}
+
+// UserCommitCount represents number of commits authored by a user.
+type UserCommitCount struct {
+	User  *User
+	Count int
+}
+
+type userCommitCounts []*UserCommitCount
+
+func (c userCommitCounts) Len() int           { return len(c) }
+func (c userCommitCounts) Less(i, j int) bool { return c[i].Count > c[j].Count }
+func (c userCommitCounts) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+
+// TopCommitAuthors returns at most n users who authored most commits in given list,
+// authors cannot be resolved to any user are counted together as a fake user.
+func TopCommitAuthors(commits *list.List, n int) ([]*UserCommitCount, error) {
+	emails := make([]string, 0, commits.Len())
+	for e := commits.Front(); e != nil; e = e.Next() {
+		emails = append(emails, e.Value.(*git.Commit).Author.Email)
+	}
+	return topCommitAuthors(emails, n, GetUserByEmail)
+}
+
+func topCommitAuthors(emails []string, n int, getUserByEmail func(string) (*User, error)) ([]*UserCommitCount, error) {
+	users := make(map[string]*User)
+	counts := make(map[int64]*UserCommitCount)
+	results := make(userCommitCounts, 0, 10)
+	for _, email := range emails {
+		email = strings.ToLower(email)
+		u, ok := users[email]
+		if !ok {
+			var err error
+			u, err = getUserByEmail(email)
+			if err != nil {
+				if !IsErrUserNotExist(err) {
+					return nil, fmt.Errorf("GetUserByEmail [%s]: %v", email, err)
+				}
+				u = NewFakeUser()
+			}
+			users[email] = u
+		}
+
+		count, ok := counts[u.ID]
+		if !ok {
+			count = &UserCommitCount{User: u}
+			counts[u.ID] = count
+			results = append(results, count)
+		}
+		count.Count++
+	}
+
+	sort.Stable(results)
+	if n >= 0 && len(results) > n {
+		results = results[:n]
+	}
+	return results, nil
+}
+
 // GetUserByEmail returns the user object by given e-mail if exists.
 func GetUserByEmail(email string) (*User, error) {
 	if len(email) == 0 {
@@ -972,6 +3774,11 @@ func GetUserByEmail(email string) (*User, error) {
 	}
 
 	email = strings.ToLower(email)
+	// No-reply addresses are never stored, resolve them by user name
+	if name, ok := noReplyUserName(email); ok {
+		return GetUserByName(name)
+	}
+
 	// First try to find the user by primary email
 	user := &User{Email: email}
 	has, err := x.Get(user)
@@ -1001,6 +3808,8 @@ type SearchUserOptions struct {
 	OrderBy  string
 	Page     int
 	PageSize int // Can be smaller than or equal to setting.UI.ExplorePagingNum
+
+	ExcludeIDs []int64 // Users to be left out of results, e.g. already selected ones
 }
 
 // SearchUserByName takes keyword and part of user name to search,
@@ -1021,9 +3830,11 @@ func SearchUserByName(opts *SearchUserOptions) (users []*User, _ int64, _ error)
 	searchQuery := "%" + opts.Keyword + "%"
 	users = make([]*User, 0, opts.PageSize)
 	// Append conditions
-	sess := x.Where("LOWER(lower_name) LIKE ?", searchQuery).
-		Or("LOWER(full_name) LIKE ?", searchQuery).
-		And("type = ?", opts.Type)
+	sess := x.Where("(LOWER(lower_name) LIKE ? OR LOWER(full_name) LIKE ?)", searchQuery, searchQuery).
+		And("type = ?", opts.Type).And("is_deleted = ?", false)
+	if len(opts.ExcludeIDs) > 0 {
+		sess.And("id NOT IN (" + strings.Join(base.Int64sToStrings(opts.ExcludeIDs), ",") + ")")
+	}
 
 	var countSess xorm.Session
 	countSess = *sess
@@ -1038,6 +3849,79 @@ func SearchUserByName(opts *SearchUserOptions) (users []*User, _ int64, _ error)
 	return users, count, sess.Limit(opts.PageSize, (opts.Page-1)*opts.PageSize).Find(&users)
 }
 
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// escapeLike escapes wildcard characters in given string to be used
+// with "LIKE ? ESCAPE '!'" so they are matched literally.
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// SearchUsersByEmailFragment returns users whose primary e-mail or activated
+// alternative e-mail contains given fragment, matched e-mail is set to
+// MatchedEmail of each user. It is meant for admin callers only.
+func SearchUsersByEmailFragment(fragment string, limit int) ([]*User, error) {
+	fragment = strings.ToLower(strings.TrimSpace(fragment))
+	if len(fragment) == 0 || limit <= 0 {
+		return []*User{}, nil
+	}
+	pattern := "%" + escapeLike(fragment) + "%"
+
+	users := make([]*User, 0, limit)
+	if err := x.Where("LOWER(email) LIKE ? ESCAPE '!'", pattern).
+		And("type=?", USER_TYPE_INDIVIDUAL).And("is_deleted=?", false).
+		Limit(limit).Asc("id").Find(&users); err != nil {
+		return nil, fmt.Errorf("find users by primary email: %v", err)
+	}
+	found := make(map[int64]bool, len(users))
+	for _, u := range users {
+		u.MatchedEmail = u.Email
+		found[u.ID] = true
+	}
+	if len(users) >= limit {
+		return users, nil
+	}
+
+	emails := make([]*EmailAddress, 0, limit)
+	if err := x.Where("LOWER(email) LIKE ? ESCAPE '!'", pattern).
+		And("is_activated=?", true).Asc("id").Find(&emails); err != nil {
+		return nil, fmt.Errorf("find email addresses: %v", err)
+	}
+	for _, email := range emails {
+		if found[email.UID] {
+			continue
+		}
+		u, err := GetUserByID(email.UID)
+		if err != nil {
+			if IsErrUserNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("GetUserByID [%d]: %v", email.UID, err)
+		}
+		u.MatchedEmail = email.Email
+		found[u.ID] = true
+		users = append(users, u)
+		if len(users) >= limit {
+			break
+		}
+	}
+	return users, nil
+}
+
+// AutocompleteUsers returns active individuals and organizations whose name
+// starts with given prefix, it is cheaper than SearchUserByName for autocomplete.
+func AutocompleteUsers(prefix string, limit int) ([]*User, error) {
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	if len(prefix) == 0 || limit <= 0 {
+		return []*User{}, nil
+	}
+
+	users := make([]*User, 0, limit)
+	return users, x.Where("lower_name LIKE ? ESCAPE '!'", escapeLike(prefix)+"%").
+		And("(type=? OR is_active=?)", USER_TYPE_ORGANIZATION, true).And("is_deleted=?", false).
+		Limit(limit).Asc("lower_name").Find(&users)
+}
+
 // ___________    .__  .__
 // \_   _____/___ |  | |  |   ______  _  __
 //  |    __)/  _ \|  | |  |  /  _ \ \/ \/ /
@@ -1050,6 +3934,20 @@ type Follow struct {
 	ID       int64 `xorm:"pk autoincr"`
 	UserID   int64 `xorm:"UNIQUE(follow)"`
 	FollowID int64 `xorm:"UNIQUE(follow)"`
+
+	Created     time.Time `xorm:"-"`
+	CreatedUnix int64     `xorm:"INDEX"`
+}
+
+func (f *Follow) BeforeInsert() {
+	f.CreatedUnix = time.Now().Unix()
+}
+
+func (f *Follow) AfterSet(colName string, _ xorm.Cell) {
+	switch colName {
+	case "created_unix":
+		f.Created = time.Unix(f.CreatedUnix, 0).Local()
+	}
 }
 
 func IsFollowing(userID, followID int64) bool {
@@ -1083,6 +3981,97 @@ func FollowUser(userID, followID int64) (err error) {
 	return sess.Commit()
 }
 
+func purgeUserFollows(e Engine, uid int64) (err error) {
+	if _, err = e.Exec("UPDATE `user` SET num_followers=num_followers-1 WHERE id IN (SELECT follow_id FROM `follow` WHERE user_id=?)", uid); err != nil {
+		return fmt.Errorf("decrease followers of followed users: %v", err)
+	} else if _, err = e.Exec("UPDATE `user` SET num_following=num_following-1 WHERE id IN (SELECT user_id FROM `follow` WHERE follow_id=?)", uid); err != nil {
+		return fmt.Errorf("decrease following of followers: %v", err)
+	} else if _, err = e.Exec("DELETE FROM `follow` WHERE user_id=? OR follow_id=?", uid, uid); err != nil {
+		return fmt.Errorf("delete follows: %v", err)
+	}
+
+	_, err = e.Exec("UPDATE `user` SET num_followers=0, num_following=0 WHERE id=?", uid)
+	return err
+}
+
+// CountTotalFollows returns number of all follow relations.
+func CountTotalFollows() (int64, error) {
+	return x.Count(new(Follow))
+}
+
+// followCounts maps results of a grouped count query by column keyCol
+// to given IDs, IDs without any row have count of zero.
+func followCounts(ids []int64, results []map[string][]byte, keyCol string) map[int64]int64 {
+	counts := make(map[int64]int64, len(ids))
+	for _, id := range ids {
+		counts[id] = 0
+	}
+	for _, result := range results {
+		id := com.StrTo(result[keyCol]).MustInt64()
+		if _, ok := counts[id]; ok {
+			counts[id] = com.StrTo(result["num"]).MustInt64()
+		}
+	}
+	return counts
+}
+
+// RecountFollowsForUsers recomputes follower and following counters of given users
+// from the follow table, e.g. after follow rows are inserted in bulk.
+func RecountFollowsForUsers(ids []int64) (err error) {
+	if len(ids) == 0 {
+		return nil
+	}
+	idList := strings.Join(base.Int64sToStrings(ids), ",")
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	results, err := sess.Query("SELECT follow_id, COUNT(*) AS num FROM `follow` WHERE follow_id IN (" + idList + ") GROUP BY follow_id")
+	if err != nil {
+		return fmt.Errorf("count followers: %v", err)
+	}
+	followers := followCounts(ids, results, "follow_id")
+
+	results, err = sess.Query("SELECT user_id, COUNT(*) AS num FROM `follow` WHERE user_id IN (" + idList + ") GROUP BY user_id")
+	if err != nil {
+		return fmt.Errorf("count following: %v", err)
+	}
+	following := followCounts(ids, results, "user_id")
+
+	for _, id := range ids {
+		if _, err = sess.Exec("UPDATE `user` SET num_followers=?, num_following=? WHERE id=?",
+			followers[id], following[id], id); err != nil {
+			return fmt.Errorf("update counters of user %d: %v", id, err)
+		}
+	}
+	return sess.Commit()
+}
+
+// CountNewFollowers returns number of users who started following given user after given time.
+// Follows created before the creation time is recorded are never counted.
+func CountNewFollowers(uid int64, since time.Time) (int64, error) {
+	return x.Where("follow_id=?", uid).And("created_unix>?", since.Unix()).Count(new(Follow))
+}
+
+// PurgeUserFollows removes all follow relations from and to given user,
+// and corrects counters of the other users.
+func PurgeUserFollows(uid int64) (err error) {
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	if err = purgeUserFollows(sess, uid); err != nil {
+		return err
+	}
+
+	return sess.Commit()
+}
+
 // UnfollowUser unmarks someone be another's follower.
 func UnfollowUser(userID, followID int64) (err error) {
 	if userID == followID || !IsFollowing(userID, followID) {
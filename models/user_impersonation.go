@@ -0,0 +1,119 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+	gouuid "github.com/satori/go.uuid"
+
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/setting"
+)
+
+const _IMPERSONATION_TOKEN_LIFETIME = 30 * time.Minute
+
+// ImpersonationToken represents a time-limited permission of an admin
+// to view the site as another user, it is kept for auditing.
+type ImpersonationToken struct {
+	ID       int64  `xorm:"pk autoincr"`
+	AdminID  int64  `xorm:"INDEX"`
+	TargetID int64  `xorm:"INDEX"`
+	Sha1     string `xorm:"UNIQUE VARCHAR(40)"`
+	Reason   string
+
+	Created     time.Time `xorm:"-"`
+	CreatedUnix int64
+	Expires     time.Time `xorm:"-"`
+	ExpiresUnix int64
+}
+
+func (t *ImpersonationToken) BeforeInsert() {
+	t.CreatedUnix = time.Now().Unix()
+	t.ExpiresUnix = t.CreatedUnix + int64(_IMPERSONATION_TOKEN_LIFETIME/time.Second)
+}
+
+func (t *ImpersonationToken) AfterSet(colName string, _ xorm.Cell) {
+	switch colName {
+	case "created_unix":
+		t.Created = time.Unix(t.CreatedUnix, 0).Local()
+	case "expires_unix":
+		t.Expires = time.Unix(t.ExpiresUnix, 0).Local()
+	}
+}
+
+// IsExpired returns true if token can no longer be used.
+func (t *ImpersonationToken) IsExpired() bool {
+	return time.Now().Unix() >= t.ExpiresUnix
+}
+
+// canImpersonate returns true if admin is allowed to impersonate target user,
+// other admins and users prohibited to login cannot be impersonated.
+func canImpersonate(admin, target *User) bool {
+	return admin.IsAdmin && admin.ID != target.ID &&
+		!target.IsOrganization() && !target.IsAdmin && !target.ProhibitLogin
+}
+
+// GetImpersonatableUsers returns users in given range that can be impersonated by admins,
+// keyword is optional and matches part of user name.
+func GetImpersonatableUsers(opts *SearchUserOptions) ([]*User, error) {
+	if opts.PageSize <= 0 || opts.PageSize > setting.UI.ExplorePagingNum {
+		opts.PageSize = setting.UI.ExplorePagingNum
+	}
+	if opts.Page <= 0 {
+		opts.Page = 1
+	}
+
+	users := make([]*User, 0, opts.PageSize)
+	sess := x.Where("type=?", USER_TYPE_INDIVIDUAL).
+		And("is_deleted=?", false).
+		And("is_admin=?", false).
+		And("prohibit_login=?", false)
+	if len(opts.Keyword) > 0 {
+		sess.And("lower_name LIKE ? ESCAPE '!'", "%"+escapeLike(strings.ToLower(opts.Keyword))+"%")
+	}
+	if len(opts.OrderBy) > 0 {
+		sess.OrderBy(opts.OrderBy)
+	} else {
+		sess.Asc("id")
+	}
+	return users, sess.Limit(opts.PageSize, (opts.Page-1)*opts.PageSize).Find(&users)
+}
+
+// NewImpersonationToken issues a new token for admin to impersonate target user.
+func NewImpersonationToken(admin, target *User, reason string) (*ImpersonationToken, error) {
+	if !canImpersonate(admin, target) {
+		return nil, ErrImpersonationNotAllowed{admin.ID, target.ID}
+	}
+
+	t := &ImpersonationToken{
+		AdminID:  admin.ID,
+		TargetID: target.ID,
+		Sha1:     base.EncodeSha1(gouuid.NewV4().String()),
+		Reason:   reason,
+	}
+	if _, err := x.Insert(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// GetImpersonationToken returns valid impersonation token by given sha1,
+// expired token is treated as nonexistent.
+func GetImpersonationToken(sha string) (*ImpersonationToken, error) {
+	if len(sha) == 0 {
+		return nil, ErrImpersonationTokenNotExist{sha}
+	}
+	t := &ImpersonationToken{Sha1: sha}
+	has, err := x.Get(t)
+	if err != nil {
+		return nil, err
+	} else if !has || t.IsExpired() {
+		return nil, ErrImpersonationTokenNotExist{sha}
+	}
+	return t, nil
+}
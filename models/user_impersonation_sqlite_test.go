@@ -0,0 +1,44 @@
+// +build sqlite
+
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func Test_GetImpersonatableUsers(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Admins, organizations and prohibited users are not listed", t, func() {
+		setting.UI.ExplorePagingNum = 20
+		_, err := x.Insert(
+			&User{Name: "alice", LowerName: "alice", Email: "alice@example.com"},
+			&User{Name: "admin", LowerName: "admin", Email: "admin@example.com", IsAdmin: true},
+			&User{Name: "prohibited", LowerName: "prohibited", Email: "prohibited@example.com", ProhibitLogin: true},
+			&User{Name: "org", LowerName: "org", Type: USER_TYPE_ORGANIZATION},
+			&User{Name: "malice", LowerName: "malice", Email: "malice@example.com"},
+		)
+		So(err, ShouldBeNil)
+
+		users, err := GetImpersonatableUsers(&SearchUserOptions{})
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 2)
+		So(users[0].Name, ShouldEqual, "alice")
+		So(users[1].Name, ShouldEqual, "malice")
+
+		users, err = GetImpersonatableUsers(&SearchUserOptions{Keyword: "AL"})
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 2)
+		users, err = GetImpersonatableUsers(&SearchUserOptions{Keyword: "adm"})
+		So(err, ShouldBeNil)
+		So(users, ShouldBeEmpty)
+	})
+}
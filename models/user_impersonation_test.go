@@ -0,0 +1,35 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ImpersonationToken(t *testing.T) {
+	Convey("Admins and prohibited users cannot be impersonated", t, func() {
+		admin := &User{ID: 1, IsAdmin: true}
+		So(canImpersonate(admin, &User{ID: 2}), ShouldBeTrue)
+		So(canImpersonate(admin, admin), ShouldBeFalse)
+		So(canImpersonate(admin, &User{ID: 2, IsAdmin: true}), ShouldBeFalse)
+		So(canImpersonate(admin, &User{ID: 2, ProhibitLogin: true}), ShouldBeFalse)
+		So(canImpersonate(&User{ID: 3}, &User{ID: 2}), ShouldBeFalse)
+
+		_, err := NewImpersonationToken(admin, &User{ID: 2, IsAdmin: true}, "")
+		So(IsErrImpersonationNotAllowed(err), ShouldBeTrue)
+	})
+
+	Convey("Token expires after its lifetime", t, func() {
+		token := new(ImpersonationToken)
+		token.BeforeInsert()
+		So(token.IsExpired(), ShouldBeFalse)
+
+		token.ExpiresUnix = time.Now().Add(-time.Minute).Unix()
+		So(token.IsExpired(), ShouldBeTrue)
+	})
+}
@@ -7,6 +7,13 @@ package models
 import (
 	"fmt"
 	"strings"
+	"time"
+
+	"github.com/Unknwon/com"
+	"github.com/go-xorm/xorm"
+
+	"github.com/gogits/gogs/modules/base"
+	"github.com/gogits/gogs/modules/setting"
 )
 
 // EmailAdresses is the list of all email addresses of a user. Can contain the
@@ -16,13 +23,28 @@ type EmailAddress struct {
 	UID         int64  `xorm:"INDEX NOT NULL"`
 	Email       string `xorm:"UNIQUE NOT NULL"`
 	IsActivated bool
+	SortOrder   int  `xorm:"NOT NULL DEFAULT 0"` // Display order chosen by user
 	IsPrimary   bool `xorm:"-"`
+
+	Created     time.Time `xorm:"-"`
+	CreatedUnix int64
+}
+
+func (email *EmailAddress) BeforeInsert() {
+	email.CreatedUnix = time.Now().Unix()
+}
+
+func (email *EmailAddress) AfterSet(colName string, _ xorm.Cell) {
+	switch colName {
+	case "created_unix":
+		email.Created = time.Unix(email.CreatedUnix, 0).Local()
+	}
 }
 
 // GetEmailAddresses returns all email addresses belongs to given user.
 func GetEmailAddresses(uid int64) ([]*EmailAddress, error) {
 	emails := make([]*EmailAddress, 0, 5)
-	if err := x.Where("uid=?", uid).Find(&emails); err != nil {
+	if err := x.Where("uid=?", uid).Asc("sort_order").Asc("id").Find(&emails); err != nil {
 		return nil, err
 	}
 
@@ -31,26 +53,73 @@ func GetEmailAddresses(uid int64) ([]*EmailAddress, error) {
 		return nil, err
 	}
 
+	return primaryEmailFirst(dedupEmailAddresses(emails, u.Email)), nil
+}
+
+// primaryEmailFirst moves primary email address to the front of list
+// and keeps order of the others.
+func primaryEmailFirst(emails []*EmailAddress) []*EmailAddress {
+	for i := range emails {
+		if emails[i].IsPrimary {
+			primary := emails[i]
+			copy(emails[1:i+1], emails[:i])
+			emails[0] = primary
+			break
+		}
+	}
+	return emails
+}
+
+// ReorderEmailAddresses sets display order of email addresses of user
+// by given list of IDs, IDs that do not belong to the user are ignored.
+func ReorderEmailAddresses(uid int64, orderedIDs []int64) (err error) {
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return err
+	}
+
+	for i, id := range orderedIDs {
+		if _, err = sess.Where("id=?", id).And("uid=?", uid).
+			Cols("sort_order").Update(&EmailAddress{SortOrder: i + 1}); err != nil {
+			return fmt.Errorf("update sort order [%d]: %v", id, err)
+		}
+	}
+
+	return sess.Commit()
+}
+
+// dedupEmailAddresses removes duplicated email addresses by their normalized form
+// and makes sure there is exactly one entry marked as primary.
+func dedupEmailAddresses(emails []*EmailAddress, primary string) []*EmailAddress {
+	normalizedPrimary := strings.ToLower(strings.TrimSpace(primary))
+	seen := make(map[string]bool, len(emails))
+	results := make([]*EmailAddress, 0, len(emails)+1)
 	isPrimaryFound := false
 	for _, email := range emails {
-		if email.Email == u.Email {
+		normalized := strings.ToLower(strings.TrimSpace(email.Email))
+		if seen[normalized] {
+			continue
+		}
+		seen[normalized] = true
+
+		email.IsPrimary = normalized == normalizedPrimary
+		if email.IsPrimary {
 			isPrimaryFound = true
-			email.IsPrimary = true
-		} else {
-			email.IsPrimary = false
 		}
+		results = append(results, email)
 	}
 
 	// We alway want the primary email address displayed, even if it's not in
 	// the emailaddress table (yet).
 	if !isPrimaryFound {
-		emails = append(emails, &EmailAddress{
-			Email:       u.Email,
+		results = append(results, &EmailAddress{
+			Email:       primary,
 			IsActivated: true,
 			IsPrimary:   true,
 		})
 	}
-	return emails, nil
+	return results
 }
 
 func isEmailUsed(e Engine, email string) (bool, error) {
@@ -66,6 +135,49 @@ func IsEmailUsed(email string) (bool, error) {
 	return isEmailUsed(x, email)
 }
 
+// EmailExists returns true if the email has been used as primary or alternative
+// e-mail address by an individual. It only counts rows and is cheaper than
+// IsEmailUsedByType, which it agrees with for individuals.
+func EmailExists(email string) (bool, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if len(email) == 0 {
+		return true, nil
+	}
+
+	count, err := x.Where("type=?", USER_TYPE_INDIVIDUAL).And("email=?", email).Count(new(User))
+	if err != nil {
+		return false, err
+	} else if count > 0 {
+		return true, nil
+	}
+
+	count, err = x.Where("email=?", email).Count(new(EmailAddress))
+	return count > 0, err
+}
+
+// Note: primary e-mail is meant to be unique among users of the same type,
+// so an organization is allowed to use same e-mail as an individual.
+// Alternative e-mails always belong to individuals.
+func isEmailUsedByType(e Engine, email string, t UserType) (bool, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	if len(email) == 0 {
+		return true, nil
+	}
+
+	has, err := e.Where("type=?", t).And("email=?", email).Get(new(User))
+	if err != nil {
+		return false, err
+	} else if has || t != USER_TYPE_INDIVIDUAL {
+		return has, nil
+	}
+	return isEmailUsed(e, email)
+}
+
+// IsEmailUsedByType returns true if the email has been used by a user of given type.
+func IsEmailUsedByType(email string, t UserType) (bool, error) {
+	return isEmailUsedByType(x, email, t)
+}
+
 func addEmailAddress(e Engine, email *EmailAddress) error {
 	email.Email = strings.ToLower(strings.TrimSpace(email.Email))
 	used, err := isEmailUsed(e, email.Email)
@@ -196,3 +308,281 @@ func MakeEmailPrimary(email *EmailAddress) error {
 
 	return sess.Commit()
 }
+
+// MakeEmailPrimaryByID makes email address with given ID primary email of given user,
+// the email address must belong to the user and has been activated.
+func MakeEmailPrimaryByID(uid, emailID int64) error {
+	email := new(EmailAddress)
+	has, err := x.Id(emailID).Get(email)
+	if err != nil {
+		return err
+	} else if !has || email.UID != uid {
+		return ErrEmailNotExist
+	}
+
+	return MakeEmailPrimary(email)
+}
+
+const (
+	// _PRIMARY_EMAIL_COND matches rows of email_address that are primary email of the owner.
+	_PRIMARY_EMAIL_COND = "EXISTS (SELECT 1 FROM `user` WHERE `user`.id=email_address.uid AND `user`.email=email_address.email)"
+	// _NOT_PRIMARY_EMAIL_COND matches rows of email_address that are not primary email of the owner.
+	_NOT_PRIMARY_EMAIL_COND = "NOT " + _PRIMARY_EMAIL_COND
+)
+
+// Note: rows created before the creation time is recorded are never considered
+// stale, and primary email of user is never returned even if it is unactivated.
+func unactivatedEmailAddressesCond(e Engine, olderThan time.Duration) *xorm.Session {
+	return e.Where("is_activated=?", false).
+		And("created_unix>0 AND created_unix<?", time.Now().Add(-olderThan).Unix()).
+		And(_NOT_PRIMARY_EMAIL_COND)
+}
+
+// GetUnactivatedEmailAddresses returns alternative email addresses that have not
+// been activated and were added longer than given duration ago.
+func GetUnactivatedEmailAddresses(olderThan time.Duration) ([]*EmailAddress, error) {
+	emails := make([]*EmailAddress, 0, 10)
+	return emails, unactivatedEmailAddressesCond(x, olderThan).Asc("id").Find(&emails)
+}
+
+// PurgeUnactivatedEmailAddresses deletes alternative email addresses that have not
+// been activated and were added longer than given duration ago,
+// it returns number of email addresses deleted.
+func PurgeUnactivatedEmailAddresses(olderThan time.Duration) (int, error) {
+	affected, err := unactivatedEmailAddressesCond(x, olderThan).Delete(new(EmailAddress))
+	return int(affected), err
+}
+
+// FindExistingEmails returns owner user ID of each given email that has been used
+// as primary or activated alternative email, keys are normalized to lower case
+// and unknown emails are omitted.
+func FindExistingEmails(emails []string) (map[string]int64, error) {
+	existing := make(map[string]int64, len(emails))
+	normalized := make([]string, 0, len(emails))
+	for i := range emails {
+		email := strings.ToLower(strings.TrimSpace(emails[i]))
+		if len(email) > 0 {
+			normalized = append(normalized, email)
+		}
+	}
+	if len(normalized) == 0 {
+		return existing, nil
+	}
+
+	users := make([]*User, 0, len(normalized))
+	if err := x.Cols("id", "email").In("email", normalized).Find(&users); err != nil {
+		return nil, fmt.Errorf("find users: %v", err)
+	}
+	for _, u := range users {
+		existing[strings.ToLower(u.Email)] = u.ID
+	}
+
+	addresses := make([]*EmailAddress, 0, len(normalized))
+	if err := x.Where("is_activated=?", true).In("email", normalized).Find(&addresses); err != nil {
+		return nil, fmt.Errorf("find email addresses: %v", err)
+	}
+	for _, address := range addresses {
+		email := strings.ToLower(address.Email)
+		if _, ok := existing[email]; !ok {
+			existing[email] = address.UID
+		}
+	}
+	return existing, nil
+}
+
+// EmailCollision represents a primary email used by multiple users of the same type
+// when compared case-insensitively.
+type EmailCollision struct {
+	Email   string // Lower-cased
+	Type    UserType
+	UserIDs []int64
+}
+
+// groupEmailCollisions returns collisions of primary emails of given users
+// in order of their first appearance.
+func groupEmailCollisions(users []*User) []EmailCollision {
+	type key struct {
+		email string
+		typ   UserType
+	}
+	indexes := make(map[key]int, len(users))
+	groups := make([]EmailCollision, 0, len(users))
+	for _, u := range users {
+		k := key{strings.ToLower(strings.TrimSpace(u.Email)), u.Type}
+		if len(k.email) == 0 {
+			continue
+		}
+		if i, ok := indexes[k]; ok {
+			groups[i].UserIDs = append(groups[i].UserIDs, u.ID)
+			continue
+		}
+		indexes[k] = len(groups)
+		groups = append(groups, EmailCollision{
+			Email:   k.email,
+			Type:    k.typ,
+			UserIDs: []int64{u.ID},
+		})
+	}
+
+	collisions := make([]EmailCollision, 0, 5)
+	for i := range groups {
+		if len(groups[i].UserIDs) > 1 {
+			collisions = append(collisions, groups[i])
+		}
+	}
+	return collisions
+}
+
+// FindCaseInsensitiveEmailCollisions returns primary emails that are used by
+// multiple users of the same type when compared case-insensitively.
+// Such collisions can only exist in data written before emails were lower-cased on write.
+// Only users of colliding emails are loaded.
+func FindCaseInsensitiveEmailCollisions() ([]EmailCollision, error) {
+	results, err := x.Query("SELECT LOWER(TRIM(email)) AS lower_email, type FROM `user` WHERE email != '' " +
+		"GROUP BY LOWER(TRIM(email)), type HAVING COUNT(*) > 1")
+	if err != nil {
+		return nil, fmt.Errorf("find colliding emails: %v", err)
+	} else if len(results) == 0 {
+		return []EmailCollision{}, nil
+	}
+
+	emails := make([]interface{}, len(results))
+	for i := range results {
+		emails[i] = string(results[i]["lower_email"])
+	}
+	users := make([]*User, 0, len(results)*2)
+	if err = x.Cols("id", "type", "email").
+		Where("LOWER(TRIM(email)) IN ("+strings.Repeat("?,", len(emails)-1)+"?)", emails...).
+		Asc("id").Find(&users); err != nil {
+		return nil, fmt.Errorf("find users: %v", err)
+	}
+	return groupEmailCollisions(users), nil
+}
+
+// normalizeEmailDomain returns lower-cased domain without leading "@".
+func normalizeEmailDomain(domain string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "@")
+}
+
+// MarkEmailsUnverifiedByDomain marks activated alternative email addresses on given domain
+// as unactivated so they have to be verified again, primary emails of users are never affected.
+// It returns number of email addresses changed.
+func MarkEmailsUnverifiedByDomain(domain string) (int, error) {
+	domain = normalizeEmailDomain(domain)
+	if len(domain) == 0 {
+		return 0, nil
+	}
+
+	affected, err := x.Where("is_activated=?", true).
+		And("email LIKE ? ESCAPE '!'", "%@"+escapeLike(domain)).
+		And(_NOT_PRIMARY_EMAIL_COND).
+		Cols("is_activated").UseBool("is_activated").Update(new(EmailAddress))
+	return int(affected), err
+}
+
+// activationCodeLifetime returns how long an activation code is valid.
+func activationCodeLifetime() time.Duration {
+	return time.Duration(setting.Service.ActiveCodeLives) * time.Minute
+}
+
+// PurgeExpiredTokens is the maintenance pass for stored activation artifacts,
+// it returns number of rows deleted. Pending email changes, i.e. alternative email
+// addresses whose activation codes have expired without being activated, are
+// the only such rows: password reset and account activation codes are derived
+// from user rands rather than stored, so they expire on their own.
+// It is therefore the same as PurgeUnactivatedEmailAddresses with lifetime
+// of activation codes.
+func PurgeExpiredTokens() (int, error) {
+	return PurgeUnactivatedEmailAddresses(activationCodeLifetime())
+}
+
+// EmailConflict represents a primary email of an active user that also exists
+// as an unactivated alternative email.
+type EmailConflict struct {
+	UID     int64
+	Email   string
+	EmailID int64 // ID of the alternative email row
+}
+
+// findEmailConflicts returns conflicts between given active users
+// and unactivated alternative emails.
+func findEmailConflicts(users []*User, addresses []*EmailAddress) []EmailConflict {
+	primaries := make(map[int64]string, len(users))
+	for _, u := range users {
+		if u.IsActive {
+			primaries[u.ID] = strings.ToLower(u.Email)
+		}
+	}
+
+	conflicts := make([]EmailConflict, 0, 5)
+	for _, address := range addresses {
+		if address.IsActivated {
+			continue
+		}
+		if primary, ok := primaries[address.UID]; ok && primary == strings.ToLower(address.Email) {
+			conflicts = append(conflicts, EmailConflict{
+				UID:     address.UID,
+				Email:   primary,
+				EmailID: address.ID,
+			})
+		}
+	}
+	return conflicts
+}
+
+// FindPrimaryAltEmailConflicts returns active users whose primary email also exists
+// as an unactivated alternative email, which can be left behind by migrations.
+func FindPrimaryAltEmailConflicts() ([]EmailConflict, error) {
+	addresses := make([]*EmailAddress, 0, 5)
+	if err := x.Where("is_activated=?", false).And(_PRIMARY_EMAIL_COND).Asc("id").Find(&addresses); err != nil {
+		return nil, fmt.Errorf("find email addresses: %v", err)
+	} else if len(addresses) == 0 {
+		return []EmailConflict{}, nil
+	}
+
+	uids := make([]int64, len(addresses))
+	for i := range addresses {
+		uids[i] = addresses[i].UID
+	}
+	users := make([]*User, 0, len(uids))
+	if err := x.Where("is_active=?", true).In("id", base.Int64sToStrings(uids)).Find(&users); err != nil {
+		return nil, fmt.Errorf("find users: %v", err)
+	}
+	return findEmailConflicts(users, addresses), nil
+}
+
+// groupEmailAddresses groups email addresses by ID of owner and keeps their order.
+func groupEmailAddresses(emails []*EmailAddress) map[int64][]*EmailAddress {
+	groups := make(map[int64][]*EmailAddress)
+	for i := range emails {
+		groups[emails[i].UID] = append(groups[emails[i].UID], emails[i])
+	}
+	return groups
+}
+
+// GetAllEmailAddressesGrouped returns alternative email addresses keyed by user ID,
+// pagination is applied to users who have any alternative email address.
+func GetAllEmailAddressesGrouped(page, pageSize int) (map[int64][]*EmailAddress, error) {
+	if page < 1 {
+		page = 1
+	}
+
+	results, err := x.Query("SELECT DISTINCT uid FROM `email_address` WHERE "+_NOT_PRIMARY_EMAIL_COND+
+		" ORDER BY uid LIMIT ? OFFSET ?", pageSize, (page-1)*pageSize)
+	if err != nil {
+		return nil, fmt.Errorf("find user IDs: %v", err)
+	} else if len(results) == 0 {
+		return map[int64][]*EmailAddress{}, nil
+	}
+
+	uids := make([]int64, len(results))
+	for i := range results {
+		uids[i] = com.StrTo(results[i]["uid"]).MustInt64()
+	}
+	emails := make([]*EmailAddress, 0, len(uids))
+	if err = x.In("uid", base.Int64sToStrings(uids)).And(_NOT_PRIMARY_EMAIL_COND).
+		Asc("uid").Asc("sort_order").Asc("id").Find(&emails); err != nil {
+		return nil, fmt.Errorf("find email addresses: %v", err)
+	}
+	return groupEmailAddresses(emails), nil
+}
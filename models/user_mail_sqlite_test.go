@@ -0,0 +1,218 @@
+// +build sqlite
+
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func Test_FindCaseInsensitiveEmailCollisions(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Only users of colliding emails are reported", t, func() {
+		_, err := x.Insert(
+			&User{Name: "user1", LowerName: "user1", Email: "A@x.com"},
+			&User{Name: "user2", LowerName: "user2", Email: "b@x.com"},
+			&User{Name: "user3", LowerName: "user3", Email: "a@x.com"},
+			&User{Name: "org4", LowerName: "org4", Email: "b@x.com", Type: USER_TYPE_ORGANIZATION},
+			&User{Name: "org5", LowerName: "org5", Type: USER_TYPE_ORGANIZATION},
+			&User{Name: "org6", LowerName: "org6", Type: USER_TYPE_ORGANIZATION},
+		)
+		So(err, ShouldBeNil)
+
+		collisions, err := FindCaseInsensitiveEmailCollisions()
+		So(err, ShouldBeNil)
+		So(collisions, ShouldResemble, []EmailCollision{
+			{Email: "a@x.com", Type: USER_TYPE_INDIVIDUAL, UserIDs: []int64{1, 3}},
+		})
+	})
+}
+
+func Test_EmailExists(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Email exists when it is used by an individual", t, func() {
+		_, err := x.Insert(
+			&User{Name: "user1", LowerName: "user1", Email: "user1@example.com"},
+			&User{Name: "org2", LowerName: "org2", Email: "org2@example.com", Type: USER_TYPE_ORGANIZATION},
+			&EmailAddress{UID: 1, Email: "alt1@example.com"},
+		)
+		So(err, ShouldBeNil)
+
+		for _, email := range []string{"user1@example.com", " User1@Example.com", "alt1@example.com", "org2@example.com", "none@example.com"} {
+			exists, err := EmailExists(email)
+			So(err, ShouldBeNil)
+			used, err := IsEmailUsedByType(email, USER_TYPE_INDIVIDUAL)
+			So(err, ShouldBeNil)
+			So(exists, ShouldEqual, used)
+		}
+
+		exists, err := EmailExists("org2@example.com")
+		So(err, ShouldBeNil)
+		So(exists, ShouldBeFalse)
+	})
+}
+
+func Test_IsEmailUsedByType(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Email is only taken among users of the same type", t, func() {
+		_, err := x.Insert(
+			&User{Name: "user1", LowerName: "user1", Email: "user1@example.com"},
+			&User{Name: "org2", LowerName: "org2", Email: "org2@example.com", Type: USER_TYPE_ORGANIZATION},
+			&EmailAddress{UID: 1, Email: "alt1@example.com"},
+		)
+		So(err, ShouldBeNil)
+
+		cases := []struct {
+			email    string
+			t        UserType
+			expected bool
+		}{
+			{"user1@example.com", USER_TYPE_INDIVIDUAL, true},
+			{"User1@Example.com ", USER_TYPE_INDIVIDUAL, true},
+			{"alt1@example.com", USER_TYPE_INDIVIDUAL, true},
+			{"org2@example.com", USER_TYPE_INDIVIDUAL, false},
+			{"org2@example.com", USER_TYPE_ORGANIZATION, true},
+			{"user1@example.com", USER_TYPE_ORGANIZATION, false},
+			{"alt1@example.com", USER_TYPE_ORGANIZATION, false},
+			{"", USER_TYPE_ORGANIZATION, true},
+		}
+		for _, c := range cases {
+			used, err := IsEmailUsedByType(c.email, c.t)
+			So(err, ShouldBeNil)
+			So(used, ShouldEqual, c.expected)
+		}
+	})
+}
+
+func Test_MakeEmailPrimaryByID(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Only activated email address of the user can become primary", t, func() {
+		alice := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com", IsActive: true}
+		bob := &User{Name: "bob", LowerName: "bob", Email: "bob@example.com", IsActive: true}
+		_, err := x.Insert(alice, bob)
+		So(err, ShouldBeNil)
+		activated := &EmailAddress{UID: alice.ID, Email: "alice2@example.com", IsActivated: true}
+		unactivated := &EmailAddress{UID: alice.ID, Email: "alice3@example.com"}
+		_, err = x.Insert(activated, unactivated)
+		So(err, ShouldBeNil)
+
+		So(MakeEmailPrimaryByID(bob.ID, activated.ID), ShouldEqual, ErrEmailNotExist)
+		So(MakeEmailPrimaryByID(alice.ID, activated.ID+100), ShouldEqual, ErrEmailNotExist)
+		So(MakeEmailPrimaryByID(alice.ID, unactivated.ID), ShouldEqual, ErrEmailNotActivated)
+		u, err := GetUserByID(bob.ID)
+		So(err, ShouldBeNil)
+		So(u.Email, ShouldEqual, "bob@example.com")
+
+		So(MakeEmailPrimaryByID(alice.ID, activated.ID), ShouldBeNil)
+		u, err = GetUserByID(alice.ID)
+		So(err, ShouldBeNil)
+		So(u.Email, ShouldEqual, "alice2@example.com")
+
+		// Former primary email is kept as alternative one.
+		former := &EmailAddress{Email: "alice@example.com"}
+		has, err := x.Get(former)
+		So(err, ShouldBeNil)
+		So(has, ShouldBeTrue)
+		So(former.UID, ShouldEqual, alice.ID)
+		So(former.IsActivated, ShouldBeTrue)
+	})
+}
+
+func Test_PurgeUnactivatedEmailAddresses(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Only old unactivated alternative email addresses are purged", t, func() {
+		_, err := x.Insert(&User{Name: "alice", LowerName: "alice", Email: "alice@example.com"})
+		So(err, ShouldBeNil)
+		stale := &EmailAddress{UID: 1, Email: "stale@example.com"}
+		recent := &EmailAddress{UID: 1, Email: "recent@example.com"}
+		activated := &EmailAddress{UID: 1, Email: "activated@example.com", IsActivated: true}
+		primary := &EmailAddress{UID: 1, Email: "alice@example.com"}
+		legacy := &EmailAddress{UID: 1, Email: "legacy@example.com"}
+		_, err = x.Insert(stale, recent, activated, primary, legacy)
+		So(err, ShouldBeNil)
+		_, err = x.Exec("UPDATE `email_address` SET created_unix=? WHERE id IN (?,?,?)",
+			time.Now().Add(-48*time.Hour).Unix(), stale.ID, activated.ID, primary.ID)
+		So(err, ShouldBeNil)
+		_, err = x.Exec("UPDATE `email_address` SET created_unix=0 WHERE id=?", legacy.ID)
+		So(err, ShouldBeNil)
+
+		emails, err := GetUnactivatedEmailAddresses(24 * time.Hour)
+		So(err, ShouldBeNil)
+		So(emails, ShouldHaveLength, 1)
+		So(emails[0].ID, ShouldEqual, stale.ID)
+
+		purged, err := PurgeUnactivatedEmailAddresses(24 * time.Hour)
+		So(err, ShouldBeNil)
+		So(purged, ShouldEqual, 1)
+		count, err := x.Count(new(EmailAddress))
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 4)
+	})
+}
+
+func Test_PurgeExpiredTokens(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Pending email changes are purged once activation code expires", t, func() {
+		defer func(lives int) { setting.Service.ActiveCodeLives = lives }(setting.Service.ActiveCodeLives)
+		setting.Service.ActiveCodeLives = 60
+		expired := &EmailAddress{UID: 1, Email: "expired@example.com"}
+		pending := &EmailAddress{UID: 1, Email: "pending@example.com"}
+		_, err := x.Insert(expired, pending)
+		So(err, ShouldBeNil)
+		_, err = x.Exec("UPDATE `email_address` SET created_unix=? WHERE id=?", time.Now().Add(-2*time.Hour).Unix(), expired.ID)
+		So(err, ShouldBeNil)
+
+		purged, err := PurgeExpiredTokens()
+		So(err, ShouldBeNil)
+		So(purged, ShouldEqual, 1)
+		has, err := x.Id(pending.ID).Get(new(EmailAddress))
+		So(err, ShouldBeNil)
+		So(has, ShouldBeTrue)
+	})
+}
+
+func Test_FindExistingEmails(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Map used emails to their owners in at most two queries", t, func() {
+		_, err := x.Insert(
+			&User{Name: "alice", LowerName: "alice", Email: "alice@example.com"},
+			&User{Name: "bob", LowerName: "bob", Email: "bob@example.com"},
+			&EmailAddress{UID: 1, Email: "alice2@example.com", IsActivated: true},
+			&EmailAddress{UID: 2, Email: "bob2@example.com"},
+		)
+		So(err, ShouldBeNil)
+
+		var existing map[string]int64
+		So(countQueries(func() {
+			existing, err = FindExistingEmails([]string{
+				"Alice@Example.com", " alice2@example.com", "bob@example.com",
+				"bob2@example.com", "ghost@example.com", "",
+			})
+		}), ShouldEqual, 2)
+		So(err, ShouldBeNil)
+		So(existing, ShouldResemble, map[string]int64{
+			"alice@example.com":  1,
+			"alice2@example.com": 1,
+			"bob@example.com":    2,
+		})
+
+		existing, err = FindExistingEmails(nil)
+		So(err, ShouldBeNil)
+		So(existing, ShouldBeEmpty)
+	})
+}
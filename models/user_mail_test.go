@@ -0,0 +1,126 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func Test_dedupEmailAddresses(t *testing.T) {
+	Convey("Duplicated primary email collapses to single primary entry", t, func() {
+		emails := dedupEmailAddresses([]*EmailAddress{
+			{ID: 1, Email: "alice@example.com", IsActivated: true},
+			{ID: 2, Email: "work@example.com"},
+			{ID: 3, Email: "Alice@Example.com ", IsActivated: true},
+		}, "alice@example.com")
+
+		So(emails, ShouldHaveLength, 2)
+		numPrimary := 0
+		for _, email := range emails {
+			if email.IsPrimary {
+				numPrimary++
+				So(email.ID, ShouldEqual, 1)
+			}
+		}
+		So(numPrimary, ShouldEqual, 1)
+	})
+
+	Convey("Missing primary email is appended", t, func() {
+		emails := dedupEmailAddresses([]*EmailAddress{
+			{ID: 2, Email: "work@example.com"},
+		}, "alice@example.com")
+
+		So(emails, ShouldHaveLength, 2)
+		So(emails[1].Email, ShouldEqual, "alice@example.com")
+		So(emails[1].IsPrimary, ShouldBeTrue)
+	})
+}
+
+func Test_primaryEmailFirst(t *testing.T) {
+	Convey("Primary email is moved to front and others keep order", t, func() {
+		emails := primaryEmailFirst([]*EmailAddress{
+			{ID: 2, Email: "work@example.com"},
+			{ID: 3, Email: "home@example.com"},
+			{ID: 1, Email: "alice@example.com", IsPrimary: true},
+			{ID: 4, Email: "old@example.com"},
+		})
+
+		ids := make([]int64, len(emails))
+		for i := range emails {
+			ids[i] = emails[i].ID
+		}
+		So(ids, ShouldResemble, []int64{1, 2, 3, 4})
+	})
+}
+
+func Test_groupEmailCollisions(t *testing.T) {
+	Convey("Case-only difference of primary emails is reported", t, func() {
+		collisions := groupEmailCollisions([]*User{
+			{ID: 1, Email: "A@x.com"},
+			{ID: 2, Email: "b@x.com"},
+			{ID: 3, Email: "a@x.com"},
+			{ID: 4, Email: "b@x.com", Type: USER_TYPE_ORGANIZATION},
+			{ID: 5},
+			{ID: 6},
+		})
+		So(collisions, ShouldResemble, []EmailCollision{
+			{Email: "a@x.com", Type: USER_TYPE_INDIVIDUAL, UserIDs: []int64{1, 3}},
+		})
+	})
+}
+
+func Test_normalizeEmailDomain(t *testing.T) {
+	Convey("Normalize email domain", t, func() {
+		So(normalizeEmailDomain(" @Example.COM "), ShouldEqual, "example.com")
+		So(normalizeEmailDomain("example.com"), ShouldEqual, "example.com")
+		So(normalizeEmailDomain("@"), ShouldBeEmpty)
+	})
+}
+
+func Test_activationCodeLifetime(t *testing.T) {
+	Convey("Activation code lifetime follows service setting", t, func() {
+		defer func(lives int) { setting.Service.ActiveCodeLives = lives }(setting.Service.ActiveCodeLives)
+		setting.Service.ActiveCodeLives = 180
+		So(activationCodeLifetime(), ShouldEqual, 3*time.Hour)
+	})
+}
+
+func Test_findEmailConflicts(t *testing.T) {
+	Convey("Unactivated alternative row of active user's primary email is reported", t, func() {
+		users := []*User{
+			{ID: 1, Email: "alice@example.com", IsActive: true},
+			{ID: 2, Email: "bob@example.com"},
+		}
+		addresses := []*EmailAddress{
+			{ID: 10, UID: 1, Email: "Alice@Example.com"},
+			{ID: 11, UID: 1, Email: "other@example.com"},
+			{ID: 12, UID: 2, Email: "bob@example.com"},
+			{ID: 13, UID: 1, Email: "alice@example.com", IsActivated: true},
+		}
+		So(findEmailConflicts(users, addresses), ShouldResemble, []EmailConflict{
+			{UID: 1, Email: "alice@example.com", EmailID: 10},
+		})
+	})
+}
+
+func Test_groupEmailAddresses(t *testing.T) {
+	Convey("Group email addresses by owner", t, func() {
+		emails := []*EmailAddress{
+			{ID: 1, UID: 1, Email: "a1@example.com"},
+			{ID: 2, UID: 2, Email: "b1@example.com"},
+			{ID: 3, UID: 1, Email: "a2@example.com"},
+		}
+		So(groupEmailAddresses(emails), ShouldResemble, map[int64][]*EmailAddress{
+			1: {emails[0], emails[2]},
+			2: {emails[1]},
+		})
+		So(groupEmailAddresses(nil), ShouldBeEmpty)
+	})
+}
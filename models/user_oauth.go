@@ -0,0 +1,181 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-xorm/xorm"
+)
+
+// ExternalLoginUser represents a link between a user and an account of external OAuth provider.
+type ExternalLoginUser struct {
+	ID         int64  `xorm:"pk autoincr"`
+	Provider   string `xorm:"UNIQUE(s) NOT NULL"`
+	ExternalID string `xorm:"UNIQUE(s) NOT NULL"`
+	UID        int64  `xorm:"INDEX NOT NULL"`
+
+	Created     time.Time `xorm:"-"`
+	CreatedUnix int64
+}
+
+func (l *ExternalLoginUser) BeforeInsert() {
+	l.CreatedUnix = time.Now().Unix()
+}
+
+func (l *ExternalLoginUser) AfterSet(colName string, _ xorm.Cell) {
+	switch colName {
+	case "created_unix":
+		l.Created = time.Unix(l.CreatedUnix, 0).Local()
+	}
+}
+
+// newOAuthUser returns a new active user with verified email returned by OAuth provider,
+// the random password is never told to anyone and can only be changed by resetting.
+func newOAuthUser(email, name string) (*User, error) {
+	if err := ValidateUserName(name); err != nil {
+		return nil, err
+	}
+	return &User{
+		Name:     name,
+		Email:    strings.ToLower(strings.TrimSpace(email)),
+		Passwd:   GetUserSalt(),
+		IsActive: true,
+	}, nil
+}
+
+// canAutoLinkOAuthUser returns true if external account with given verified email
+// can be linked to user automatically, which requires the email to be verified
+// on our side as well: either primary email of an active user or one of given
+// activated email addresses of the user.
+func canAutoLinkOAuthUser(u *User, email string, activated []*EmailAddress) bool {
+	if u == nil || u.IsOrganization() || u.IsDeleted || !u.IsActive {
+		return false
+	} else if u.Email == email {
+		return true
+	}
+	for i := range activated {
+		if activated[i].UID == u.ID && activated[i].IsActivated && activated[i].Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+// getOAuthLinkTarget returns the user who owns given email as verified primary
+// or activated alternative email, it returns nil if there is none.
+// No-reply addresses are never resolved.
+func getOAuthLinkTarget(e Engine, email string) (*User, error) {
+	if len(email) == 0 {
+		return nil, nil
+	}
+
+	u := new(User)
+	has, err := e.Where("email=?", email).And("type=?", USER_TYPE_INDIVIDUAL).Get(u)
+	if err != nil {
+		return nil, fmt.Errorf("get user by primary email: %v", err)
+	} else if has {
+		if canAutoLinkOAuthUser(u, email, nil) {
+			return u, nil
+		}
+		return nil, nil
+	}
+
+	activated := make([]*EmailAddress, 0, 1)
+	if err = e.Where("email=?", email).And("is_activated=?", true).Find(&activated); err != nil {
+		return nil, fmt.Errorf("find email addresses: %v", err)
+	} else if len(activated) == 0 {
+		return nil, nil
+	}
+	u, err = getUserByID(e, activated[0].UID)
+	if err != nil {
+		if IsErrUserNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("getUserByID: %v", err)
+	} else if !canAutoLinkOAuthUser(u, email, activated) {
+		return nil, nil
+	}
+	return u, nil
+}
+
+// linkOAuthUser links user to external account.
+func linkOAuthUser(e Engine, u *User, provider, externalID string) error {
+	if _, err := e.Insert(&ExternalLoginUser{
+		Provider:   provider,
+		ExternalID: externalID,
+		UID:        u.ID,
+	}); err != nil {
+		return fmt.Errorf("insert external login user: %v", err)
+	}
+	return nil
+}
+
+// EnsureOAuthUser returns the user linked to given external account of OAuth provider.
+// For first-time login, the active user who owns given email as a verified address
+// is linked, or a new active user is created by the same rules as CreateUser.
+// An unverified account that uses the email is never linked, creating a new user
+// fails with ErrEmailAlreadyUsed then and the account has to be linked explicitly.
+func EnsureOAuthUser(provider, externalID, email, name string) (_ *User, err error) {
+	if len(provider) == 0 || len(externalID) == 0 {
+		return nil, ErrUserNotExist{0, externalID}
+	}
+
+	link := &ExternalLoginUser{Provider: provider, ExternalID: externalID}
+	has, err := x.Get(link)
+	if err != nil {
+		return nil, fmt.Errorf("get external login user: %v", err)
+	} else if has {
+		u, err := GetUserByID(link.UID)
+		if err != nil {
+			return nil, err
+		} else if u.IsOrganization() {
+			return nil, ErrUserNotExist{link.UID, ""}
+		}
+		return u, nil
+	}
+
+	email = strings.ToLower(strings.TrimSpace(email))
+	u, err := getOAuthLinkTarget(x, email)
+	if err != nil {
+		return nil, err
+	}
+	isNew := u == nil
+	if isNew {
+		if u, err = newOAuthUser(email, name); err != nil {
+			return nil, err
+		} else if err = prepareNewUser(u); err != nil {
+			return nil, err
+		}
+	}
+
+	sess := x.NewSession()
+	defer sessionRelease(sess)
+	if err = sess.Begin(); err != nil {
+		return nil, err
+	}
+
+	if isNew {
+		if _, err = sess.Insert(u); err != nil {
+			return nil, err
+		} else if err = os.MkdirAll(UserPath(u.Name), os.ModePerm); err != nil {
+			return nil, err
+		}
+		defer func() {
+			if err != nil {
+				os.RemoveAll(UserPath(u.Name))
+			}
+		}()
+	}
+
+	if err = linkOAuthUser(sess, u, provider, externalID); err != nil {
+		return nil, err
+	}
+
+	return u, sess.Commit()
+}
@@ -0,0 +1,57 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_newOAuthUser(t *testing.T) {
+	Convey("OAuth user is provisioned as active", t, func() {
+		u, err := newOAuthUser(" Alice@Example.com", "alice")
+		So(err, ShouldBeNil)
+		So(u.Name, ShouldEqual, "alice")
+		So(u.Email, ShouldEqual, "alice@example.com")
+		So(u.IsActive, ShouldBeTrue)
+		So(u.Passwd, ShouldNotBeEmpty)
+
+		bob, err := newOAuthUser("bob@example.com", "bob")
+		So(err, ShouldBeNil)
+		So(bob.Passwd, ShouldNotEqual, u.Passwd)
+	})
+
+	Convey("Name from OAuth provider must be a legal user name", t, func() {
+		for _, name := range []string{"alice.", "bad name", "admin", ""} {
+			_, err := newOAuthUser("alice@example.com", name)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func Test_canAutoLinkOAuthUser(t *testing.T) {
+	Convey("Only verified email of active individual user is linked automatically", t, func() {
+		u := &User{ID: 1, Email: "alice@example.com", IsActive: true}
+		So(canAutoLinkOAuthUser(u, "alice@example.com", nil), ShouldBeTrue)
+
+		activated := []*EmailAddress{
+			{UID: 1, Email: "alt@example.com", IsActivated: true},
+			{UID: 1, Email: "pending@example.com"},
+			{UID: 2, Email: "other@example.com", IsActivated: true},
+		}
+		So(canAutoLinkOAuthUser(u, "alt@example.com", activated), ShouldBeTrue)
+		So(canAutoLinkOAuthUser(u, "pending@example.com", activated), ShouldBeFalse)
+		So(canAutoLinkOAuthUser(u, "other@example.com", activated), ShouldBeFalse)
+
+		// Pre-registered account with someone else's email is not verified.
+		unverified := &User{ID: 3, Email: "victim@example.com"}
+		So(canAutoLinkOAuthUser(unverified, "victim@example.com", nil), ShouldBeFalse)
+
+		org := &User{ID: 4, Email: "org@example.com", IsActive: true, Type: USER_TYPE_ORGANIZATION}
+		So(canAutoLinkOAuthUser(org, "org@example.com", nil), ShouldBeFalse)
+		So(canAutoLinkOAuthUser(nil, "alice@example.com", nil), ShouldBeFalse)
+	})
+}
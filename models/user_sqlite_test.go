@@ -0,0 +1,1194 @@
+// +build sqlite
+
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gogits/git-module"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func Test_SetProfileRepo(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Only public repository owned by user can be profile repository", t, func() {
+		owner := &User{Name: "owner", LowerName: "owner", Email: "owner@example.com"}
+		other := &User{Name: "other", LowerName: "other", Email: "other@example.com"}
+		_, err := x.Insert(owner, other)
+		So(err, ShouldBeNil)
+		_, err = x.Insert(
+			&Repository{OwnerID: owner.ID, Name: "Profile", LowerName: "profile"},
+			&Repository{OwnerID: owner.ID, Name: "secret", LowerName: "secret", IsPrivate: true},
+			&Repository{OwnerID: other.ID, Name: "others", LowerName: "others"},
+		)
+		So(err, ShouldBeNil)
+
+		So(IsErrRepoNotExist(SetProfileRepo(owner, "others")), ShouldBeTrue)
+		So(IsErrRepoNotExist(SetProfileRepo(owner, "secret")), ShouldBeTrue)
+		So(IsErrRepoNotExist(SetProfileRepo(owner, "ghost")), ShouldBeTrue)
+
+		So(SetProfileRepo(owner, "profile"), ShouldBeNil)
+		u, err := GetUserByID(owner.ID)
+		So(err, ShouldBeNil)
+		So(u.ProfileRepo, ShouldEqual, "Profile")
+
+		So(SetProfileRepo(owner, ""), ShouldBeNil)
+		u, err = GetUserByID(owner.ID)
+		So(err, ShouldBeNil)
+		So(u.ProfileRepo, ShouldBeEmpty)
+	})
+}
+
+func insertTestUsers(tb testing.TB, n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("User%d", i)
+		u := &User{Name: names[i], LowerName: strings.ToLower(names[i]), Email: names[i] + "@example.com"}
+		if _, err := x.Insert(u); err != nil {
+			tb.Fatalf("Insert: %v", err)
+		}
+	}
+	return names
+}
+
+func Test_GetUserEmailsByNames(t *testing.T) {
+	setTestEngine(t)
+	names := insertTestUsers(t, 20)
+
+	Convey("Resolve names with a single query regardless of count", t, func() {
+		var mails []string
+		So(countQueries(func() {
+			mails = GetUserEmailsByNames(append([]string{"ghost"}, names...))
+		}), ShouldEqual, 1)
+		So(mails, ShouldHaveLength, len(names))
+		So(mails[0], ShouldEqual, "User0@example.com")
+
+		var ids []int64
+		So(countQueries(func() {
+			ids = GetUserIDsByNames(names)
+		}), ShouldEqual, 1)
+		So(ids, ShouldHaveLength, len(names))
+
+		// Resolving names one by one takes a query per name.
+		So(countQueries(func() {
+			for _, name := range names {
+				GetUserByName(name)
+			}
+		}), ShouldEqual, len(names))
+	})
+}
+
+func Benchmark_GetUserEmailsByNames(b *testing.B) {
+	setTestEngine(b)
+	names := insertTestUsers(b, 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		GetUserEmailsByNames(names)
+	}
+}
+
+func Benchmark_GetUserByName_perName(b *testing.B) {
+	setTestEngine(b)
+	names := insertTestUsers(b, 100)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, name := range names {
+			GetUserByName(name)
+		}
+	}
+}
+
+func Test_IsUserNameAvailable(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Report why name is not available", t, func() {
+		_, err := x.Insert(&User{Name: "alice", LowerName: "alice", Email: "alice@example.com"})
+		So(err, ShouldBeNil)
+
+		for name, reason := range map[string]string{
+			"":        "empty",
+			"al ice":  "illegal",
+			"admin":   "reserved",
+			"a.keys":  "pattern_not_allowed",
+			"alice":   "taken",
+			"ALICE":   "taken",
+			"bob":     "",
+			"alice-2": "",
+		} {
+			available, r, err := IsUserNameAvailable(name)
+			So(err, ShouldBeNil)
+			So(available, ShouldEqual, len(reason) == 0)
+			So(r, ShouldEqual, reason)
+		}
+	})
+}
+
+func Test_IsUserNameAvailable_ConfusableAndRenamed(t *testing.T) {
+	setTestEngine(t)
+	defer setTestDirs(t)()
+
+	Convey("Names looking like existing ones are not available", t, func() {
+		_, err := x.Insert(&User{Name: "william_1", LowerName: "william_1", Email: "william@example.com"})
+		So(err, ShouldBeNil)
+
+		for _, name := range []string{"wil1iam_1", "WILLIAM-L", "vvilliam_l"} {
+			available, reason, err := IsUserNameAvailable(name)
+			So(err, ShouldBeNil)
+			So(available, ShouldBeFalse)
+			So(reason, ShouldEqual, "confusable")
+		}
+		available, _, err := IsUserNameAvailable("william_2")
+		So(err, ShouldBeNil)
+		So(available, ShouldBeTrue)
+	})
+
+	Convey("Old name of renamed user is held during cooldown", t, func() {
+		setting.Service.DeletedUserNameCooldown = 60
+		defer func() { setting.Service.DeletedUserNameCooldown = 0 }()
+		u := &User{Name: "carol", LowerName: "carol", Email: "carol@example.com"}
+		_, err := x.Insert(u)
+		So(err, ShouldBeNil)
+		So(os.MkdirAll(UserPath("carol"), os.ModePerm), ShouldBeNil)
+
+		So(ChangeUserName(u, "caroline"), ShouldBeNil)
+		available, reason, err := IsUserNameAvailable("carol")
+		So(err, ShouldBeNil)
+		So(available, ShouldBeFalse)
+		So(reason, ShouldEqual, "taken")
+	})
+}
+
+func Test_ChangeUserName(t *testing.T) {
+	setTestEngine(t)
+	setting.RepoRootPath, _ = ioutil.TempDir("", "gogs-user")
+	defer os.RemoveAll(setting.RepoRootPath)
+
+	Convey("Login name is renamed only when it mirrors user name", t, func() {
+		mirrored := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com", LoginName: "alice"}
+		external := &User{Name: "bob", LowerName: "bob", Email: "bob@example.com", LoginName: "uid=bob"}
+		_, err := x.Insert(mirrored, external)
+		So(err, ShouldBeNil)
+		os.MkdirAll(UserPath("alice"), os.ModePerm)
+		os.MkdirAll(UserPath("bob"), os.ModePerm)
+
+		So(ChangeUserName(mirrored, "alice2"), ShouldBeNil)
+		u, err := GetUserByID(mirrored.ID)
+		So(err, ShouldBeNil)
+		So(u.LowerName, ShouldEqual, "alice2")
+		So(u.LoginName, ShouldEqual, "alice2")
+
+		So(ChangeUserName(external, "bob2"), ShouldBeNil)
+		u, err = GetUserByID(external.ID)
+		So(err, ShouldBeNil)
+		So(u.LowerName, ShouldEqual, "bob2")
+		So(u.LoginName, ShouldEqual, "uid=bob")
+	})
+}
+
+func Test_GetMostFollowedUsers(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Order active users by number of followers", t, func() {
+		_, err := x.Insert(
+			&User{Name: "few", LowerName: "few", Email: "few@example.com", IsActive: true, NumFollowers: 1},
+			&User{Name: "many", LowerName: "many", Email: "many@example.com", IsActive: true, NumFollowers: 10},
+			&User{Name: "inactive", LowerName: "inactive", Email: "inactive@example.com", NumFollowers: 20},
+			&User{Name: "suspended", LowerName: "suspended", Email: "suspended@example.com", IsActive: true, ProhibitLogin: true, NumFollowers: 30},
+			&User{Name: "some", LowerName: "some", Email: "some@example.com", IsActive: true, NumFollowers: 5},
+		)
+		So(err, ShouldBeNil)
+
+		users, err := GetMostFollowedUsers(10)
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 3)
+		So(users[0].Name, ShouldEqual, "many")
+		So(users[1].Name, ShouldEqual, "some")
+		So(users[2].Name, ShouldEqual, "few")
+
+		users, err = GetMostFollowedUsers(1)
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 1)
+		So(users[0].Name, ShouldEqual, "many")
+	})
+}
+
+func Test_IsCommitAuthorVerified(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Only activated e-mails of user are verified", t, func() {
+		active := &User{Name: "active", LowerName: "active", Email: "active@example.com", IsActive: true}
+		inactive := &User{Name: "inactive", LowerName: "inactive", Email: "inactive@example.com"}
+		_, err := x.Insert(active, inactive)
+		So(err, ShouldBeNil)
+		_, err = x.Insert(
+			&EmailAddress{UID: active.ID, Email: "verified@example.com", IsActivated: true},
+			&EmailAddress{UID: active.ID, Email: "unverified@example.com"},
+		)
+		So(err, ShouldBeNil)
+
+		commitBy := func(email string) *git.Commit {
+			return &git.Commit{Author: &git.Signature{Email: email}}
+		}
+
+		verified, u := IsCommitAuthorVerified(commitBy("Active@example.com"))
+		So(verified, ShouldBeTrue)
+		So(u.ID, ShouldEqual, active.ID)
+
+		verified, u = IsCommitAuthorVerified(commitBy("verified@example.com"))
+		So(verified, ShouldBeTrue)
+		So(u.ID, ShouldEqual, active.ID)
+
+		verified, u = IsCommitAuthorVerified(commitBy("unverified@example.com"))
+		So(verified, ShouldBeFalse)
+		So(u.ID, ShouldEqual, active.ID)
+
+		verified, u = IsCommitAuthorVerified(commitBy("inactive@example.com"))
+		So(verified, ShouldBeFalse)
+		So(u.ID, ShouldEqual, inactive.ID)
+
+		verified, u = IsCommitAuthorVerified(commitBy("ghost@example.com"))
+		So(verified, ShouldBeFalse)
+		So(u, ShouldBeNil)
+	})
+}
+
+func Test_SetHideEmailForAll(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Set option of hiding e-mail for all individual users", t, func() {
+		_, err := x.Insert(
+			&User{Name: "shown", LowerName: "shown", Email: "shown@example.com"},
+			&User{Name: "hidden", LowerName: "hidden", Email: "hidden@example.com", HideEmail: true},
+			&User{Name: "org", LowerName: "org", Email: "org@example.com", Type: USER_TYPE_ORGANIZATION},
+		)
+		So(err, ShouldBeNil)
+
+		numHidden := func() int64 {
+			count, err := x.Where("hide_email=?", true).Count(new(User))
+			So(err, ShouldBeNil)
+			return count
+		}
+
+		changed, err := SetHideEmailForAll(true)
+		So(err, ShouldBeNil)
+		So(changed, ShouldEqual, 1)
+		So(numHidden(), ShouldEqual, 2)
+
+		changed, err = SetHideEmailForAll(false)
+		So(err, ShouldBeNil)
+		So(changed, ShouldEqual, 2)
+		So(numHidden(), ShouldEqual, 0)
+	})
+}
+
+func Test_GetRecentlyRegisteredUsers(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Order users by creation time descending", t, func() {
+		oldest := &User{Name: "oldest", LowerName: "oldest", Email: "oldest@example.com", IsActive: true}
+		newest := &User{Name: "newest", LowerName: "newest", Email: "newest@example.com"}
+		middle := &User{Name: "middle", LowerName: "middle", Email: "middle@example.com", IsActive: true}
+		org := &User{Name: "org", LowerName: "org", Email: "org@example.com", Type: USER_TYPE_ORGANIZATION}
+		_, err := x.Insert(oldest, newest, middle, org)
+		So(err, ShouldBeNil)
+		for created, u := range []*User{oldest, middle, newest, org} {
+			_, err = x.Exec("UPDATE `user` SET created_unix=? WHERE id=?", 1000+created, u.ID)
+			So(err, ShouldBeNil)
+		}
+
+		users, err := GetRecentlyRegisteredUsers(10)
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 3)
+		So(users[0].Name, ShouldEqual, "newest")
+		So(users[0].IsActive, ShouldBeFalse)
+		So(users[1].Name, ShouldEqual, "middle")
+		So(users[2].Name, ShouldEqual, "oldest")
+
+		users, err = GetRecentlyRegisteredUsers(2)
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 2)
+	})
+}
+
+func Test_InvalidateUserSessions(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Rotate rands and increase session epoch", t, func() {
+		u := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com", Rands: "rands", SessionEpoch: 3}
+		_, err := x.Insert(u)
+		So(err, ShouldBeNil)
+
+		So(InvalidateUserSessions(u.ID), ShouldBeNil)
+		u2, err := GetUserByID(u.ID)
+		So(err, ShouldBeNil)
+		So(u2.SessionEpoch, ShouldEqual, 4)
+		So(u2.Rands, ShouldNotEqual, "rands")
+
+		So(InvalidateUserSessions(u.ID), ShouldBeNil)
+		u3, err := GetUserByID(u.ID)
+		So(err, ShouldBeNil)
+		So(u3.SessionEpoch, ShouldEqual, 5)
+		So(u3.Rands, ShouldNotEqual, u2.Rands)
+
+		So(u3.IsValidSessionEpoch(3), ShouldBeFalse)
+		So(u3.IsValidSessionEpoch(4), ShouldBeFalse)
+		So(u3.IsValidSessionEpoch(5), ShouldBeTrue)
+	})
+}
+
+func Test_IsNameTaken(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Users and organizations share the same namespace", t, func() {
+		setting.Service.DeletedUserNameCooldown = 60
+		defer func() {
+			setting.Service.DeletedUserNameCooldown = 0
+		}()
+
+		_, err := x.Insert(
+			&User{Name: "alice", LowerName: "alice", Email: "alice@example.com"},
+			&User{Name: "team", LowerName: "team", Email: "team@example.com", Type: USER_TYPE_ORGANIZATION},
+		)
+		So(err, ShouldBeNil)
+		So(recordDeletedUserName(x, "Gone"), ShouldBeNil)
+
+		for name, expected := range map[string]bool{
+			"alice": true,
+			"Alice": true,
+			"TEAM":  true,
+			"gone":  true,
+			"bob":   false,
+			"":      false,
+		} {
+			taken, err := IsNameTaken(name)
+			So(err, ShouldBeNil)
+			So(taken, ShouldEqual, expected)
+		}
+
+		err = CreateOrganization(&User{Name: "Alice", Email: "org@example.com", Type: USER_TYPE_ORGANIZATION}, nil)
+		So(IsErrUserAlreadyExist(err), ShouldBeTrue)
+		err = CreateUser(&User{Name: "team", Email: "bob@example.com", Passwd: "password"})
+		So(IsErrUserAlreadyExist(err), ShouldBeTrue)
+	})
+}
+
+func Test_SetDefaultRepoVisibility(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Persist personal default visibility of new repository", t, func() {
+		u := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com"}
+		_, err := x.Insert(u)
+		So(err, ShouldBeNil)
+
+		So(SetDefaultRepoVisibility(u, true), ShouldBeNil)
+		u2, err := GetUserByID(u.ID)
+		So(err, ShouldBeNil)
+		So(u2.DefaultRepoPrivate, ShouldBeTrue)
+
+		So(SetDefaultRepoVisibility(u, false), ShouldBeNil)
+		u2, err = GetUserByID(u.ID)
+		So(err, ShouldBeNil)
+		So(u2.DefaultRepoPrivate, ShouldBeFalse)
+	})
+}
+
+func Test_GetMutualFollows(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Only reciprocal follows are mutual", t, func() {
+		_, err := x.Insert(
+			&User{Name: "alice", LowerName: "alice", Email: "alice@example.com"},
+			&User{Name: "bob", LowerName: "bob", Email: "bob@example.com"},
+			&User{Name: "carol", LowerName: "carol", Email: "carol@example.com"},
+			&User{Name: "dave", LowerName: "dave", Email: "dave@example.com"},
+			&User{Name: "erin", LowerName: "erin", Email: "erin@example.com"},
+		)
+		So(err, ShouldBeNil)
+		_, err = x.Insert(
+			// alice <-> bob, alice <-> erin
+			&Follow{UserID: 1, FollowID: 2},
+			&Follow{UserID: 2, FollowID: 1},
+			&Follow{UserID: 1, FollowID: 5},
+			&Follow{UserID: 5, FollowID: 1},
+			// alice -> carol, dave -> alice
+			&Follow{UserID: 1, FollowID: 3},
+			&Follow{UserID: 4, FollowID: 1},
+		)
+		So(err, ShouldBeNil)
+
+		users, err := GetMutualFollows(1, 1, 10)
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 2)
+		So(users[0].Name, ShouldEqual, "bob")
+		So(users[1].Name, ShouldEqual, "erin")
+
+		users, err = GetMutualFollows(1, 2, 1)
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 1)
+		So(users[0].Name, ShouldEqual, "erin")
+
+		users, err = GetMutualFollows(3, 1, 10)
+		So(err, ShouldBeNil)
+		So(users, ShouldBeEmpty)
+	})
+}
+
+func Test_CreateUserWithKey(t *testing.T) {
+	setTestEngine(t)
+	defer setTestDirs(t)()
+	const key = "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAABAQDMZXh+1OBUwSH9D45wTaxErQIN9IoC9xl7MKJkqvTvv6O5RR9YW/IK9FbfjXgXsppYGhsCZo1hFOOsXHMnfOORqu/xMDx4yPuyvKpw4LePEcg4TDipaDFuxbWOqc/BUZRZcXu41QAWfDLrInwsltWZHSeG7hjhpacl4FrVv9V1pS6Oc5Q1NxxEzTzuNLS/8diZrTm/YAQQ/+B+mzWI3zEtF4miZjjAljWd1LTBPvU23d29DcBmmFahcZ441XZsTeAwGxG/Q6j8NgNXj9WxMeWwxXV2jeAX/EBSpZrCVlCQ1yJswT6xCp8TuBnTiGWYMBNTbOZvPC4e0WI2/yZW/s5F nocomment"
+	setting.SSH.Disabled = false
+	setting.SSH.StartBuiltinServer = false
+	setting.SSH.MinimumKeySizeCheck = false
+
+	Convey("Invalid key leaves no user behind", t, func() {
+		So(CreateUserWithKey(&User{Name: "alice", Email: "alice@example.com", Passwd: "password"}, "ssh-rsa invalid", "laptop"), ShouldNotBeNil)
+
+		has, err := x.Where("lower_name=?", "alice").Get(new(User))
+		So(err, ShouldBeNil)
+		So(has, ShouldBeFalse)
+	})
+
+	Convey("Failure after user insert rolls it back", t, func() {
+		// Writing authorized_keys fails after both user and key are inserted.
+		root := setting.SSH.RootPath
+		setting.SSH.RootPath = root + "/missing"
+		So(CreateUserWithKey(&User{Name: "alice", Email: "alice@example.com", Passwd: "password"}, key, "laptop"), ShouldNotBeNil)
+		setting.SSH.RootPath = root
+
+		has, err := x.Where("lower_name=?", "alice").Get(new(User))
+		So(err, ShouldBeNil)
+		So(has, ShouldBeFalse)
+		num, err := x.Count(new(PublicKey))
+		So(err, ShouldBeNil)
+		So(num, ShouldEqual, 0)
+		_, err = os.Stat(UserPath("alice"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		u := &User{Name: "alice", Email: "alice@example.com", Passwd: "password"}
+		So(CreateUserWithKey(u, key, "laptop"), ShouldBeNil)
+		num, err = x.Where("owner_id=?", u.ID).Count(new(PublicKey))
+		So(err, ShouldBeNil)
+		So(num, ShouldEqual, 1)
+	})
+}
+
+func Test_AutocompleteUsers(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Match active users and organizations by name prefix", t, func() {
+		_, err := x.Insert(
+			&User{Name: "Alice", LowerName: "alice", Email: "alice@example.com", IsActive: true},
+			&User{Name: "alfred", LowerName: "alfred", Email: "alfred@example.com", IsActive: true},
+			&User{Name: "al_org", LowerName: "al_org", Email: "al_org@example.com", Type: USER_TYPE_ORGANIZATION},
+			&User{Name: "albert", LowerName: "albert", Email: "albert@example.com"},
+			&User{Name: "malice", LowerName: "malice", Email: "malice@example.com", IsActive: true},
+		)
+		So(err, ShouldBeNil)
+
+		names := func(prefix string, limit int) []string {
+			users, err := AutocompleteUsers(prefix, limit)
+			So(err, ShouldBeNil)
+			names := make([]string, len(users))
+			for i := range users {
+				names[i] = users[i].LowerName
+			}
+			return names
+		}
+
+		So(names("Al", 10), ShouldResemble, []string{"al_org", "alfred", "alice"})
+		So(names("al", 2), ShouldResemble, []string{"al_org", "alfred"})
+		So(names("lic", 10), ShouldBeEmpty)
+		So(names("al_", 10), ShouldResemble, []string{"al_org"})
+		So(names("a%", 10), ShouldBeEmpty)
+		So(names("", 10), ShouldBeEmpty)
+	})
+}
+
+func Test_FindUsersWithSharedPasswordHash(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Report users with identical password hash and salt", t, func() {
+		_, err := x.Insert(
+			&User{Name: "user1", LowerName: "user1", Email: "user1@example.com", Passwd: "hash", Salt: "salt"},
+			&User{Name: "user2", LowerName: "user2", Email: "user2@example.com", Passwd: "hash", Salt: "other"},
+			&User{Name: "user3", LowerName: "user3", Email: "user3@example.com", Passwd: "hash", Salt: "salt"},
+			&User{Name: "user4", LowerName: "user4", Email: "user4@example.com", Passwd: "unique", Salt: "salt"},
+			&User{Name: "org5", LowerName: "org5", Email: "org5@example.com", Type: USER_TYPE_ORGANIZATION},
+			&User{Name: "org6", LowerName: "org6", Email: "org6@example.com", Type: USER_TYPE_ORGANIZATION},
+		)
+		So(err, ShouldBeNil)
+
+		groups, err := FindUsersWithSharedPasswordHash()
+		So(err, ShouldBeNil)
+		So(groups, ShouldHaveLength, 1)
+		So(groups[0].Users, ShouldHaveLength, 2)
+		So(groups[0].Users[0].Name, ShouldEqual, "user1")
+		So(groups[0].Users[1].Name, ShouldEqual, "user3")
+	})
+}
+
+func Test_TrimUserNames(t *testing.T) {
+	setTestEngine(t)
+	defer setTestDirs(t)()
+
+	Convey("Trim names and leave collisions alone", t, func() {
+		for i, name := range []string{"alice.", "bob ", "carol", "carol.", "..."} {
+			_, err := x.Insert(&User{Name: name, LowerName: name, Email: fmt.Sprintf("user%d@example.com", i)})
+			So(err, ShouldBeNil)
+			So(os.MkdirAll(UserPath(name), os.ModePerm), ShouldBeNil)
+		}
+
+		count, err := TrimUserNames()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+
+		for _, name := range []string{"alice", "bob", "carol", "carol.", "..."} {
+			has, err := x.Where("name=?", name).Get(new(User))
+			So(err, ShouldBeNil)
+			So(has, ShouldBeTrue)
+		}
+		_, err = os.Stat(UserPath("alice"))
+		So(err, ShouldBeNil)
+
+		count, err = TrimUserNames()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 0)
+	})
+}
+
+func Test_GetUserJoinRank(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Rank individual users by registration time", t, func() {
+		first := &User{Name: "first", LowerName: "first", Email: "first@example.com"}
+		org := &User{Name: "org", LowerName: "org", Type: USER_TYPE_ORGANIZATION}
+		second := &User{Name: "second", LowerName: "second", Email: "second@example.com"}
+		third := &User{Name: "third", LowerName: "third", Email: "third@example.com"}
+		_, err := x.Insert(first, org, second, third)
+		So(err, ShouldBeNil)
+		for created, u := range map[int64]*User{100: first, 150: org, 200: second} {
+			_, err = x.Exec("UPDATE `user` SET created_unix=? WHERE id=?", created, u.ID)
+			So(err, ShouldBeNil)
+		}
+		// Ties are broken by ID.
+		_, err = x.Exec("UPDATE `user` SET created_unix=? WHERE id=?", 200, third.ID)
+		So(err, ShouldBeNil)
+
+		for rank, u := range []*User{first, second, third} {
+			r, err := GetUserJoinRank(u.ID)
+			So(err, ShouldBeNil)
+			So(r, ShouldEqual, rank+1)
+		}
+
+		_, err = GetUserJoinRank(org.ID)
+		So(IsErrUserNotExist(err), ShouldBeTrue)
+		_, err = GetUserJoinRank(1000)
+		So(IsErrUserNotExist(err), ShouldBeTrue)
+	})
+}
+
+func Test_PurgeUserFollows(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Remove follows from and to user and fix counters of others", t, func() {
+		insertTestUsers(t, 3)
+		alice, bob, carol := int64(1), int64(2), int64(3)
+		So(FollowUser(alice, bob), ShouldBeNil)
+		So(FollowUser(bob, alice), ShouldBeNil)
+		So(FollowUser(carol, alice), ShouldBeNil)
+		So(FollowUser(carol, bob), ShouldBeNil)
+
+		So(PurgeUserFollows(alice), ShouldBeNil)
+
+		num, err := x.Where("user_id=? OR follow_id=?", alice, alice).Count(new(Follow))
+		So(err, ShouldBeNil)
+		So(num, ShouldEqual, 0)
+		num, err = x.Count(new(Follow))
+		So(err, ShouldBeNil)
+		So(num, ShouldEqual, 1)
+
+		for uid, counts := range map[int64][2]int{alice: {0, 0}, bob: {1, 0}, carol: {0, 1}} {
+			u, err := GetUserByID(uid)
+			So(err, ShouldBeNil)
+			So(u.NumFollowers, ShouldEqual, counts[0])
+			So(u.NumFollowing, ShouldEqual, counts[1])
+		}
+	})
+}
+
+func Test_GetUsersWithUnverifiedPrimaryEmail(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Return old users who have not activated primary e-mail", t, func() {
+		oldInactive := &User{Name: "old", LowerName: "old", Email: "old@example.com"}
+		oldActive := &User{Name: "active", LowerName: "active", Email: "active@example.com", IsActive: true}
+		recent := &User{Name: "recent", LowerName: "recent", Email: "recent@example.com"}
+		_, err := x.Insert(oldInactive, oldActive, recent)
+		So(err, ShouldBeNil)
+		_, err = x.Exec("UPDATE `user` SET created_unix=? WHERE id IN (?,?)",
+			time.Now().Add(-48*time.Hour).Unix(), oldInactive.ID, oldActive.ID)
+		So(err, ShouldBeNil)
+
+		users, err := GetUsersWithUnverifiedPrimaryEmail(24 * time.Hour)
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 1)
+		So(users[0].ID, ShouldEqual, oldInactive.ID)
+	})
+}
+
+func Test_RepairLowerNames(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Recompute lower names that do not match names", t, func() {
+		_, err := x.Insert(
+			&User{Name: "Alice", LowerName: "alice", Email: "alice@example.com"},
+			&User{Name: "Bob", LowerName: "", Email: "bob@example.com"},
+			&User{Name: "Carol", LowerName: "karol", Email: "carol@example.com"},
+		)
+		So(err, ShouldBeNil)
+
+		fixed, err := RepairLowerNames()
+		So(err, ShouldBeNil)
+		So(fixed, ShouldEqual, 2)
+
+		for _, name := range []string{"Alice", "Bob", "Carol"} {
+			_, err = GetUserByName(name)
+			So(err, ShouldBeNil)
+		}
+
+		fixed, err = RepairLowerNames()
+		So(err, ShouldBeNil)
+		So(fixed, ShouldEqual, 0)
+	})
+}
+
+func Test_CountByType(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Count users of each type in a single query", t, func() {
+		counts, err := CountByType()
+		So(err, ShouldBeNil)
+		So(counts, ShouldResemble, map[UserType]int64{USER_TYPE_INDIVIDUAL: 0, USER_TYPE_ORGANIZATION: 0})
+
+		_, err = x.Insert(
+			&User{Name: "user1", LowerName: "user1", Email: "user1@example.com"},
+			&User{Name: "user2", LowerName: "user2", Email: "user2@example.com"},
+			&User{Name: "deleted~3", LowerName: "deleted~3", Email: "deleted3@example.com", IsDeleted: true},
+			&User{Name: "org4", LowerName: "org4", Email: "org4@example.com", Type: USER_TYPE_ORGANIZATION},
+		)
+		So(err, ShouldBeNil)
+
+		So(countQueries(func() {
+			counts, err = CountByType()
+		}), ShouldEqual, 1)
+		So(err, ShouldBeNil)
+		So(counts[USER_TYPE_INDIVIDUAL], ShouldEqual, 2)
+		So(counts[USER_TYPE_ORGANIZATION], ShouldEqual, 1)
+		So(counts[USER_TYPE_INDIVIDUAL], ShouldEqual, CountUsers())
+		So(counts[USER_TYPE_ORGANIZATION], ShouldEqual, CountOrganizations())
+	})
+}
+
+func Test_SetAllowGitHook(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Persist permission of editing Git hooks", t, func() {
+		alice := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com"}
+		bob := &User{Name: "bob", LowerName: "bob", Email: "bob@example.com"}
+		admin := &User{Name: "admin", LowerName: "admin", Email: "admin@example.com", IsAdmin: true}
+		_, err := x.Insert(alice, bob, admin)
+		So(err, ShouldBeNil)
+
+		So(SetAllowGitHook(alice, true), ShouldBeNil)
+		So(SetAllowGitHook(bob, true), ShouldBeNil)
+		users, err := GetUsersAllowedGitHooks()
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 2)
+
+		So(SetAllowGitHook(alice, false), ShouldBeNil)
+		u, err := GetUserByID(alice.ID)
+		So(err, ShouldBeNil)
+		So(u.AllowGitHook, ShouldBeFalse)
+
+		users, err = GetUsersAllowedGitHooks()
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 1)
+		So(users[0].ID, ShouldEqual, bob.ID)
+	})
+}
+
+func Test_SearchUserByName_ExcludeIDs(t *testing.T) {
+	setTestEngine(t)
+	setting.UI.ExplorePagingNum = 20
+
+	Convey("Excluded users never appear in search results", t, func() {
+		_, err := x.Insert(
+			&User{Name: "alice", LowerName: "alice", Email: "alice@example.com"},
+			&User{Name: "alina", LowerName: "alina", Email: "alina@example.com"},
+			&User{Name: "alonzo", LowerName: "alonzo", Email: "alonzo@example.com"},
+		)
+		So(err, ShouldBeNil)
+
+		search := func(excludeIDs []int64) ([]int64, int64) {
+			users, count, err := SearchUserByName(&SearchUserOptions{
+				Keyword:    "al",
+				Type:       USER_TYPE_INDIVIDUAL,
+				OrderBy:    "id ASC",
+				ExcludeIDs: excludeIDs,
+			})
+			So(err, ShouldBeNil)
+			ids := make([]int64, len(users))
+			for i := range users {
+				ids[i] = users[i].ID
+			}
+			return ids, count
+		}
+
+		ids, count := search(nil)
+		So(ids, ShouldResemble, []int64{1, 2, 3})
+		So(count, ShouldEqual, 3)
+
+		ids, count = search([]int64{})
+		So(ids, ShouldResemble, []int64{1, 2, 3})
+		So(count, ShouldEqual, 3)
+
+		ids, count = search([]int64{1, 3})
+		So(ids, ShouldResemble, []int64{2})
+		So(count, ShouldEqual, 1)
+	})
+}
+
+func Test_GetUserBriefsByIDs(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Load only brief information of given users", t, func() {
+		_, err := x.Insert(
+			&User{Name: "alice", LowerName: "alice", FullName: "Alice A", Email: "alice@example.com", Avatar: "avatar1"},
+			&User{Name: "bob", LowerName: "bob", FullName: "Bob B", Email: "bob@example.com", Avatar: "avatar2"},
+			&User{Name: "carol", LowerName: "carol", Email: "carol@example.com"},
+		)
+		So(err, ShouldBeNil)
+
+		briefs, err := GetUserBriefsByIDs([]int64{2, 1, 100})
+		So(err, ShouldBeNil)
+		So(briefs, ShouldResemble, []*UserBrief{
+			{ID: 1, Name: "alice", FullName: "Alice A", Avatar: "avatar1"},
+			{ID: 2, Name: "bob", FullName: "Bob B", Avatar: "avatar2"},
+		})
+
+		briefs, err = GetUserBriefsByIDs(nil)
+		So(err, ShouldBeNil)
+		So(briefs, ShouldBeEmpty)
+	})
+}
+
+func Test_CountTotalFollows(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Count all follow relations", t, func() {
+		names := insertTestUsers(t, 3)
+		So(names, ShouldHaveLength, 3)
+
+		count, err := CountTotalFollows()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 0)
+
+		So(FollowUser(1, 2), ShouldBeNil)
+		So(FollowUser(1, 3), ShouldBeNil)
+		So(FollowUser(2, 1), ShouldBeNil)
+		So(FollowUser(2, 1), ShouldBeNil)
+		count, err = CountTotalFollows()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 3)
+
+		So(UnfollowUser(1, 3), ShouldBeNil)
+		count, err = CountTotalFollows()
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+	})
+}
+
+func Test_GetUsersNeverLoggedIn(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Return active users who have never signed in", t, func() {
+		never := &User{Name: "never", LowerName: "never", Email: "never@example.com", IsActive: true}
+		logged := &User{Name: "logged", LowerName: "logged", Email: "logged@example.com", IsActive: true}
+		inactive := &User{Name: "inactive", LowerName: "inactive", Email: "inactive@example.com"}
+		_, err := x.Insert(never, logged, inactive)
+		So(err, ShouldBeNil)
+		So(UpdateUserLastLogin(logged), ShouldBeNil)
+
+		users, err := GetUsersNeverLoggedIn()
+		So(err, ShouldBeNil)
+		So(users, ShouldHaveLength, 1)
+		So(users[0].ID, ShouldEqual, never.ID)
+	})
+}
+
+func Test_DeleteInactivateUsers_SoftDeleted(t *testing.T) {
+	setTestEngine(t)
+	defer setTestDirs(t)()
+
+	Convey("Soft-deleted user is only deleted permanently after retention", t, func() {
+		setting.Service.DeletedUserRetentionDays = 30
+		deleted := &User{Name: "deleted", LowerName: "deleted", Email: "deleted@example.com", IsActive: true}
+		inactive := &User{Name: "inactive", LowerName: "inactive", Email: "inactive@example.com"}
+		_, err := x.Insert(deleted, inactive)
+		So(err, ShouldBeNil)
+		So(MarkUserDeleted(deleted), ShouldBeNil)
+
+		So(DeleteInactivateUsers(), ShouldBeNil)
+		has, err := x.Id(deleted.ID).Get(new(User))
+		So(err, ShouldBeNil)
+		So(has, ShouldBeTrue)
+		has, err = x.Id(inactive.ID).Get(new(User))
+		So(err, ShouldBeNil)
+		So(has, ShouldBeFalse)
+
+		reaped, err := ReapDeletedUsers()
+		So(err, ShouldBeNil)
+		So(reaped, ShouldEqual, 0)
+
+		_, err = x.Exec("UPDATE `user` SET deleted_unix=? WHERE id=?", time.Now().Add(-31*24*time.Hour).Unix(), deleted.ID)
+		So(err, ShouldBeNil)
+		reaped, err = ReapDeletedUsers()
+		So(err, ShouldBeNil)
+		So(reaped, ShouldEqual, 1)
+		has, err = x.Id(deleted.ID).Get(new(User))
+		So(err, ShouldBeNil)
+		So(has, ShouldBeFalse)
+	})
+}
+
+func Test_SoftDeletedUsersHidden(t *testing.T) {
+	setTestEngine(t)
+	setting.UI.ExplorePagingNum = 20
+
+	Convey("Soft-deleted users are left out of reports and lookups", t, func() {
+		_, err := x.Insert(
+			&User{Name: "alice", LowerName: "alice", Email: "alice@example.com", IsActive: true,
+				Passwd: "hash", Salt: "salt", AllowGitHook: true, LoginType: LOGIN_LDAP, LoginSource: 1},
+			&User{Name: "bob", LowerName: "bob", Email: "bob@example.com", IsActive: true,
+				Passwd: "hash", Salt: "salt"},
+			&User{Name: "deleted~3", LowerName: "deleted~3", IsDeleted: true, ProhibitLogin: true,
+				Passwd: "hash", Salt: "salt", AllowGitHook: true, LoginType: LOGIN_LDAP, LoginSource: 1},
+		)
+		So(err, ShouldBeNil)
+		// Row is left impersonatable on purpose to make sure it is filtered by is_deleted.
+		_, err = x.Exec("UPDATE `user` SET prohibit_login=? WHERE id=3", false)
+		So(err, ShouldBeNil)
+
+		ids := func(users []*User, err error) []int64 {
+			So(err, ShouldBeNil)
+			ids := make([]int64, len(users))
+			for i := range users {
+				ids[i] = users[i].ID
+			}
+			return ids
+		}
+
+		So(ids(GetUsersAllowedGitHooks()), ShouldResemble, []int64{1})
+		So(ids(GetUsersByLoginType(LOGIN_LDAP)), ShouldResemble, []int64{1})
+		So(ids(GetUsersByLoginSource(1)), ShouldResemble, []int64{1})
+		So(ids(FindSuspectedBotAccounts(BotHeuristics{CreatedWithin: time.Hour})), ShouldResemble, []int64{1, 2})
+		So(ids(GetImpersonatableUsers(&SearchUserOptions{OrderBy: "id ASC"})), ShouldResemble, []int64{1, 2})
+		So(GetUserIDsByNames([]string{"alice", "deleted~3"}), ShouldResemble, []int64{1})
+
+		groups, err := FindUsersWithSharedPasswordHash()
+		So(err, ShouldBeNil)
+		So(groups, ShouldHaveLength, 1)
+		So(ids(groups[0].Users, nil), ShouldResemble, []int64{1, 2})
+
+		distribution, err := GetUserRepoCountDistribution()
+		So(err, ShouldBeNil)
+		So(distribution[RepoCountTiers[0]], ShouldEqual, 2)
+	})
+}
+
+func Test_DeleteUserAccount(t *testing.T) {
+	setTestEngine(t)
+	defer setTestDirs(t)()
+
+	Convey("Soft-delete user only when it is enabled", t, func() {
+		alice := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com", IsActive: true}
+		bob := &User{Name: "bob", LowerName: "bob", Email: "bob@example.com", IsActive: true}
+		_, err := x.Insert(alice, bob)
+		So(err, ShouldBeNil)
+
+		setting.Service.SoftDeleteUsers = false
+		So(DeleteUserAccount(alice), ShouldBeNil)
+		has, err := x.Id(alice.ID).Get(new(User))
+		So(err, ShouldBeNil)
+		So(has, ShouldBeFalse)
+
+		setting.Service.SoftDeleteUsers = true
+		defer func() {
+			setting.Service.SoftDeleteUsers = false
+		}()
+		So(DeleteUserAccount(bob), ShouldBeNil)
+		u := new(User)
+		has, err = x.Id(bob.ID).Get(u)
+		So(err, ShouldBeNil)
+		So(has, ShouldBeTrue)
+		So(u.IsDeleted, ShouldBeTrue)
+	})
+}
+
+func Test_MarkUserDeleted(t *testing.T) {
+	setTestEngine(t)
+	defer setTestDirs(t)()
+
+	Convey("Soft-deleted user frees its name and loses credentials", t, func() {
+		setting.Service.DeletedUserNameCooldown = 60
+		setting.Service.DeletedUserRetentionDays = 30
+		defer func() {
+			setting.Service.DeletedUserNameCooldown = 0
+		}()
+
+		u := &User{Name: "Alice", LowerName: "alice", Email: "alice@example.com", IsActive: true, Rands: "rands"}
+		_, err := x.Insert(u)
+		So(err, ShouldBeNil)
+		_, err = x.Insert(
+			&AccessToken{UID: u.ID, Name: "token", Sha1: "sha1"},
+			&PublicKey{OwnerID: u.ID, Name: "key", Fingerprint: "fingerprint", Content: "content"},
+			&EmailAddress{UID: u.ID, Email: "alice2@example.com", IsActivated: true},
+		)
+		So(err, ShouldBeNil)
+
+		So(MarkUserDeleted(u), ShouldBeNil)
+		So(u.Name, ShouldEqual, deletedUserName(u.ID))
+
+		taken, err := IsNameTaken("alice")
+		So(err, ShouldBeNil)
+		So(taken, ShouldBeTrue)
+		has, err := x.Get(&DeletedUserName{LowerName: "alice"})
+		So(err, ShouldBeNil)
+		So(has, ShouldBeTrue)
+		_, err = GetUserByName("Alice")
+		So(IsErrUserNotExist(err), ShouldBeTrue)
+		setting.Service.DeletedUserNameCooldown = 0
+		taken, err = IsNameTaken("alice")
+		So(err, ShouldBeNil)
+		So(taken, ShouldBeFalse)
+
+		for _, bean := range []interface{}{
+			&AccessToken{UID: u.ID},
+			&PublicKey{OwnerID: u.ID},
+			&EmailAddress{UID: u.ID},
+		} {
+			count, err := x.Count(bean)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 0)
+		}
+
+		deleted := new(User)
+		has, err = x.Id(u.ID).Get(deleted)
+		So(err, ShouldBeNil)
+		So(has, ShouldBeTrue)
+		So(deleted.IsDeleted, ShouldBeTrue)
+		So(deleted.ProhibitLogin, ShouldBeTrue)
+		So(deleted.Email, ShouldBeEmpty)
+		So(deleted.Rands, ShouldNotEqual, "rands")
+	})
+}
+
+func Test_ReapDeletedUsers(t *testing.T) {
+	setTestEngine(t)
+	defer setTestDirs(t)()
+
+	Convey("Reap soft-deleted users only after retention period", t, func() {
+		setting.Service.DeletedUserRetentionDays = 30
+		old := &User{Name: "old", LowerName: "old", Email: "old@example.com", IsActive: true}
+		recent := &User{Name: "recent", LowerName: "recent", Email: "recent@example.com", IsActive: true}
+		alive := &User{Name: "alive", LowerName: "alive", Email: "alive@example.com", IsActive: true}
+		_, err := x.Insert(old, recent, alive)
+		So(err, ShouldBeNil)
+		So(MarkUserDeleted(old), ShouldBeNil)
+		So(MarkUserDeleted(recent), ShouldBeNil)
+		_, err = x.Exec("UPDATE `user` SET deleted_unix=? WHERE id=?", time.Now().Add(-31*24*time.Hour).Unix(), old.ID)
+		So(err, ShouldBeNil)
+
+		reaped, err := ReapDeletedUsers()
+		So(err, ShouldBeNil)
+		So(reaped, ShouldEqual, 1)
+
+		for id, expected := range map[int64]bool{old.ID: false, recent.ID: true, alive.ID: true} {
+			has, err := x.Id(id).Get(new(User))
+			So(err, ShouldBeNil)
+			So(has, ShouldEqual, expected)
+		}
+
+		// Placeholder name of soft-deleted user is not recorded as deleted name.
+		count, err := x.Count(new(DeletedUserName))
+		So(err, ShouldBeNil)
+		So(count, ShouldEqual, 2)
+		has, err := x.Get(&DeletedUserName{LowerName: deletedUserName(old.ID)})
+		So(err, ShouldBeNil)
+		So(has, ShouldBeFalse)
+	})
+}
+
+func Test_StorageQuotaEnforcement(t *testing.T) {
+	setTestEngine(t)
+	defer setTestDirs(t)()
+
+	Convey("Repository creation is blocked once cached usage exceeds quota", t, func() {
+		setting.Repository.MaxStorage = 1000
+		defer func() {
+			setting.Repository.MaxStorage = 0
+		}()
+
+		u := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com", MaxRepoCreation: -1}
+		_, err := x.Insert(u)
+		So(err, ShouldBeNil)
+		os.MkdirAll(UserPath(u.Name), os.ModePerm)
+		So(ioutil.WriteFile(UserPath(u.Name)+"/blob", make([]byte, 1500), 0644), ShouldBeNil)
+
+		So(UpdateUserStorageUsage(u.ID), ShouldBeNil)
+		u, err = GetUserByID(u.ID)
+		So(err, ShouldBeNil)
+		So(u.UsedStorage, ShouldEqual, 1500)
+
+		_, err = CreateRepository(u, CreateRepoOptions{Name: "repo"})
+		So(IsErrUserStorageQuotaExceeded(err), ShouldBeTrue)
+		_, err = ForkRepository(u, &Repository{ID: 1}, "fork", "")
+		So(IsErrUserStorageQuotaExceeded(err), ShouldBeTrue)
+		ok, err := HasStorageQuotaRemaining(u.ID, 0)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeFalse)
+
+		_, err = x.Id(u.ID).Cols("max_storage").Update(&User{MaxStorage: 2000})
+		So(err, ShouldBeNil)
+		ok, err = HasStorageQuotaRemaining(u.ID, 0)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+	})
+}
+
+func Test_AdminResetPassword_SQLite(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Reset password on behalf of site admin", t, func() {
+		u := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com", Passwd: "old password", Rands: "rands", NumFailedLogins: 3}
+		So(u.EncodePasswd(), ShouldBeNil)
+		_, err := x.Insert(u)
+		So(err, ShouldBeNil)
+
+		So(IsErrPasswordTooWeak(AdminResetPassword(u.ID, "abc")), ShouldBeTrue)
+
+		So(AdminResetPassword(u.ID, "new password"), ShouldBeNil)
+		u2, err := GetUserByID(u.ID)
+		So(err, ShouldBeNil)
+		So(u2.ValidatePassword("new password"), ShouldBeTrue)
+		So(u2.ValidatePassword("old password"), ShouldBeFalse)
+		So(u2.MustChangePassword, ShouldBeTrue)
+		So(u2.Rands, ShouldNotEqual, "rands")
+		So(u2.NumFailedLogins, ShouldEqual, 0)
+		So(u2.IsValidSessionEpoch(u.SessionEpoch), ShouldBeFalse)
+		So(u2.IsValidSessionEpoch(u.SessionEpoch+1), ShouldBeTrue)
+	})
+}
+
+func Test_RotateAllUserRands(t *testing.T) {
+	setTestEngine(t)
+
+	Convey("Rotate rands and session epoch of every user", t, func() {
+		insertTestUsers(t, 150)
+
+		rotated, err := RotateAllUserRands()
+		So(err, ShouldBeNil)
+		So(rotated, ShouldEqual, 150)
+
+		users := make([]*User, 0, 150)
+		So(x.Find(&users), ShouldBeNil)
+		So(users, ShouldHaveLength, 150)
+		seen := make(map[string]bool, len(users))
+		for _, u := range users {
+			So(u.Rands, ShouldNotBeEmpty)
+			So(seen[u.Rands], ShouldBeFalse)
+			seen[u.Rands] = true
+			So(u.SessionEpoch, ShouldEqual, 1)
+		}
+	})
+}
+
+func Test_MergeUsers(t *testing.T) {
+	setTestEngine(t)
+	defer setTestDirs(t)()
+
+	Convey("Merge repositories, keys and e-mail into kept user", t, func() {
+		keep := &User{Name: "alice", LowerName: "alice", Email: "alice@example.com", IsActive: true}
+		merge := &User{Name: "bob", LowerName: "bob", Email: "bob@example.com", IsActive: true, NumRepos: 1}
+		_, err := x.Insert(keep, merge)
+		So(err, ShouldBeNil)
+		repo := &Repository{OwnerID: merge.ID, Name: "project", LowerName: "project"}
+		_, err = x.Insert(repo, &PublicKey{OwnerID: merge.ID, Name: "key", Fingerprint: "fingerprint"})
+		So(err, ShouldBeNil)
+		So(os.MkdirAll(RepoPath("bob", "project"), os.ModePerm), ShouldBeNil)
+
+		So(MergeUsers(keep.ID, merge.ID), ShouldBeNil)
+
+		_, err = GetUserByID(merge.ID)
+		So(IsErrUserNotExist(err), ShouldBeTrue)
+		_, err = os.Stat(UserPath("bob"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		_, err = os.Stat(RepoPath("alice", "project"))
+		So(err, ShouldBeNil)
+
+		u, err := GetUserByID(keep.ID)
+		So(err, ShouldBeNil)
+		So(u.NumRepos, ShouldEqual, 1)
+		r, err := GetRepositoryByID(repo.ID)
+		So(err, ShouldBeNil)
+		So(r.OwnerID, ShouldEqual, keep.ID)
+
+		key := new(PublicKey)
+		has, err := x.Where("name=?", "key").Get(key)
+		So(err, ShouldBeNil)
+		So(has, ShouldBeTrue)
+		So(key.OwnerID, ShouldEqual, keep.ID)
+		email := &EmailAddress{Email: "bob@example.com"}
+		has, err = x.Get(email)
+		So(err, ShouldBeNil)
+		So(has, ShouldBeTrue)
+		So(email.UID, ShouldEqual, keep.ID)
+	})
+
+	Convey("Conflicting repository name leaves both users untouched", t, func() {
+		keep := &User{Name: "carol", LowerName: "carol", Email: "carol@example.com", IsActive: true, NumRepos: 1}
+		merge := &User{Name: "dave", LowerName: "dave", Email: "dave@example.com", IsActive: true, NumRepos: 1}
+		_, err := x.Insert(keep, merge)
+		So(err, ShouldBeNil)
+		repo := &Repository{OwnerID: merge.ID, Name: "shared", LowerName: "shared"}
+		_, err = x.Insert(&Repository{OwnerID: keep.ID, Name: "shared", LowerName: "shared"}, repo)
+		So(err, ShouldBeNil)
+		So(os.MkdirAll(RepoPath("carol", "shared"), os.ModePerm), ShouldBeNil)
+		So(os.MkdirAll(RepoPath("dave", "shared"), os.ModePerm), ShouldBeNil)
+
+		So(IsErrRepoAlreadyExist(MergeUsers(keep.ID, merge.ID)), ShouldBeTrue)
+
+		_, err = GetUserByID(merge.ID)
+		So(err, ShouldBeNil)
+		_, err = os.Stat(RepoPath("dave", "shared"))
+		So(err, ShouldBeNil)
+		r, err := GetRepositoryByID(repo.ID)
+		So(err, ShouldBeNil)
+		So(r.OwnerID, ShouldEqual, merge.ID)
+	})
+}
@@ -0,0 +1,1002 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package models
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/gogits/git-module"
+
+	"github.com/gogits/gogs/modules/setting"
+)
+
+func Test_UserEmailPrivacy(t *testing.T) {
+	Convey("Hide e-mail in Git and on web independently", t, func() {
+		setting.Service.NoReplyAddress = "noreply.example.com"
+		u := &User{
+			LowerName: "alice",
+			Email:     "alice@example.com",
+		}
+
+		So(u.GitEmail(), ShouldEqual, "alice@example.com")
+		So(u.WebEmail(), ShouldEqual, "alice@example.com")
+
+		u.HideEmail = true
+		So(u.GitEmail(), ShouldEqual, "alice@noreply.example.com")
+		So(u.WebEmail(), ShouldEqual, "alice@example.com")
+
+		u.HideEmail = false
+		u.HideEmailOnWeb = true
+		So(u.GitEmail(), ShouldEqual, "alice@example.com")
+		So(u.WebEmail(), ShouldBeEmpty)
+	})
+}
+
+func Test_UploadAvatarFromURL(t *testing.T) {
+	Convey("Refuse to fetch avatar from private network", t, func() {
+		for _, url := range []string{
+			"http://127.0.0.1/avatar.png",
+			"http://localhost/avatar.png",
+			"http://10.0.0.1/avatar.png",
+			"http://192.168.1.1/avatar.png",
+			"http://[::1]/avatar.png",
+		} {
+			err := UploadAvatarFromURL(&User{ID: 1}, url)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "private network")
+		}
+	})
+}
+
+func Test_ValidateUserName(t *testing.T) {
+	Convey("Validate legality and usability of user name", t, func() {
+		So(ValidateUserName("alice.bob-1_2"), ShouldBeNil)
+		So(ValidateUserName(""), ShouldEqual, ErrNameEmpty)
+		So(ValidateUserName("alice bob"), ShouldEqual, ErrUserNameIllegal)
+		So(ValidateUserName("alice/bob"), ShouldEqual, ErrUserNameIllegal)
+		So(ValidateUserName("alice."), ShouldEqual, ErrUserNameIllegal)
+		So(IsErrNameReserved(ValidateUserName("admin")), ShouldBeTrue)
+		So(IsErrNamePatternNotAllowed(ValidateUserName("alice.keys")), ShouldBeTrue)
+	})
+}
+
+func Test_ValidateUserProfile(t *testing.T) {
+	Convey("Validate length of profile fields", t, func() {
+		u := &User{
+			Location: "Earth",
+			Website:  "https://example.com/" + strings.Repeat("a", 255),
+		}
+		err := ValidateUserProfile(u)
+		So(IsErrUserProfileTooLong(err), ShouldBeTrue)
+		So(err.(ErrUserProfileTooLong).Field, ShouldEqual, "website")
+
+		u.Website = "https://example.com"
+		So(ValidateUserProfile(u), ShouldBeNil)
+	})
+}
+
+func Test_escapeLike(t *testing.T) {
+	Convey("Escape wildcard characters for LIKE", t, func() {
+		So(escapeLike("alice"), ShouldEqual, "alice")
+		So(escapeLike("a_l%ice!"), ShouldEqual, "a!_l!%ice!!")
+	})
+}
+
+func Test_DefaultAvatarLink(t *testing.T) {
+	Convey("Use configured default avatar when it is set", t, func() {
+		defer func() {
+			setting.DefaultAvatar = ""
+		}()
+
+		setting.DefaultAvatar = ""
+		So(DefaultAvatarLink(), ShouldEqual, _BUILTIN_DEFAULT_AVATAR)
+
+		setting.DefaultAvatar = "/img/brand_avatar.png"
+		So(DefaultAvatarLink(), ShouldEqual, "/img/brand_avatar.png")
+	})
+}
+
+func Test_noReplyUserName(t *testing.T) {
+	Convey("Extract user name from no-reply address", t, func() {
+		setting.Service.NoReplyAddress = "noreply.example.com"
+
+		name, ok := noReplyUserName("alice@noreply.example.com")
+		So(ok, ShouldBeTrue)
+		So(name, ShouldEqual, "alice")
+
+		_, ok = noReplyUserName("alice@example.com")
+		So(ok, ShouldBeFalse)
+		_, ok = noReplyUserName("@noreply.example.com")
+		So(ok, ShouldBeFalse)
+	})
+}
+
+func Test_SetAvatarBytes(t *testing.T) {
+	Convey("Reject avatar image in wrong size or format", t, func() {
+		buf := new(bytes.Buffer)
+		So(png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 100, 100))), ShouldBeNil)
+
+		err := SetAvatarBytes(&User{ID: 1}, buf.Bytes(), "png")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "image size")
+
+		err = SetAvatarBytes(&User{ID: 1}, buf.Bytes(), "jpeg")
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "format mismatch")
+	})
+}
+
+func Test_AvatarCrop(t *testing.T) {
+	Convey("Crop largest square in the center", t, func() {
+		So(centerSquare(image.Rect(0, 0, 300, 200)), ShouldResemble, image.Rect(50, 0, 250, 200))
+		So(centerSquare(image.Rect(0, 0, 200, 300)), ShouldResemble, image.Rect(0, 50, 200, 250))
+	})
+
+	Convey("Honor and clamp client-provided crop area", t, func() {
+		bounds := image.Rect(0, 0, 300, 200)
+		So(clampCrop(image.Rect(10, 20, 110, 120), bounds), ShouldResemble, image.Rect(10, 20, 110, 120))
+		So(clampCrop(image.Rect(250, 150, 400, 300), bounds), ShouldResemble, image.Rect(250, 150, 300, 200))
+		So(clampCrop(image.Rect(500, 500, 600, 600), bounds), ShouldResemble, image.Rect(50, 0, 250, 200))
+
+		img := cropImage(image.NewRGBA(bounds), image.Rect(10, 20, 110, 120))
+		So(img.Bounds(), ShouldResemble, image.Rect(10, 20, 110, 120))
+	})
+}
+
+func Test_dirSize(t *testing.T) {
+	Convey("Sum sizes of all files under path", t, func() {
+		root, _ := ioutil.TempDir("", "gogs-user")
+		defer os.RemoveAll(root)
+
+		size, err := dirSize(filepath.Join(root, "nonexistent"))
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, 0)
+
+		os.MkdirAll(filepath.Join(root, "repo.git", "objects"), os.ModePerm)
+		ioutil.WriteFile(filepath.Join(root, "repo.git", "HEAD"), make([]byte, 23), 0644)
+		ioutil.WriteFile(filepath.Join(root, "repo.git", "objects", "pack"), make([]byte, 1000), 0644)
+		size, err = dirSize(root)
+		So(err, ShouldBeNil)
+		So(size, ShouldEqual, 1023)
+	})
+}
+
+func Test_StorageQuota(t *testing.T) {
+	Convey("Check remaining storage quota", t, func() {
+		setting.Repository.MaxStorage = 1000
+		defer func() {
+			setting.Repository.MaxStorage = 0
+		}()
+
+		u := &User{UsedStorage: 900}
+		So(u.HasStorageQuotaRemaining(100), ShouldBeTrue)
+		So(u.HasStorageQuotaRemaining(101), ShouldBeFalse)
+
+		u.MaxStorage = 2000
+		So(u.HasStorageQuotaRemaining(101), ShouldBeTrue)
+
+		u.MaxStorage = 0
+		setting.Repository.MaxStorage = 0
+		So(u.HasStorageQuotaRemaining(1<<40), ShouldBeTrue)
+	})
+}
+
+func Test_CustomAvatarDataURL(t *testing.T) {
+	Convey("Embed custom avatar as data URL", t, func() {
+		uploadPath := setting.AvatarUploadPath
+		defer func() {
+			setting.AvatarUploadPath = uploadPath
+		}()
+		setting.AvatarUploadPath, _ = ioutil.TempDir("", "gogs-avatars")
+		defer os.RemoveAll(setting.AvatarUploadPath)
+
+		u := &User{ID: 1}
+		dataURL, err := u.CustomAvatarDataURL()
+		So(err, ShouldBeNil)
+		So(dataURL, ShouldBeEmpty)
+
+		buf := new(bytes.Buffer)
+		So(png.Encode(buf, image.NewRGBA(image.Rect(0, 0, 1, 1))), ShouldBeNil)
+		So(ioutil.WriteFile(u.CustomAvatarPath(), buf.Bytes(), 0644), ShouldBeNil)
+
+		u.UseCustomAvatar = true
+		dataURL, err = u.CustomAvatarDataURL()
+		So(err, ShouldBeNil)
+		So(dataURL, ShouldStartWith, "data:image/png;base64,")
+	})
+}
+
+func Test_SetUserTheme(t *testing.T) {
+	Convey("Reject unknown theme", t, func() {
+		So(isValidTheme(""), ShouldBeTrue)
+		So(isValidTheme("gogs"), ShouldBeTrue)
+		So(isValidTheme("dark"), ShouldBeFalse)
+
+		u := &User{ID: 1}
+		So(IsErrThemeNotExist(SetUserTheme(u, "nonexistent")), ShouldBeTrue)
+		So(u.Theme, ShouldBeEmpty)
+	})
+}
+
+func Test_IsAnimatedGIF(t *testing.T) {
+	Convey("Detect animated GIF", t, func() {
+		frame := image.NewPaletted(image.Rect(0, 0, 2, 2), color.Palette{color.Black, color.White})
+		buf := new(bytes.Buffer)
+		So(gif.EncodeAll(buf, &gif.GIF{
+			Image: []*image.Paletted{frame, frame},
+			Delay: []int{10, 10},
+		}), ShouldBeNil)
+		So(IsAnimatedGIF(buf.Bytes()), ShouldBeTrue)
+
+		buf.Reset()
+		So(gif.Encode(buf, frame, nil), ShouldBeNil)
+		So(IsAnimatedGIF(buf.Bytes()), ShouldBeFalse)
+
+		buf.Reset()
+		So(png.Encode(buf, frame), ShouldBeNil)
+		So(IsAnimatedGIF(buf.Bytes()), ShouldBeFalse)
+	})
+}
+
+func Test_avatarHash(t *testing.T) {
+	Convey("Compute avatar hash from normalized avatar e-mail", t, func() {
+		u := &User{Email: "alice@example.com"}
+		So(u.avatarHash(), ShouldEqual, "c160f8cc69a4f0bf2b0362752353d060")
+
+		u.AvatarEmail = " Alice@Example.com "
+		So(u.avatarHash(), ShouldEqual, "c160f8cc69a4f0bf2b0362752353d060")
+	})
+}
+
+func Test_CanViewEmail(t *testing.T) {
+	Convey("Check if viewer can see e-mail of target user", t, func() {
+		target := &User{ID: 1}
+		other := &User{ID: 2}
+		admin := &User{ID: 3, IsAdmin: true}
+
+		So(CanViewEmail(nil, target), ShouldBeTrue)
+		So(CanViewEmail(other, target), ShouldBeTrue)
+
+		target.HideEmailOnWeb = true
+		So(CanViewEmail(nil, target), ShouldBeFalse)
+		So(CanViewEmail(other, target), ShouldBeFalse)
+		So(CanViewEmail(target, target), ShouldBeTrue)
+		So(CanViewEmail(admin, target), ShouldBeTrue)
+	})
+}
+
+func Test_topCommitAuthors(t *testing.T) {
+	Convey("Count commits per author and order by count descending", t, func() {
+		alice := &User{ID: 1, Name: "alice"}
+		bob := &User{ID: 2, Name: "bob"}
+		getUserByEmail := func(email string) (*User, error) {
+			switch email {
+			case "alice@example.com", "alice@work.com":
+				return alice, nil
+			case "bob@example.com":
+				return bob, nil
+			}
+			return nil, ErrUserNotExist{0, email}
+		}
+
+		counts, err := topCommitAuthors([]string{
+			"bob@example.com",
+			"alice@example.com",
+			"unknown@example.com",
+			"Alice@Example.com",
+			"alice@work.com",
+			"bob@example.com",
+		}, 10, getUserByEmail)
+		So(err, ShouldBeNil)
+		So(counts, ShouldHaveLength, 3)
+		So(counts[0].User, ShouldEqual, alice)
+		So(counts[0].Count, ShouldEqual, 3)
+		So(counts[1].User, ShouldEqual, bob)
+		So(counts[1].Count, ShouldEqual, 2)
+		So(counts[2].User.ID, ShouldEqual, -1)
+		So(counts[2].Count, ShouldEqual, 1)
+
+		counts, err = topCommitAuthors([]string{"bob@example.com", "alice@example.com"}, 1, getUserByEmail)
+		So(err, ShouldBeNil)
+		So(counts, ShouldHaveLength, 1)
+	})
+}
+
+func Test_writeAvatarFile(t *testing.T) {
+	Convey("Write avatar file atomically", t, func() {
+		dir, _ := ioutil.TempDir("", "gogs-avatars")
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "1")
+
+		err := writeAvatarFile(path, func(w io.Writer) error {
+			w.Write([]byte("partial"))
+			return errors.New("encode failed")
+		})
+		So(err, ShouldNotBeNil)
+		files, _ := ioutil.ReadDir(dir)
+		So(files, ShouldBeEmpty)
+
+		So(writeAvatarFile(path, func(w io.Writer) error {
+			return png.Encode(w, image.NewRGBA(image.Rect(0, 0, 2, 2)))
+		}), ShouldBeNil)
+		files, _ = ioutil.ReadDir(dir)
+		So(files, ShouldHaveLength, 1)
+
+		data, err := ioutil.ReadFile(path)
+		So(err, ShouldBeNil)
+		_, err = png.Decode(bytes.NewReader(data))
+		So(err, ShouldBeNil)
+	})
+}
+
+func Test_DeletedUserNameCooldown(t *testing.T) {
+	Convey("Name of deleted user is unavailable during cooldown", t, func() {
+		now := time.Now()
+		n := &DeletedUserName{LowerName: "alice", DeletedUnix: now.Add(-time.Hour).Unix()}
+
+		So(n.inCooldown(now, 2*time.Hour), ShouldBeTrue)
+		So(n.inCooldown(now, 30*time.Minute), ShouldBeFalse)
+		So(n.inCooldown(now, 0), ShouldBeFalse)
+
+		setting.Service.DeletedUserNameCooldown = 0
+		isDeleted, err := IsNameRecentlyDeleted("alice")
+		So(err, ShouldBeNil)
+		So(isDeleted, ShouldBeFalse)
+	})
+}
+
+func Test_parseCoAuthorEmails(t *testing.T) {
+	Convey("Parse co-author trailers of commit message", t, func() {
+		So(parseCoAuthorEmails("Fix typo"), ShouldBeEmpty)
+		So(parseCoAuthorEmails(`Add feature
+
+Co-authored-by: Alice <alice@example.com>
+co-authored-by: Bob Smith <bob@example.com>
+Signed-off-by: Carol <carol@example.com>
+Co-authored-by: broken trailer`), ShouldResemble, []string{"alice@example.com", "bob@example.com"})
+	})
+}
+
+func Test_EffectiveSessionLifetime(t *testing.T) {
+	Convey("Per-user session lifetime takes precedence", t, func() {
+		setting.SessionConfig.Maxlifetime = 86400
+
+		u := &User{}
+		So(EffectiveSessionLifetime(u), ShouldEqual, 24*time.Hour)
+
+		u.SessionMaxLifetime = 3600
+		So(EffectiveSessionLifetime(u), ShouldEqual, time.Hour)
+	})
+}
+
+func Test_CanUserSeeUser(t *testing.T) {
+	Convey("Restricted user is hidden from strangers", t, func() {
+		target := &User{ID: 1}
+		So(CanUserSeeUser(nil, target), ShouldBeTrue)
+
+		target.IsRestricted = true
+		So(CanUserSeeUser(nil, target), ShouldBeFalse)
+		So(CanUserSeeUser(target, target), ShouldBeTrue)
+		So(CanUserSeeUser(&User{ID: 2, IsAdmin: true}, target), ShouldBeTrue)
+	})
+}
+
+func Test_GenerateInitialsAvatar(t *testing.T) {
+	Convey("Initials reflect display name", t, func() {
+		So(nameInitials("John Ronald Tolkien"), ShouldEqual, "JT")
+		So(nameInitials("unknwon"), ShouldEqual, "U")
+		So(nameInitials(" "), ShouldEqual, "")
+		So(nameInitials((&User{Name: "gogs", FullName: "Go Git Service"}).DisplayName()), ShouldEqual, "GS")
+	})
+
+	Convey("Same user yields same image", t, func() {
+		u := &User{ID: 7, Name: "gogs"}
+		data1, err := GenerateInitialsAvatar(u)
+		So(err, ShouldBeNil)
+		data2, err := GenerateInitialsAvatar(u)
+		So(err, ShouldBeNil)
+		So(bytes.Equal(data1, data2), ShouldBeTrue)
+
+		_, err = png.Decode(bytes.NewReader(data1))
+		So(err, ShouldBeNil)
+
+		u.FullName = "Other Name"
+		data3, err := GenerateInitialsAvatar(u)
+		So(err, ShouldBeNil)
+		So(bytes.Equal(data1, data3), ShouldBeFalse)
+	})
+}
+
+func Test_DeletionImpact(t *testing.T) {
+	Convey("Owned repositories and organizations block deletion", t, func() {
+		So((&DeletionImpact{NumFollowers: 3, NumIssues: 2, NumKeys: 1}).CanDelete(), ShouldBeTrue)
+		So((&DeletionImpact{NumRepos: 1}).CanDelete(), ShouldBeFalse)
+		So((&DeletionImpact{NumOrgs: 1}).CanDelete(), ShouldBeFalse)
+	})
+}
+
+func Test_AdminResetPassword(t *testing.T) {
+	Convey("Weak password is rejected", t, func() {
+		So(IsErrPasswordTooWeak(validatePasswordStrength("abc")), ShouldBeTrue)
+		So(IsErrPasswordTooWeak(validatePasswordStrength("      ")), ShouldBeTrue)
+		So(validatePasswordStrength("correct horse"), ShouldBeNil)
+	})
+
+	Convey("Reset password validates and invalidates sessions", t, func() {
+		setting.BcryptCost = bcrypt.MinCost
+		u := &User{Passwd: "old", Rands: "rands"}
+		So(u.resetPassword("correct horse"), ShouldBeNil)
+		So(u.ValidatePassword("correct horse"), ShouldBeTrue)
+		So(u.ValidatePassword("old"), ShouldBeFalse)
+		So(u.Rands, ShouldNotEqual, "rands")
+		So(u.MustChangePassword, ShouldBeTrue)
+	})
+}
+
+func Test_usersWithMissingDir(t *testing.T) {
+	Convey("Report users whose directory was removed", t, func() {
+		root, _ := ioutil.TempDir("", "gogs-repos")
+		defer os.RemoveAll(root)
+		oldRoot := setting.RepoRootPath
+		setting.RepoRootPath = root
+		defer func() {
+			setting.RepoRootPath = oldRoot
+		}()
+
+		os.MkdirAll(filepath.Join(root, "alice"), os.ModePerm)
+		os.MkdirAll(filepath.Join(root, "bob"), os.ModePerm)
+		alice, bob := &User{ID: 1, Name: "Alice"}, &User{ID: 2, Name: "bob"}
+		So(usersWithMissingDir([]*User{alice, bob}), ShouldBeEmpty)
+
+		os.RemoveAll(filepath.Join(root, "bob"))
+		So(usersWithMissingDir([]*User{alice, bob}), ShouldResemble, []*User{bob})
+	})
+}
+
+func Test_attributeCommits(t *testing.T) {
+	Convey("Attribute commits to users with verification status", t, func() {
+		alice := &User{ID: 1, Name: "alice"}
+		bob := &User{ID: 2, Name: "bob"}
+		resolved := map[string]emailVerification{
+			"alice@example.com": {alice, true},
+			"bob@example.com":   {bob, false},
+		}
+
+		commits := list.New()
+		for _, email := range []string{"Alice@Example.com", "bob@example.com", "unknown@example.com"} {
+			commits.PushBack(&git.Commit{Author: &git.Signature{Email: email}})
+		}
+
+		results := attributeCommits(commits, resolved)
+		So(results.Len(), ShouldEqual, 3)
+		c := results.Front().Value.(VerifiedUserCommit)
+		So(c.User, ShouldEqual, alice)
+		So(c.Verified, ShouldBeTrue)
+		c = results.Front().Next().Value.(VerifiedUserCommit)
+		So(c.User, ShouldEqual, bob)
+		So(c.Verified, ShouldBeFalse)
+		c = results.Back().Value.(VerifiedUserCommit)
+		So(c.User, ShouldBeNil)
+		So(c.Verified, ShouldBeFalse)
+	})
+}
+
+func Test_FollowCreated(t *testing.T) {
+	Convey("Creation time of follow is recorded", t, func() {
+		f := new(Follow)
+		f.BeforeInsert()
+		So(f.CreatedUnix, ShouldBeGreaterThan, time.Now().Add(-time.Minute).Unix())
+
+		f.AfterSet("created_unix", nil)
+		So(f.Created.Unix(), ShouldEqual, f.CreatedUnix)
+	})
+}
+
+func Test_NormalizeWebsiteURL(t *testing.T) {
+	Convey("Normalize website URL", t, func() {
+		for raw, expected := range map[string]string{
+			"":                      "",
+			"example.com":           "https://example.com",
+			" example.com/blog ":    "https://example.com/blog",
+			"localhost:3000":        "https://localhost:3000",
+			"http://example.com":    "http://example.com",
+			"https://example.com/a": "https://example.com/a",
+		} {
+			website, err := NormalizeWebsiteURL(raw)
+			So(err, ShouldBeNil)
+			So(website, ShouldEqual, expected)
+		}
+
+		for _, raw := range []string{"javascript:alert(1)", "mailto:gogs@example.com", "ftp://example.com", "https://"} {
+			_, err := NormalizeWebsiteURL(raw)
+			So(IsErrInvalidWebsiteURL(err), ShouldBeTrue)
+		}
+	})
+}
+
+func Test_avatarWorkingPool(t *testing.T) {
+	Convey("Avatar changes of the same user are serialized", t, func() {
+		var (
+			lock      sync.Mutex
+			active    int
+			maxActive int
+			wg        sync.WaitGroup
+		)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				avatarWorkingPool.CheckIn("1")
+				defer avatarWorkingPool.CheckOut("1")
+
+				lock.Lock()
+				active++
+				if active > maxActive {
+					maxActive = active
+				}
+				lock.Unlock()
+
+				time.Sleep(time.Millisecond)
+
+				lock.Lock()
+				active--
+				lock.Unlock()
+			}()
+		}
+		wg.Wait()
+		So(maxActive, ShouldEqual, 1)
+		So(avatarWorkingPool.pool, ShouldBeEmpty)
+	})
+}
+
+func Test_PublicProfile(t *testing.T) {
+	Convey("Public profile excludes private fields", t, func() {
+		setting.DisableGravatar = false
+		setting.OfflineMode = false
+		setting.GravatarSource = "https://secure.gravatar.com/avatar/"
+
+		u := &User{
+			ID:             1,
+			Name:           "alice",
+			Email:          "alice@example.com",
+			Passwd:         "passwd-hash",
+			Salt:           "salt-value",
+			Rands:          "rands-value",
+			LoginName:      "login-name",
+			HideEmailOnWeb: true,
+		}
+		data, err := json.Marshal(u.PublicProfile(nil))
+		So(err, ShouldBeNil)
+		for _, secret := range []string{"passwd-hash", "salt-value", "rands-value", "login-name", "alice@example.com"} {
+			So(string(data), ShouldNotContainSubstring, secret)
+		}
+
+		So(u.PublicProfile(&User{ID: 2}).Email, ShouldBeEmpty)
+		So(u.PublicProfile(u).Email, ShouldEqual, "alice@example.com")
+		So(u.PublicProfile(&User{ID: 2, IsAdmin: true}).Email, ShouldEqual, "alice@example.com")
+
+		u.HideEmailOnWeb = false
+		So(u.PublicProfile(nil).Email, ShouldEqual, "alice@example.com")
+	})
+}
+
+func Test_bucketRepoCounts(t *testing.T) {
+	Convey("Users are bucketed by number of repositories", t, func() {
+		tiers := bucketRepoCounts(map[int]int64{0: 10, 1: 3, 5: 2, 6: 4, 20: 1, 21: 2, 100: 1})
+		So(tiers, ShouldResemble, map[string]int64{"0": 10, "1-5": 5, "6-20": 5, "21+": 3})
+
+		var total int64
+		for _, num := range tiers {
+			total += num
+		}
+		So(total, ShouldEqual, 23)
+
+		So(bucketRepoCounts(nil), ShouldResemble, map[string]int64{"0": 0, "1-5": 0, "6-20": 0, "21+": 0})
+	})
+}
+
+func Test_SanitizeSVGAvatar(t *testing.T) {
+	Convey("Scripts and external references are removed from SVG", t, func() {
+		data, err := SanitizeSVGAvatar([]byte(`<?xml version="1.0"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" onload="alert(1)">
+  <script><![CDATA[alert(2)]]></script>
+  <circle id="c" r="4"/>
+  <use xlink:href="#c"/>
+  <image xlink:href="https://evil.example.com/track.png"/>
+</svg>`))
+		So(err, ShouldBeNil)
+		So(IsSVGImage(data), ShouldBeTrue)
+		So(string(data), ShouldStartWith, "<svg")
+		So(string(data), ShouldNotContainSubstring, "script")
+		So(string(data), ShouldNotContainSubstring, "alert")
+		So(string(data), ShouldNotContainSubstring, "evil.example.com")
+		So(string(data), ShouldContainSubstring, `<use xlink:href="#c">`)
+	})
+
+	Convey("Only allowed elements and attributes are kept", t, func() {
+		data, err := SanitizeSVGAvatar([]byte(`<svg xmlns="http://www.w3.org/2000/svg" style="background:url(https://evil.example.com/a)">
+  <a href="https://evil.example.com/"><rect width="1" height="1"/></a>
+  <style>@import "https://evil.example.com/a.css";</style>
+  <rect fill="url(#g)" stroke="url(https://evil.example.com/b)" data-x="1"/>
+</svg>`))
+		So(err, ShouldBeNil)
+		So(string(data), ShouldNotContainSubstring, "evil.example.com")
+		So(string(data), ShouldNotContainSubstring, "data-x")
+		So(string(data), ShouldContainSubstring, `<rect fill="url(#g)">`)
+	})
+
+	Convey("Data without SVG root element is rejected", t, func() {
+		for _, data := range []string{
+			"<html></html>",
+			`<html><svg xmlns="http://www.w3.org/2000/svg"></svg></html>`,
+			`<svg xmlns="http://www.w3.org/2000/svg"></svg><script>alert(1)</script>`,
+		} {
+			_, err := SanitizeSVGAvatar([]byte(data))
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func Test_canViewPrivateRepos(t *testing.T) {
+	Convey("Private repositories are only visible to owner and admins", t, func() {
+		owner := &User{ID: 1}
+		So(canViewPrivateRepos(nil, owner), ShouldBeFalse)
+		So(canViewPrivateRepos(&User{ID: 2}, owner), ShouldBeFalse)
+		So(canViewPrivateRepos(owner, owner), ShouldBeTrue)
+		So(canViewPrivateRepos(&User{ID: 2, IsAdmin: true}, owner), ShouldBeTrue)
+	})
+}
+
+func Test_isSuspectedBot(t *testing.T) {
+	Convey("Flag users matching all bot heuristics", t, func() {
+		opts := BotHeuristics{
+			CreatedWithin:  time.Hour,
+			MinRepos:       10,
+			NoCustomAvatar: true,
+			EmailDomains:   []string{"@Mailinator.com"},
+		}
+		bot := &User{ID: 1, Email: "x1@mailinator.com", NumRepos: 30, CreatedUnix: time.Now().Unix()}
+		So(isSuspectedBot(bot, opts), ShouldBeTrue)
+
+		normal := &User{ID: 2, Email: "alice@example.com", NumRepos: 30, CreatedUnix: time.Now().Unix()}
+		So(isSuspectedBot(normal, opts), ShouldBeFalse)
+
+		old := &User{ID: 3, Email: "x3@mailinator.com", NumRepos: 30, CreatedUnix: time.Now().Add(-48 * time.Hour).Unix()}
+		So(isSuspectedBot(old, opts), ShouldBeFalse)
+
+		So(isSuspectedBot(bot, BotHeuristics{}), ShouldBeFalse)
+	})
+}
+
+func Test_planMergeFollows(t *testing.T) {
+	Convey("Merged follows collapse duplicates and self-follows", t, func() {
+		const keep, merge = 1, 2
+		follows := []*Follow{
+			{ID: 1, UserID: keep, FollowID: 3},
+			{ID: 2, UserID: merge, FollowID: 3},    // Duplicate of 1
+			{ID: 3, UserID: merge, FollowID: 4},    // Moved
+			{ID: 4, UserID: keep, FollowID: merge}, // Self-follow
+			{ID: 5, UserID: merge, FollowID: keep}, // Self-follow
+			{ID: 6, UserID: 5, FollowID: merge},    // Duplicate of 7
+			{ID: 7, UserID: 5, FollowID: keep},
+			{ID: 8, UserID: 6, FollowID: merge}, // Moved
+		}
+
+		moves, deletes := planMergeFollows(follows, keep, merge)
+		So(moves, ShouldResemble, []*Follow{
+			{ID: 3, UserID: keep, FollowID: 4},
+			{ID: 8, UserID: 6, FollowID: keep},
+		})
+		So(deletes, ShouldResemble, []*Follow{follows[1], follows[3], follows[4], follows[5]})
+	})
+}
+
+func Test_planMergeCollaborations(t *testing.T) {
+	Convey("Merged collaborations collapse duplicates and owned repositories", t, func() {
+		const keep, merge = 1, 2
+		keepCollabs := []*Collaboration{
+			{ID: 1, RepoID: 10, UserID: keep, Mode: ACCESS_MODE_READ},
+			{ID: 2, RepoID: 11, UserID: keep, Mode: ACCESS_MODE_ADMIN},
+		}
+		mergeCollabs := []*Collaboration{
+			{ID: 3, RepoID: 10, UserID: merge, Mode: ACCESS_MODE_WRITE}, // Raises 1
+			{ID: 4, RepoID: 11, UserID: merge, Mode: ACCESS_MODE_READ},  // Duplicate of 2
+			{ID: 5, RepoID: 12, UserID: merge, Mode: ACCESS_MODE_WRITE}, // Owned by kept user
+			{ID: 6, RepoID: 13, UserID: merge, Mode: ACCESS_MODE_WRITE}, // Moved
+		}
+
+		moves, deletes, raises := planMergeCollaborations(keepCollabs, mergeCollabs, map[int64]bool{12: true})
+		So(moves, ShouldResemble, []*Collaboration{mergeCollabs[3]})
+		So(deletes, ShouldResemble, []*Collaboration{mergeCollabs[0], mergeCollabs[1], mergeCollabs[2]})
+		So(raises, ShouldResemble, []*Collaboration{{ID: 1, RepoID: 10, UserID: keep, Mode: ACCESS_MODE_WRITE}})
+	})
+}
+
+func Test_planMergeWatches(t *testing.T) {
+	Convey("Merged watches collapse duplicates", t, func() {
+		const keep, merge = 1, 2
+		keepWatches := []*Watch{{ID: 1, UserID: keep, RepoID: 10}}
+		mergeWatches := []*Watch{
+			{ID: 2, UserID: merge, RepoID: 10}, // Duplicate of 1
+			{ID: 3, UserID: merge, RepoID: 11}, // Moved
+		}
+
+		moves, deletes := planMergeWatches(keepWatches, mergeWatches)
+		So(moves, ShouldResemble, []*Watch{mergeWatches[1]})
+		So(deletes, ShouldResemble, []*Watch{mergeWatches[0]})
+
+		moves, deletes = planMergeWatches(nil, nil)
+		So(moves, ShouldBeEmpty)
+		So(deletes, ShouldBeEmpty)
+	})
+}
+
+func Test_matchActivationCode(t *testing.T) {
+	Convey("Find email that activation code was generated for", t, func() {
+		setting.Service.ActiveCodeLives = 180
+		u := &User{ID: 1, LowerName: "alice", Email: "alice@example.com", Passwd: "hash", Rands: "rands"}
+		emails := []string{u.Email, "alt@example.com"}
+
+		email, valid := matchActivationCode(u, u.GenerateEmailActivateCode("alt@example.com"), emails)
+		So(valid, ShouldBeTrue)
+		So(email, ShouldEqual, "alt@example.com")
+
+		email, valid = matchActivationCode(u, u.GenerateActivateCode(), emails)
+		So(valid, ShouldBeTrue)
+		So(email, ShouldEqual, u.Email)
+
+		code := u.GenerateActivateCode()
+		u.Passwd = "changed"
+		email, valid = matchActivationCode(u, code, emails)
+		So(valid, ShouldBeFalse)
+		So(email, ShouldBeEmpty)
+		So(u.IsActive, ShouldBeFalse)
+
+		_, valid = matchActivationCode(u, "short", emails)
+		So(valid, ShouldBeFalse)
+	})
+}
+
+func Test_followCounts(t *testing.T) {
+	Convey("Map grouped follow counts to all given users", t, func() {
+		results := []map[string][]byte{
+			{"follow_id": []byte("1"), "num": []byte("3")},
+			{"follow_id": []byte("2"), "num": []byte("1")},
+			{"follow_id": []byte("9"), "num": []byte("5")},
+		}
+		So(followCounts([]int64{1, 2, 3}, results, "follow_id"), ShouldResemble, map[int64]int64{1: 3, 2: 1, 3: 0})
+		So(followCounts(nil, results, "follow_id"), ShouldBeEmpty)
+	})
+}
+
+func Test_encryptPhone(t *testing.T) {
+	Convey("Validate and encrypt phone number", t, func() {
+		setting.SecretKey = "secret"
+
+		for _, phone := range []string{"12025550123", "+02025550123", "+1202-555-0123", "+1234567890123456"} {
+			_, err := encryptPhone(phone)
+			So(IsErrInvalidPhoneNumber(err), ShouldBeTrue)
+		}
+
+		encrypted, err := encryptPhone("+12025550123")
+		So(err, ShouldBeNil)
+		So(encrypted, ShouldNotContainSubstring, "2025550123")
+
+		u := &User{Phone: encrypted}
+		phone, err := u.PhoneNumber()
+		So(err, ShouldBeNil)
+		So(phone, ShouldEqual, "+12025550123")
+
+		encrypted, err = encryptPhone("")
+		So(err, ShouldBeNil)
+		So(encrypted, ShouldBeEmpty)
+	})
+}
+
+func Test_AvatarDefaultURL(t *testing.T) {
+	Convey("Default avatar URL is stable and distinct per user", t, func() {
+		setting.AppUrl = "https://try.gogs.io/"
+		alice := &User{ID: 1, LowerName: "alice"}
+		bob := &User{ID: 2, LowerName: "bob"}
+
+		So(AvatarDefaultURL(alice), ShouldEqual, "https://try.gogs.io/user/avatar/alice")
+		So(AvatarDefaultURL(alice), ShouldEqual, AvatarDefaultURL(alice))
+		So(AvatarDefaultURL(alice), ShouldNotEqual, AvatarDefaultURL(bob))
+	})
+}
+
+func Test_isVerifiedAvatarEmail(t *testing.T) {
+	Convey("Avatar email must be primary or activated email of user", t, func() {
+		u := &User{ID: 1, Email: "alice@example.com"}
+		emails := []*EmailAddress{
+			{UID: 1, Email: "alt@example.com", IsActivated: true},
+			{UID: 1, Email: "pending@example.com", IsActivated: false},
+		}
+
+		u.AvatarEmail = "Alice@example.com"
+		So(isVerifiedAvatarEmail(u, emails), ShouldBeTrue)
+		u.AvatarEmail = "alt@example.com"
+		So(isVerifiedAvatarEmail(u, emails), ShouldBeTrue)
+		u.AvatarEmail = "pending@example.com"
+		So(isVerifiedAvatarEmail(u, emails), ShouldBeFalse)
+		u.AvatarEmail = "bob@example.com"
+		So(isVerifiedAvatarEmail(u, emails), ShouldBeFalse)
+	})
+
+	Convey("Skip check of avatar email when it is not required", t, func() {
+		setting.RequireVerifiedAvatarEmail = false
+		So(checkAvatarEmail(nil, &User{Email: "alice@example.com", AvatarEmail: "bob@example.com"}), ShouldBeNil)
+
+		setting.RequireVerifiedAvatarEmail = true
+		So(checkAvatarEmail(nil, &User{Email: "alice@example.com", AvatarEmail: "alice@example.com"}), ShouldBeNil)
+		setting.RequireVerifiedAvatarEmail = false
+	})
+}
+
+func Test_UserPermissions(t *testing.T) {
+	Convey("Permissions reflect flags of user", t, func() {
+		setting.Repository.MaxCreationLimit = -1
+
+		u := &User{IsAdmin: true, MaxRepoCreation: -1}
+		So(u.Permissions(), ShouldResemble, UserPermissions{
+			IsAdmin:               true,
+			CanCreateRepo:         true,
+			CanCreateOrganization: true,
+			CanEditGitHook:        true,
+			CanImportLocal:        true,
+		})
+
+		u = &User{IsRestricted: true, AllowGitHook: true, MaxRepoCreation: 2, NumRepos: 2}
+		So(u.Permissions(), ShouldResemble, UserPermissions{
+			IsRestricted:          true,
+			CanCreateOrganization: true,
+			CanEditGitHook:        true,
+		})
+
+		u = &User{ProhibitLogin: true, MaxRepoCreation: -1}
+		So(u.Permissions(), ShouldResemble, UserPermissions{IsSuspended: true})
+
+		org := &User{Type: USER_TYPE_ORGANIZATION, MaxRepoCreation: -1}
+		So(org.Permissions().CanCreateRepo, ShouldBeTrue)
+		So(org.Permissions().CanCreateOrganization, ShouldBeFalse)
+	})
+}
+
+func Test_PasswdHash(t *testing.T) {
+	Convey("New password is hashed with bcrypt", t, func() {
+		setting.BcryptCost = bcrypt.MinCost
+		u := &User{Passwd: "correct horse", Salt: "salt"}
+		So(u.EncodePasswd(), ShouldBeNil)
+		So(u.PasswdHashAlgo, ShouldEqual, PASSWD_HASH_BCRYPT)
+		So(u.Passwd, ShouldStartWith, "$2a$")
+		So(u.ValidatePassword("correct horse"), ShouldBeTrue)
+		So(u.ValidatePassword("wrong"), ShouldBeFalse)
+
+		upgraded, err := u.upgradePasswdHash("correct horse")
+		So(err, ShouldBeNil)
+		So(upgraded, ShouldBeFalse)
+	})
+
+	Convey("Failure of bcrypt is returned and leaves password as is", t, func() {
+		setting.BcryptCost = bcrypt.MaxCost + 1
+		legacy := encodePasswdPBKDF2("correct horse", "salt")
+		u := &User{Passwd: legacy, Salt: "salt"}
+
+		upgraded, err := u.upgradePasswdHash("correct horse")
+		So(err, ShouldNotBeNil)
+		So(upgraded, ShouldBeFalse)
+		So(u.Passwd, ShouldEqual, legacy)
+		So(u.PasswdHashAlgo, ShouldEqual, PASSWD_HASH_PBKDF2)
+		So(u.ValidatePassword("correct horse"), ShouldBeTrue)
+		setting.BcryptCost = bcrypt.MinCost
+	})
+
+	Convey("Hash algorithm is saved together with password", t, func() {
+		So(passwdCols("rands"), ShouldResemble, []string{"passwd", "salt", "passwd_hash_algo", "rands"})
+	})
+
+	Convey("Legacy password hash is upgraded after successful validation", t, func() {
+		setting.BcryptCost = bcrypt.MinCost
+		u := &User{Passwd: encodePasswdPBKDF2("correct horse", "salt"), Salt: "salt"}
+		So(u.ValidatePassword("wrong"), ShouldBeFalse)
+		So(u.ValidatePassword("correct horse"), ShouldBeTrue)
+
+		upgraded, err := u.upgradePasswdHash("correct horse")
+		So(err, ShouldBeNil)
+		So(upgraded, ShouldBeTrue)
+		So(u.PasswdHashAlgo, ShouldEqual, PASSWD_HASH_BCRYPT)
+		So(u.ValidatePassword("correct horse"), ShouldBeTrue)
+		So(u.ValidatePassword("wrong"), ShouldBeFalse)
+	})
+}
+
+func Test_FailedLoginLockout(t *testing.T) {
+	Convey("Lockout duration escalates after threshold", t, func() {
+		setting.LoginMaxFailures = 3
+		setting.LoginLockoutMinutes = 5
+
+		So(lockoutDuration(2), ShouldEqual, 0)
+		So(lockoutDuration(3), ShouldEqual, 5*time.Minute)
+		So(lockoutDuration(4), ShouldEqual, 10*time.Minute)
+		So(lockoutDuration(5), ShouldEqual, 20*time.Minute)
+		So(lockoutDuration(100), ShouldEqual, _MAX_LOCKOUT_DURATION)
+
+		setting.LoginMaxFailures = 0
+		So(lockoutDuration(100), ShouldEqual, 0)
+	})
+
+	Convey("User is locked after too many failures and unlocked after expiry", t, func() {
+		setting.LoginMaxFailures = 3
+		setting.LoginLockoutMinutes = 5
+
+		u := &User{}
+		now := time.Now()
+		u.recordFailedLogin(now)
+		u.recordFailedLogin(now)
+		So(u.IsLocked(), ShouldBeFalse)
+		u.recordFailedLogin(now)
+		So(u.NumFailedLogins, ShouldEqual, 3)
+		So(u.IsLocked(), ShouldBeTrue)
+
+		u.recordFailedLogin(now.Add(-time.Hour))
+		So(u.LockedUntilUnix, ShouldEqual, now.Add(-time.Hour).Add(10*time.Minute).Unix())
+		So(u.IsLocked(), ShouldBeFalse)
+	})
+}
+
+func Test_orderUsersByNames(t *testing.T) {
+	Convey("Order users by given names and skip unknown names", t, func() {
+		alice := &User{ID: 1, LowerName: "alice"}
+		bob := &User{ID: 2, LowerName: "bob"}
+		So(orderUsersByNames([]string{"Bob", "ghost", "alice", "bob"}, []*User{alice, bob}),
+			ShouldResemble, []*User{bob, alice, bob})
+		So(orderUsersByNames([]string{"ghost"}, nil), ShouldBeEmpty)
+	})
+}
+
+// Users of all names are fetched by a single query before ordering, instead of
+// one query per name, so this measures the only per-name work left.
+func Test_SoftDeleteUser(t *testing.T) {
+	Convey("Soft-deleted user frees its name and is reaped after retention", t, func() {
+		now := time.Now()
+		retention := 30 * 24 * time.Hour
+
+		u := &User{ID: 7, Name: "alice", LowerName: "alice", Email: "alice@example.com", IsActive: true, Rands: "rands"}
+		So(u.IsDeleted, ShouldBeFalse)
+		So(u.isReapable(now, retention), ShouldBeFalse)
+
+		u.markDeleted(now)
+		So(u.IsDeleted, ShouldBeTrue)
+		So(u.Name, ShouldEqual, "deleted~7")
+		So(u.LowerName, ShouldEqual, u.Name)
+		So(ValidateUserName(u.Name), ShouldEqual, ErrUserNameIllegal)
+		So(u.Email, ShouldBeEmpty)
+		So(u.IsActive, ShouldBeFalse)
+		So(u.ProhibitLogin, ShouldBeTrue)
+		So(u.Rands, ShouldNotEqual, "rands")
+		So(u.SessionEpoch, ShouldEqual, 1)
+		So(u.isReapable(now.Add(retention-time.Hour), retention), ShouldBeFalse)
+
+		So(u.isReapable(now.Add(retention), retention), ShouldBeTrue)
+	})
+}
@@ -67,12 +67,24 @@ func SignedInID(ctx *macaron.Context, sess session.Store) int64 {
 		return 0
 	}
 	if id, ok := uid.(int64); ok {
-		if _, err := models.GetUserByID(id); err != nil {
+		u, err := models.GetUserByID(id)
+		if err != nil {
 			if !models.IsErrUserNotExist(err) {
 				log.Error(4, "GetUserById: %v", err)
 			}
 			return 0
 		}
+
+		epoch, _ := sess.Get("session_epoch").(int)
+		if !u.IsValidSessionEpoch(epoch) {
+			return 0
+		}
+
+		// Lifetime of user may be shorter than global session lifetime.
+		if signedIn, ok := sess.Get("signed_in_unix").(int64); ok &&
+			time.Since(time.Unix(signedIn, 0)) > models.EffectiveSessionLifetime(u) {
+			return 0
+		}
 		return id
 	}
 	return 0
@@ -128,7 +140,8 @@ func SignedInUser(ctx *macaron.Context, sess session.Store) (*models.User, bool)
 
 				u, err := models.UserSignIn(uname, passwd)
 				if err != nil {
-					if !models.IsErrUserNotExist(err) {
+					// Locked user is treated as unauthorized.
+					if !models.IsErrUserNotExist(err) && !models.IsErrUserLocked(err) {
 						log.Error(4, "UserSignIn: %v", err)
 					}
 					return nil, false
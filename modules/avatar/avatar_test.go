@@ -21,3 +21,12 @@ func Test_RandomImage(t *testing.T) {
 		})
 	})
 }
+
+func Test_InitialsImage(t *testing.T) {
+	Convey("Generate an initials avatar", t, func() {
+		img := InitialsImageSize(70, "GS", 1)
+		So(img.Bounds().Dx(), ShouldEqual, 70)
+		So(img.At(0, 0), ShouldResemble, InitialsImageSize(70, "AB", 1).At(0, 0))
+		So(img.At(0, 0), ShouldNotResemble, InitialsImageSize(70, "GS", 2).At(0, 0))
+	})
+}
@@ -0,0 +1,120 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package avatar
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"unicode"
+)
+
+// initialsBackgrounds are background colors of initials avatars,
+// all of them have enough contrast with white text.
+var initialsBackgrounds = []color.RGBA{
+	{0xdb, 0x28, 0x28, 0xff},
+	{0xf2, 0x71, 0x1c, 0xff},
+	{0xb5, 0x8d, 0x04, 0xff},
+	{0x16, 0xab, 0x39, 0xff},
+	{0x00, 0xb5, 0xad, 0xff},
+	{0x21, 0x85, 0xd0, 0xff},
+	{0x64, 0x35, 0xc9, 0xff},
+	{0xa3, 0x33, 0xc8, 0xff},
+	{0xe0, 0x39, 0x97, 0xff},
+	{0x76, 0x76, 0x76, 0xff},
+}
+
+// glyphs is a 5x7 bitmap font of characters can be used as initials.
+var glyphs = map[rune][7]string{
+	'A': {"01110", "10001", "10001", "11111", "10001", "10001", "10001"},
+	'B': {"11110", "10001", "10001", "11110", "10001", "10001", "11110"},
+	'C': {"01110", "10001", "10000", "10000", "10000", "10001", "01110"},
+	'D': {"11110", "10001", "10001", "10001", "10001", "10001", "11110"},
+	'E': {"11111", "10000", "10000", "11110", "10000", "10000", "11111"},
+	'F': {"11111", "10000", "10000", "11110", "10000", "10000", "10000"},
+	'G': {"01110", "10001", "10000", "10111", "10001", "10001", "01111"},
+	'H': {"10001", "10001", "10001", "11111", "10001", "10001", "10001"},
+	'I': {"01110", "00100", "00100", "00100", "00100", "00100", "01110"},
+	'J': {"00111", "00010", "00010", "00010", "00010", "10010", "01100"},
+	'K': {"10001", "10010", "10100", "11000", "10100", "10010", "10001"},
+	'L': {"10000", "10000", "10000", "10000", "10000", "10000", "11111"},
+	'M': {"10001", "11011", "10101", "10101", "10001", "10001", "10001"},
+	'N': {"10001", "10001", "11001", "10101", "10011", "10001", "10001"},
+	'O': {"01110", "10001", "10001", "10001", "10001", "10001", "01110"},
+	'P': {"11110", "10001", "10001", "11110", "10000", "10000", "10000"},
+	'Q': {"01110", "10001", "10001", "10001", "10101", "10010", "01101"},
+	'R': {"11110", "10001", "10001", "11110", "10100", "10010", "10001"},
+	'S': {"01111", "10000", "10000", "01110", "00001", "00001", "11110"},
+	'T': {"11111", "00100", "00100", "00100", "00100", "00100", "00100"},
+	'U': {"10001", "10001", "10001", "10001", "10001", "10001", "01110"},
+	'V': {"10001", "10001", "10001", "10001", "10001", "01010", "00100"},
+	'W': {"10001", "10001", "10001", "10101", "10101", "10101", "01010"},
+	'X': {"10001", "10001", "01010", "00100", "01010", "10001", "10001"},
+	'Y': {"10001", "10001", "01010", "00100", "00100", "00100", "00100"},
+	'Z': {"11111", "00001", "00010", "00100", "01000", "10000", "11111"},
+	'0': {"01110", "10001", "10011", "10101", "11001", "10001", "01110"},
+	'1': {"00100", "01100", "00100", "00100", "00100", "00100", "01110"},
+	'2': {"01110", "10001", "00001", "00010", "00100", "01000", "11111"},
+	'3': {"11111", "00010", "00100", "00010", "00001", "10001", "01110"},
+	'4': {"00010", "00110", "01010", "10010", "11111", "00010", "00010"},
+	'5': {"11111", "10000", "11110", "00001", "00001", "10001", "01110"},
+	'6': {"00110", "01000", "10000", "11110", "10001", "10001", "01110"},
+	'7': {"11111", "00001", "00010", "00100", "01000", "01000", "01000"},
+	'8': {"01110", "10001", "10001", "01110", "10001", "10001", "01110"},
+	'9': {"01110", "10001", "10001", "01111", "00001", "00010", "01100"},
+	'?': {"01110", "10001", "00001", "00010", "00100", "00000", "00100"},
+}
+
+const (
+	_GLYPH_WIDTH  = 5
+	_GLYPH_HEIGHT = 7
+)
+
+// InitialsImageSize generates and returns an avatar image with given initials
+// drawn on a background color chosen by seed, in custom size (height and width).
+// Characters without glyph are drawn as "?".
+func InitialsImageSize(size int, initials string, seed int64) image.Image {
+	bg := initialsBackgrounds[int(uint64(seed)%uint64(len(initialsBackgrounds)))]
+	m := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(m, m.Bounds(), &image.Uniform{bg}, image.ZP, draw.Src)
+
+	chars := []rune(initials)
+	if len(chars) == 0 {
+		return m
+	}
+
+	// Text takes half width of image, a column of space is put between characters.
+	cols := len(chars)*(_GLYPH_WIDTH+1) - 1
+	scale := size / 2 / cols
+	if scale < 1 {
+		scale = 1
+	}
+	left := (size - cols*scale) / 2
+	top := (size - _GLYPH_HEIGHT*scale) / 2
+
+	fg := &image.Uniform{color.White}
+	for i, c := range chars {
+		glyph, ok := glyphs[unicode.ToUpper(c)]
+		if !ok {
+			glyph = glyphs['?']
+		}
+		for y, row := range glyph {
+			for x := range row {
+				if row[x] != '1' {
+					continue
+				}
+				min := image.Pt(left+(i*(_GLYPH_WIDTH+1)+x)*scale, top+y*scale)
+				draw.Draw(m, image.Rectangle{Min: min, Max: min.Add(image.Pt(scale, scale))}, fg, image.ZP, draw.Src)
+			}
+		}
+	}
+	return m
+}
+
+// InitialsImage generates and returns an avatar image with given initials
+// in default size (height and width).
+func InitialsImage(initials string, seed int64) image.Image {
+	return InitialsImageSize(AVATAR_SIZE, initials, seed)
+}
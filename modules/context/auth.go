@@ -10,10 +10,23 @@ import (
 	"github.com/go-macaron/csrf"
 	"gopkg.in/macaron.v1"
 
+	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/auth"
 	"github.com/gogits/gogs/modules/setting"
 )
 
+// passwordChangePaths are the only paths that can be visited by user who must change password.
+var passwordChangePaths = map[string]bool{
+	"/user/settings/password": true,
+	"/user/logout":            true,
+}
+
+// MustChangePasswordFirst returns true if user has to change password
+// before it is allowed to visit given path, users can only change it or sign out.
+func MustChangePasswordFirst(u *models.User, path string) bool {
+	return u != nil && u.MustChangePassword && !passwordChangePaths[path]
+}
+
 type ToggleOptions struct {
 	SignInRequired  bool
 	SignOutRequired bool
@@ -36,6 +49,17 @@ func Toggle(options *ToggleOptions) macaron.Handler {
 			return
 		}
 
+		if ctx.IsSigned && MustChangePasswordFirst(ctx.User, ctx.Req.URL.Path) {
+			if auth.IsAPIPath(ctx.Req.URL.Path) {
+				ctx.JSON(403, map[string]string{
+					"message": "Password must be changed before calling APIs.",
+				})
+				return
+			}
+			ctx.Redirect(setting.AppSubUrl + "/user/settings/password")
+			return
+		}
+
 		// Check non-logged users landing page.
 		if !ctx.IsSigned && ctx.Req.RequestURI == "/" && setting.LandingPageUrl != setting.LANDING_PAGE_HOME {
 			ctx.Redirect(setting.AppSubUrl + string(setting.LandingPageUrl))
@@ -0,0 +1,30 @@
+// Copyright 2016 The Gogs Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package context
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/gogits/gogs/models"
+)
+
+func Test_MustChangePasswordFirst(t *testing.T) {
+	Convey("User who must change password can only change it or sign out", t, func() {
+		u := &models.User{MustChangePassword: true}
+		So(MustChangePasswordFirst(u, "/"), ShouldBeTrue)
+		So(MustChangePasswordFirst(u, "/user/settings"), ShouldBeTrue)
+		So(MustChangePasswordFirst(u, "/api/v1/user"), ShouldBeTrue)
+		So(MustChangePasswordFirst(u, "/alice/project.git/info/refs"), ShouldBeTrue)
+		So(MustChangePasswordFirst(u, "/user/settings/password"), ShouldBeFalse)
+		So(MustChangePasswordFirst(u, "/user/logout"), ShouldBeFalse)
+	})
+
+	Convey("Other users are not restricted", t, func() {
+		So(MustChangePasswordFirst(&models.User{}, "/"), ShouldBeFalse)
+		So(MustChangePasswordFirst(nil, "/"), ShouldBeFalse)
+	})
+}
@@ -54,6 +54,17 @@ func NewContext() {
 			go models.CheckRepoStats()
 		}
 	}
+	if setting.Cron.ReapDeletedUsers.Enabled {
+		entry, err = c.AddFunc("Reap deleted users", setting.Cron.ReapDeletedUsers.Schedule, models.ReapDeletedUsersTask)
+		if err != nil {
+			log.Fatal(4, "Cron[Reap deleted users]: %v", err)
+		}
+		if setting.Cron.ReapDeletedUsers.RunAtStart {
+			entry.Prev = time.Now()
+			entry.ExecTimes++
+			go models.ReapDeletedUsersTask()
+		}
+	}
 	c.Start()
 }
 
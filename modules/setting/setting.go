@@ -88,6 +88,9 @@ var (
 	CookieUserName       string
 	CookieRememberName   string
 	ReverseProxyAuthUser string
+	BcryptCost           int
+	LoginMaxFailures     int
+	LoginLockoutMinutes  int
 
 	// Database settings
 	UseSQLite3    bool
@@ -109,6 +112,7 @@ var (
 		AnsiCharset            string
 		ForcePrivate           bool
 		MaxCreationLimit       int
+		MaxStorage             int64
 		PullRequestQueueLength int
 	}
 	RepoRootPath string
@@ -143,6 +147,10 @@ var (
 	AvatarUploadPath string
 	GravatarSource   string
 	DisableGravatar  bool
+	DefaultAvatar    string
+	InitialsAvatar   bool
+	// Whether avatar email must be a verified email address of the user
+	RequireVerifiedAvatarEmail bool
 
 	// Log settings
 	LogRootPath string
@@ -187,6 +195,11 @@ var (
 			RunAtStart bool
 			Schedule   string
 		} `ini:"cron.check_repo_stats"`
+		ReapDeletedUsers struct {
+			Enabled    bool
+			RunAtStart bool
+			Schedule   string
+		} `ini:"cron.reap_deleted_users"`
 	}
 
 	// Git settings
@@ -395,6 +408,9 @@ func NewContext() {
 	CookieUserName = sec.Key("COOKIE_USERNAME").String()
 	CookieRememberName = sec.Key("COOKIE_REMEMBER_NAME").String()
 	ReverseProxyAuthUser = sec.Key("REVERSE_PROXY_AUTHENTICATION_USER").MustString("X-WEBAUTH-USER")
+	BcryptCost = sec.Key("BCRYPT_COST").RangeInt(10, 4, 31)
+	LoginMaxFailures = sec.Key("LOGIN_MAX_FAILURES").MustInt(5)
+	LoginLockoutMinutes = sec.Key("LOGIN_LOCKOUT_MINUTES").MustInt(5)
 
 	sec = Cfg.Section("attachment")
 	AttachmentPath = sec.Key("PATH").MustString(path.Join(AppDataPath, "attachments"))
@@ -460,6 +476,15 @@ func NewContext() {
 		GravatarSource = source
 	}
 	DisableGravatar = sec.Key("DISABLE_GRAVATAR").MustBool()
+	// Existence of default avatar is checked once here instead of every time an avatar is rendered.
+	DefaultAvatar = sec.Key("DEFAULT_AVATAR").String()
+	if len(DefaultAvatar) > 0 && !com.IsFile(path.Join(CustomPath, "public", DefaultAvatar)) &&
+		!com.IsFile(path.Join(StaticRootPath, "public", DefaultAvatar)) {
+		log.Warn("Default avatar '%s' does not exist, built-in one is used instead", DefaultAvatar)
+		DefaultAvatar = ""
+	}
+	InitialsAvatar = sec.Key("INITIALS_AVATAR").MustBool()
+	RequireVerifiedAvatarEmail = sec.Key("REQUIRE_VERIFIED_AVATAR_EMAIL").MustBool()
 	if OfflineMode {
 		DisableGravatar = true
 	}
@@ -497,6 +522,10 @@ var Service struct {
 	EnableReverseProxyAuth         bool
 	EnableReverseProxyAutoRegister bool
 	EnableCaptcha                  bool
+	NoReplyAddress                 string
+	DeletedUserNameCooldown        int
+	SoftDeleteUsers                bool
+	DeletedUserRetentionDays       int
 }
 
 func newService() {
@@ -509,6 +538,10 @@ func newService() {
 	Service.EnableReverseProxyAuth = sec.Key("ENABLE_REVERSE_PROXY_AUTHENTICATION").MustBool()
 	Service.EnableReverseProxyAutoRegister = sec.Key("ENABLE_REVERSE_PROXY_AUTO_REGISTRATION").MustBool()
 	Service.EnableCaptcha = sec.Key("ENABLE_CAPTCHA").MustBool()
+	Service.NoReplyAddress = sec.Key("NO_REPLY_ADDRESS").MustString("noreply." + Domain)
+	Service.DeletedUserNameCooldown = sec.Key("DELETED_USERNAME_COOLDOWN_MINUTES").MustInt()
+	Service.SoftDeleteUsers = sec.Key("SOFT_DELETE_USERS").MustBool()
+	Service.DeletedUserRetentionDays = sec.Key("DELETED_USER_RETENTION_DAYS").MustInt(30)
 }
 
 var logLevels = map[string]string{
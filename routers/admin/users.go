@@ -179,6 +179,25 @@ func EditUserPost(ctx *context.Context, form auth.AdminEditUserForm) {
 		return
 	}
 
+	if len(form.Password) > 0 {
+		if err := models.AdminResetPassword(u.ID, form.Password); err != nil {
+			if models.IsErrPasswordTooWeak(err) {
+				ctx.Data["Err_Password"] = true
+				ctx.RenderWithErr(ctx.Tr("auth.password_too_short"), USER_EDIT, &form)
+			} else {
+				ctx.Handle(500, "AdminResetPassword", err)
+			}
+			return
+		}
+
+		// Reload so profile update below does not overwrite the new password.
+		var err error
+		if u, err = models.GetUserByID(u.ID); err != nil {
+			ctx.Handle(500, "GetUserByID", err)
+			return
+		}
+	}
+
 	fields := strings.Split(form.LoginType, "-")
 	if len(fields) == 2 {
 		loginType := models.LoginType(com.StrTo(fields[0]).MustInt())
@@ -190,12 +209,6 @@ func EditUserPost(ctx *context.Context, form auth.AdminEditUserForm) {
 		}
 	}
 
-	if len(form.Password) > 0 {
-		u.Passwd = form.Password
-		u.Salt = models.GetUserSalt()
-		u.EncodePasswd()
-	}
-
 	u.LoginName = form.LoginName
 	u.FullName = form.FullName
 	u.Email = form.Email
@@ -208,12 +221,15 @@ func EditUserPost(ctx *context.Context, form auth.AdminEditUserForm) {
 	u.AllowImportLocal = form.AllowImportLocal
 	u.ProhibitLogin = form.ProhibitLogin
 
-	if err := models.UpdateUser(u); err != nil {
+	if err := models.UpdateUserProfile(u); err != nil {
 		if models.IsErrEmailAlreadyUsed(err) {
 			ctx.Data["Err_Email"] = true
 			ctx.RenderWithErr(ctx.Tr("form.email_been_used"), USER_EDIT, &form)
+		} else if models.IsErrInvalidWebsiteURL(err) {
+			ctx.Data["Err_Website"] = true
+			ctx.RenderWithErr(ctx.Tr("settings.website")+ctx.Tr("form.url_error"), USER_EDIT, &form)
 		} else {
-			ctx.Handle(500, "UpdateUser", err)
+			ctx.Handle(500, "UpdateUserProfile", err)
 		}
 		return
 	}
@@ -230,7 +246,8 @@ func DeleteUser(ctx *context.Context) {
 		return
 	}
 
-	if err = models.DeleteUser(u); err != nil {
+	name := u.Name
+	if err = models.DeleteUserAccount(u); err != nil {
 		switch {
 		case models.IsErrUserOwnRepos(err):
 			ctx.Flash.Error(ctx.Tr("admin.users.still_own_repo"))
@@ -243,11 +260,11 @@ func DeleteUser(ctx *context.Context) {
 				"redirect": setting.AppSubUrl + "/admin/users/" + ctx.Params(":userid"),
 			})
 		default:
-			ctx.Handle(500, "DeleteUser", err)
+			ctx.Handle(500, "DeleteUserAccount", err)
 		}
 		return
 	}
-	log.Trace("Account deleted by admin (%s): %s", ctx.User.Name, u.Name)
+	log.Trace("Account deleted by admin (%s): %s", ctx.User.Name, name)
 
 	ctx.Flash.Success(ctx.Tr("admin.users.deletion_success"))
 	ctx.JSON(200, map[string]interface{}{
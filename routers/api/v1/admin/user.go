@@ -79,17 +79,29 @@ func EditUser(ctx *context.APIContext, form api.EditUserOption) {
 		return
 	}
 
+	if len(form.Password) > 0 {
+		if err := models.AdminResetPassword(u.ID, form.Password); err != nil {
+			if models.IsErrPasswordTooWeak(err) {
+				ctx.Error(422, "", err)
+			} else {
+				ctx.Error(500, "AdminResetPassword", err)
+			}
+			return
+		}
+
+		// Reload so profile update below does not overwrite the new password.
+		var err error
+		if u, err = models.GetUserByID(u.ID); err != nil {
+			ctx.Error(500, "GetUserByID", err)
+			return
+		}
+	}
+
 	parseLoginSource(ctx, u, form.SourceID, form.LoginName)
 	if ctx.Written() {
 		return
 	}
 
-	if len(form.Password) > 0 {
-		u.Passwd = form.Password
-		u.Salt = models.GetUserSalt()
-		u.EncodePasswd()
-	}
-
 	u.LoginName = form.LoginName
 	u.FullName = form.FullName
 	u.Email = form.Email
@@ -108,11 +120,11 @@ func EditUser(ctx *context.APIContext, form api.EditUserOption) {
 		u.AllowImportLocal = *form.AllowImportLocal
 	}
 
-	if err := models.UpdateUser(u); err != nil {
-		if models.IsErrEmailAlreadyUsed(err) {
+	if err := models.UpdateUserProfile(u); err != nil {
+		if models.IsErrEmailAlreadyUsed(err) || models.IsErrInvalidWebsiteURL(err) {
 			ctx.Error(422, "", err)
 		} else {
-			ctx.Error(500, "UpdateUser", err)
+			ctx.Error(500, "UpdateUserProfile", err)
 		}
 		return
 	}
@@ -128,16 +140,17 @@ func DeleteUser(ctx *context.APIContext) {
 		return
 	}
 
-	if err := models.DeleteUser(u); err != nil {
+	name := u.Name
+	if err := models.DeleteUserAccount(u); err != nil {
 		if models.IsErrUserOwnRepos(err) ||
 			models.IsErrUserHasOrgs(err) {
 			ctx.Error(422, "", err)
 		} else {
-			ctx.Error(500, "DeleteUser", err)
+			ctx.Error(500, "DeleteUserAccount", err)
 		}
 		return
 	}
-	log.Trace("Account deleted by admin(%s): %s", ctx.User.Name, u.Name)
+	log.Trace("Account deleted by admin(%s): %s", ctx.User.Name, name)
 
 	ctx.Status(204)
 }
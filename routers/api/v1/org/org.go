@@ -57,8 +57,12 @@ func Edit(ctx *context.APIContext, form api.EditOrgOption) {
 	org.Description = form.Description
 	org.Website = form.Website
 	org.Location = form.Location
-	if err := models.UpdateUser(org); err != nil {
-		ctx.Error(500, "UpdateUser", err)
+	if err := models.UpdateUserProfile(org); err != nil {
+		if models.IsErrInvalidWebsiteURL(err) {
+			ctx.Error(422, "", err)
+		} else {
+			ctx.Error(500, "UpdateUserProfile", err)
+		}
 		return
 	}
 
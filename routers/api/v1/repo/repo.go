@@ -121,6 +121,7 @@ func CreateUserRepo(ctx *context.APIContext, owner *models.User, opt api.CreateR
 	})
 	if err != nil {
 		if models.IsErrRepoAlreadyExist(err) ||
+			models.IsErrUserStorageQuotaExceeded(err) ||
 			models.IsErrNameReserved(err) ||
 			models.IsErrNamePatternNotAllowed(err) {
 			ctx.Error(422, "", err)
@@ -230,6 +231,10 @@ func Migrate(ctx *context.APIContext, form auth.MigrateRepoForm) {
 				log.Error(4, "DeleteRepository: %v", errDelete)
 			}
 		}
+		if models.IsErrUserStorageQuotaExceeded(err) {
+			ctx.Error(422, "", err)
+			return
+		}
 		ctx.Error(500, "MigrateRepository", models.HandleCloneUserCredentials(err.Error(), true))
 		return
 	}
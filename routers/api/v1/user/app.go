@@ -13,15 +13,16 @@ import (
 
 // https://github.com/gogits/go-gogs-client/wiki/Users#list-access-tokens-for-a-user
 func ListAccessTokens(ctx *context.APIContext) {
-	tokens, err := models.ListAccessTokens(ctx.User.ID)
+	tokens, err := models.GetAccessTokens(ctx.User.ID)
 	if err != nil {
-		ctx.Error(500, "ListAccessTokens", err)
+		ctx.Error(500, "GetAccessTokens", err)
 		return
 	}
 
 	apiTokens := make([]*api.AccessToken, len(tokens))
 	for i := range tokens {
-		apiTokens[i] = &api.AccessToken{tokens[i].Name, tokens[i].Sha1}
+		// Only hash of token is stored, plaintext token is not available after creation.
+		apiTokens[i] = &api.AccessToken{tokens[i].Name, ""}
 	}
 	ctx.JSON(200, &apiTokens)
 }
@@ -36,5 +37,5 @@ func CreateAccessToken(ctx *context.APIContext, form api.CreateAccessTokenOption
 		ctx.Error(500, "NewAccessToken", err)
 		return
 	}
-	ctx.JSON(201, &api.AccessToken{t.Name, t.Sha1})
+	ctx.JSON(201, &api.AccessToken{t.Name, t.Token})
 }
@@ -11,6 +11,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/Unknwon/com"
 	"github.com/go-xorm/xorm"
@@ -72,6 +73,15 @@ func GlobalInit() {
 		}
 
 		models.HasEngine = true
+
+		collisions, err := models.FindCaseInsensitiveEmailCollisions()
+		if err != nil {
+			log.Error(4, "FindCaseInsensitiveEmailCollisions: %v", err)
+		}
+		for _, c := range collisions {
+			log.Warn("Primary email '%s' is used by multiple users: %v", c.Email, c.UserIDs)
+		}
+
 		cron.NewContext()
 		models.InitDeliverHooks()
 		models.InitTestPullRequests()
@@ -376,6 +386,8 @@ func InstallPost(ctx *context.Context, form auth.InstallForm) {
 		// Auto-login for admin
 		ctx.Session.Set("uid", u.ID)
 		ctx.Session.Set("uname", u.Name)
+		ctx.Session.Set("session_epoch", u.SessionEpoch)
+		ctx.Session.Set("signed_in_unix", time.Now().Unix())
 	}
 
 	log.Info("First-time run install finished!")
@@ -5,8 +5,6 @@
 package org
 
 import (
-	"strings"
-
 	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/auth"
 	"github.com/gogits/gogs/modules/base"
@@ -40,31 +38,28 @@ func SettingsPost(ctx *context.Context, form auth.UpdateOrgSettingForm) {
 	org := ctx.Org.Organization
 
 	// Check if organization name has been changed.
-	if org.LowerName != strings.ToLower(form.Name) {
-		isExist, err := models.IsUserExist(org.ID, form.Name)
-		if err != nil {
-			ctx.Handle(500, "IsUserExist", err)
-			return
-		} else if isExist {
+	if org.Name != form.Name {
+		oldName := org.Name
+		if err := models.ChangeOrgName(org, form.Name); err != nil {
 			ctx.Data["OrgName"] = true
-			ctx.RenderWithErr(ctx.Tr("form.username_been_taken"), SETTINGS_OPTIONS, &form)
-			return
-		} else if err = models.ChangeUserName(org, form.Name); err != nil {
-			if err == models.ErrUserNameIllegal {
-				ctx.Data["OrgName"] = true
+			switch {
+			case models.IsErrUserAlreadyExist(err):
+				ctx.RenderWithErr(ctx.Tr("form.username_been_taken"), SETTINGS_OPTIONS, &form)
+			case err == models.ErrUserNameIllegal:
 				ctx.RenderWithErr(ctx.Tr("form.illegal_username"), SETTINGS_OPTIONS, &form)
-			} else {
-				ctx.Handle(500, "ChangeUserName", err)
+			case models.IsErrNameReserved(err):
+				ctx.RenderWithErr(ctx.Tr("org.form.name_reserved", err.(models.ErrNameReserved).Name), SETTINGS_OPTIONS, &form)
+			case models.IsErrNamePatternNotAllowed(err):
+				ctx.RenderWithErr(ctx.Tr("org.form.name_pattern_not_allowed", err.(models.ErrNamePatternNotAllowed).Pattern), SETTINGS_OPTIONS, &form)
+			default:
+				ctx.Handle(500, "ChangeOrgName", err)
 			}
 			return
 		}
 		// reset ctx.org.OrgLink with new name
-		ctx.Org.OrgLink = setting.AppSubUrl + "/org/" + form.Name
-		log.Trace("Organization name changed: %s -> %s", org.Name, form.Name)
+		ctx.Org.OrgLink = setting.AppSubUrl + "/org/" + org.Name
+		log.Trace("Organization name changed: %s -> %s", oldName, org.Name)
 	}
-	// In case it's just a case change.
-	org.Name = form.Name
-	org.LowerName = strings.ToLower(form.Name)
 
 	if ctx.User.IsAdmin {
 		org.MaxRepoCreation = form.MaxRepoCreation
@@ -74,8 +69,13 @@ func SettingsPost(ctx *context.Context, form auth.UpdateOrgSettingForm) {
 	org.Description = form.Description
 	org.Website = form.Website
 	org.Location = form.Location
-	if err := models.UpdateUser(org); err != nil {
-		ctx.Handle(500, "UpdateUser", err)
+	if err := models.UpdateUserProfile(org); err != nil {
+		if models.IsErrInvalidWebsiteURL(err) {
+			ctx.Data["Err_Website"] = true
+			ctx.RenderWithErr(ctx.Tr("settings.website")+ctx.Tr("form.url_error"), SETTINGS_OPTIONS, &form)
+		} else {
+			ctx.Handle(500, "UpdateUserProfile", err)
+		}
 		return
 	}
 	log.Trace("Organization setting updated: %s", org.Name)
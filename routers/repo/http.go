@@ -104,30 +104,29 @@ func HTTP(ctx *context.Context) {
 
 		authUser, err = models.UserSignIn(authUsername, authPasswd)
 		if err != nil {
-			if !models.IsErrUserNotExist(err) {
+			if models.IsErrUserLocked(err) {
+				ctx.HandleText(http.StatusForbidden, "account is temporarily locked")
+				return
+			} else if !models.IsErrUserNotExist(err) {
 				ctx.Handle(http.StatusInternalServerError, "UserSignIn error: %v", err)
 				return
 			}
 
 			// Assume username now is a token.
-			token, err := models.GetAccessTokenBySHA(authUsername)
+			authUser, err = models.GetUserByAccessToken(authUsername)
 			if err != nil {
 				if models.IsErrAccessTokenNotExist(err) || models.IsErrAccessTokenEmpty(err) {
 					ctx.HandleText(http.StatusUnauthorized, "invalid token")
 				} else {
-					ctx.Handle(http.StatusInternalServerError, "GetAccessTokenBySha", err)
+					ctx.Handle(http.StatusInternalServerError, "GetUserByAccessToken", err)
 				}
 				return
 			}
-			token.Updated = time.Now()
-			if err = models.UpdateAccessToken(token); err != nil {
-				ctx.Handle(http.StatusInternalServerError, "UpdateAccessToken", err)
-			}
-			authUser, err = models.GetUserByID(token.UID)
-			if err != nil {
-				ctx.Handle(http.StatusInternalServerError, "GetUserByID", err)
-				return
-			}
+		}
+
+		if context.MustChangePasswordFirst(authUser, ctx.Req.URL.Path) {
+			ctx.HandleText(http.StatusForbidden, "password must be changed before using Git over HTTP")
+			return
 		}
 
 		if !isPublicPull {
@@ -160,6 +159,11 @@ func HTTP(ctx *context.Context) {
 				ctx.HandleText(http.StatusForbidden, "mirror repository is read-only")
 				return
 			}
+
+			if !isPull && !repoUser.HasStorageQuotaRemaining(0) {
+				ctx.HandleText(http.StatusForbidden, "repository owner has exceeded storage quota")
+				return
+			}
 		}
 	}
 
@@ -124,6 +124,8 @@ func ForkPost(ctx *context.Context, form auth.CreateRepoForm) {
 		switch {
 		case models.IsErrRepoAlreadyExist(err):
 			ctx.RenderWithErr(ctx.Tr("repo.settings.new_owner_has_same_repo"), FORK, &form)
+		case models.IsErrUserStorageQuotaExceeded(err):
+			ctx.RenderWithErr(ctx.Tr("repo.form.storage_quota_exceeded", base.FileSize(ctxUser.StorageQuota())), FORK, &form)
 		case models.IsErrNameReserved(err):
 			ctx.RenderWithErr(ctx.Tr("repo.form.name_reserved", err.(models.ErrNameReserved).Name), FORK, &form)
 		case models.IsErrNamePatternNotAllowed(err):
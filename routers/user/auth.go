@@ -7,6 +7,7 @@ package user
 import (
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/go-macaron/captcha"
 
@@ -60,8 +61,13 @@ func AutoSignIn(ctx *context.Context) (bool, error) {
 	}
 
 	isSucceed = true
+	if err = models.UpdateUserLastLogin(u); err != nil {
+		log.Error(4, "UpdateUserLastLogin: %v", err)
+	}
 	ctx.Session.Set("uid", u.ID)
 	ctx.Session.Set("uname", u.Name)
+	ctx.Session.Set("session_epoch", u.SessionEpoch)
+	ctx.Session.Set("signed_in_unix", time.Now().Unix())
 	ctx.SetCookie(setting.CSRFCookieName, "", -1, setting.AppSubUrl)
 	return true, nil
 }
@@ -101,6 +107,8 @@ func SignInPost(ctx *context.Context, form auth.SignInForm) {
 	if err != nil {
 		if models.IsErrUserNotExist(err) {
 			ctx.RenderWithErr(ctx.Tr("form.username_password_incorrect"), SIGNIN, &form)
+		} else if models.IsErrUserLocked(err) {
+			ctx.RenderWithErr(ctx.Tr("form.user_locked"), SIGNIN, &form)
 		} else {
 			ctx.Handle(500, "UserSignIn", err)
 		}
@@ -109,17 +117,31 @@ func SignInPost(ctx *context.Context, form auth.SignInForm) {
 
 	if form.Remember {
 		days := 86400 * setting.LogInRememberDays
+		// Remember cookie cannot outlive session lifetime set for the user.
+		if u.SessionMaxLifetime > 0 && u.SessionMaxLifetime < days {
+			days = u.SessionMaxLifetime
+		}
 		ctx.SetCookie(setting.CookieUserName, u.Name, days, setting.AppSubUrl)
 		ctx.SetSuperSecureCookie(base.EncodeMD5(u.Rands+u.Passwd),
 			setting.CookieRememberName, u.Name, days, setting.AppSubUrl)
 	}
 
+	if err = models.UpdateUserLastLogin(u); err != nil {
+		log.Error(4, "UpdateUserLastLogin: %v", err)
+	}
 	ctx.Session.Set("uid", u.ID)
 	ctx.Session.Set("uname", u.Name)
+	ctx.Session.Set("session_epoch", u.SessionEpoch)
+	ctx.Session.Set("signed_in_unix", time.Now().Unix())
 
 	// Clear whatever CSRF has right now, force to generate a new one
 	ctx.SetCookie(setting.CSRFCookieName, "", -1, setting.AppSubUrl)
 
+	if u.MustChangePassword {
+		ctx.Redirect(setting.AppSubUrl + "/user/settings/password")
+		return
+	}
+
 	if redirectTo, _ := url.QueryUnescape(ctx.GetCookie("redirect_to")); len(redirectTo) > 0 {
 		ctx.SetCookie("redirect_to", "", -1, setting.AppSubUrl)
 		ctx.Redirect(redirectTo)
@@ -280,6 +302,8 @@ func Activate(ctx *context.Context) {
 
 		ctx.Session.Set("uid", user.ID)
 		ctx.Session.Set("uname", user.Name)
+		ctx.Session.Set("session_epoch", user.SessionEpoch)
+		ctx.Session.Set("signed_in_unix", time.Now().Unix())
 		ctx.Redirect(setting.AppSubUrl + "/")
 		return
 	}
@@ -400,10 +424,17 @@ func ResetPasswdPost(ctx *context.Context) {
 		u.Passwd = passwd
 		u.Rands = models.GetUserSalt()
 		u.Salt = models.GetUserSalt()
-		u.EncodePasswd()
+		if err := u.EncodePasswd(); err != nil {
+			ctx.Handle(500, "EncodePasswd", err)
+			return
+		}
+		u.MustChangePassword = false
 		if err := models.UpdateUser(u); err != nil {
 			ctx.Handle(500, "UpdateUser", err)
 			return
+		} else if err = models.InvalidateUserSessions(u.ID); err != nil {
+			ctx.Handle(500, "InvalidateUserSessions", err)
+			return
 		}
 
 		log.Trace("User password reset: %s", u.Name)
@@ -387,3 +387,26 @@ func Email2User(ctx *context.Context) {
 	}
 	ctx.Redirect(setting.AppSubUrl + "/user/" + u.Name)
 }
+
+// DefaultAvatar serves generated default avatar of user, it is used as
+// Gravatar fallback for users who do not have one.
+func DefaultAvatar(ctx *context.Context) {
+	u, err := models.GetUserByName(ctx.Params(":username"))
+	if err != nil {
+		if models.IsErrUserNotExist(err) {
+			ctx.Handle(404, "GetUserByName", err)
+		} else {
+			ctx.Handle(500, "GetUserByName", err)
+		}
+		return
+	}
+
+	data, err := models.GenerateInitialsAvatar(u)
+	if err != nil {
+		ctx.Handle(500, "GenerateInitialsAvatar", err)
+		return
+	}
+	ctx.Resp.Header().Set("Content-Type", "image/png")
+	ctx.Resp.Header().Set("Cache-Control", "public, max-age=86400")
+	ctx.Resp.Write(data)
+}
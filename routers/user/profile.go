@@ -6,14 +6,19 @@ package user
 
 import (
 	"fmt"
+	"io/ioutil"
 	"path"
 	"strings"
 
 	"github.com/Unknwon/paginater"
 
+	"github.com/gogits/git-module"
+
 	"github.com/gogits/gogs/models"
 	"github.com/gogits/gogs/modules/base"
 	"github.com/gogits/gogs/modules/context"
+	"github.com/gogits/gogs/modules/log"
+	"github.com/gogits/gogs/modules/markdown"
 	"github.com/gogits/gogs/modules/setting"
 	"github.com/gogits/gogs/routers/repo"
 )
@@ -41,6 +46,62 @@ func GetUserByParams(ctx *context.Context) *models.User {
 	return GetUserByName(ctx, ctx.Params(":username"))
 }
 
+// renderProfileReadme renders README of profile repository of user if it is set.
+// Errors are only logged so that profile page is always shown.
+func renderProfileReadme(ctx *context.Context, ctxUser *models.User) {
+	if len(ctxUser.ProfileRepo) == 0 {
+		return
+	}
+
+	profileRepo, err := models.GetRepositoryByName(ctxUser.ID, ctxUser.ProfileRepo)
+	if err != nil {
+		if !models.IsErrRepoNotExist(err) {
+			log.Error(4, "GetRepositoryByName: %v", err)
+		}
+		return
+	} else if profileRepo.IsPrivate || profileRepo.IsBare {
+		return
+	}
+
+	gitRepo, err := git.OpenRepository(profileRepo.RepoPath())
+	if err != nil {
+		log.Error(4, "OpenRepository: %v", err)
+		return
+	}
+	commit, err := gitRepo.GetBranchCommit(profileRepo.DefaultBranch)
+	if err != nil {
+		log.Error(4, "GetBranchCommit: %v", err)
+		return
+	}
+	entries, err := commit.ListEntries()
+	if err != nil {
+		log.Error(4, "ListEntries: %v", err)
+		return
+	}
+
+	for _, f := range entries {
+		if f.IsDir() || !markdown.IsReadmeFile(f.Name()) || !markdown.IsMarkdownFile(f.Name()) {
+			continue
+		} else if f.Blob().Size() >= setting.UI.MaxDisplayFileSize {
+			return
+		}
+
+		dataRc, err := f.Blob().Data()
+		if err != nil {
+			log.Error(4, "Data: %v", err)
+			return
+		}
+		data, err := ioutil.ReadAll(dataRc)
+		if err != nil {
+			log.Error(4, "ReadAll: %v", err)
+			return
+		}
+		ctx.Data["ProfileReadme"] = string(markdown.Render(data,
+			profileRepo.Link()+"/src/"+profileRepo.DefaultBranch, profileRepo.ComposeMetas()))
+		return
+	}
+}
+
 func Profile(ctx *context.Context) {
 	uname := ctx.Params(":username")
 	// Special handle for FireFox requests favicon.ico.
@@ -85,6 +146,8 @@ func Profile(ctx *context.Context) {
 
 	ctx.Data["Orgs"] = orgs
 
+	renderProfileReadme(ctx, ctxUser)
+
 	tab := ctx.Query("tab")
 	ctx.Data["TabName"] = tab
 	switch tab {
@@ -46,6 +46,7 @@ func handleUsernameChange(ctx *context.Context, newName string) {
 
 	// Check if user name has been changed
 	if ctx.User.LowerName != strings.ToLower(newName) {
+		oldName := ctx.User.Name
 		if err := models.ChangeUserName(ctx.User, newName); err != nil {
 			switch {
 			case models.IsErrUserAlreadyExist(err):
@@ -54,6 +55,9 @@ func handleUsernameChange(ctx *context.Context, newName string) {
 			case models.IsErrEmailAlreadyUsed(err):
 				ctx.Flash.Error(ctx.Tr("form.email_been_used"))
 				ctx.Redirect(setting.AppSubUrl + "/user/settings")
+			case err == models.ErrUserNameIllegal:
+				ctx.Flash.Error(ctx.Tr("form.illegal_username"))
+				ctx.Redirect(setting.AppSubUrl + "/user/settings")
 			case models.IsErrNameReserved(err):
 				ctx.Flash.Error(ctx.Tr("user.newName_reserved"))
 				ctx.Redirect(setting.AppSubUrl + "/user/settings")
@@ -65,7 +69,7 @@ func handleUsernameChange(ctx *context.Context, newName string) {
 			}
 			return
 		}
-		log.Trace("User name changed: %s -> %s", ctx.User.Name, newName)
+		log.Trace("User name changed: %s -> %s", oldName, newName)
 	}
 
 	// In case it's just a case change
@@ -91,12 +95,37 @@ func SettingsPost(ctx *context.Context, form auth.UpdateProfileForm) {
 	ctx.User.Email = form.Email
 	ctx.User.Website = form.Website
 	ctx.User.Location = form.Location
-	if len(form.Gravatar) > 0 {
+	if len(form.Gravatar) > 0 && form.Gravatar != ctx.User.AvatarEmail {
 		ctx.User.Avatar = base.EncodeMD5(form.Gravatar)
 		ctx.User.AvatarEmail = form.Gravatar
+		if err := models.CheckAvatarEmail(ctx.User); err != nil {
+			if models.IsErrAvatarEmailNotVerified(err) {
+				ctx.Flash.Error(ctx.Tr("settings.avatar_email_not_verified"))
+				ctx.Redirect(setting.AppSubUrl + "/user/settings")
+			} else {
+				ctx.Handle(500, "CheckAvatarEmail", err)
+			}
+			return
+		}
 	}
-	if err := models.UpdateUser(ctx.User); err != nil {
-		ctx.Handle(500, "UpdateUser", err)
+	if form.ProfileRepo != ctx.User.ProfileRepo {
+		if err := models.SetProfileRepo(ctx.User, form.ProfileRepo); err != nil {
+			if models.IsErrRepoNotExist(err) {
+				ctx.Flash.Error(ctx.Tr("settings.profile_repo_not_found"))
+				ctx.Redirect(setting.AppSubUrl + "/user/settings")
+			} else {
+				ctx.Handle(500, "SetProfileRepo", err)
+			}
+			return
+		}
+	}
+	if err := models.UpdateUserProfile(ctx.User); err != nil {
+		if models.IsErrInvalidWebsiteURL(err) {
+			ctx.Flash.Error(ctx.Tr("settings.website") + ctx.Tr("form.url_error"))
+			ctx.Redirect(setting.AppSubUrl + "/user/settings")
+			return
+		}
+		ctx.Handle(500, "UpdateUserProfile", err)
 		return
 	}
 
@@ -120,7 +149,7 @@ func UpdateAvatarSetting(ctx *context.Context, form auth.UploadAvatarForm, ctxUs
 		if err != nil {
 			return fmt.Errorf("ioutil.ReadAll: %v", err)
 		}
-		if _, ok := base.IsImageFile(data); !ok {
+		if _, ok := base.IsImageFile(data); !ok && !models.IsSVGImage(data) {
 			return errors.New(ctx.Tr("settings.uploaded_avatar_not_a_image"))
 		}
 		if err = ctxUser.UploadAvatar(data); err != nil {
@@ -183,11 +212,20 @@ func SettingsPasswordPost(ctx *context.Context, form auth.ChangePasswordForm) {
 	} else {
 		ctx.User.Passwd = form.Password
 		ctx.User.Salt = models.GetUserSalt()
-		ctx.User.EncodePasswd()
+		if err := ctx.User.EncodePasswd(); err != nil {
+			ctx.Handle(500, "EncodePasswd", err)
+			return
+		}
+		ctx.User.MustChangePassword = false
 		if err := models.UpdateUser(ctx.User); err != nil {
 			ctx.Handle(500, "UpdateUser", err)
 			return
+		} else if err = models.InvalidateUserSessions(ctx.User.ID); err != nil {
+			ctx.Handle(500, "InvalidateUserSessions", err)
+			return
 		}
+		// Keep current session signed in, others are invalidated.
+		ctx.Session.Set("session_epoch", ctx.User.SessionEpoch+1)
 		log.Trace("User password updated: %s", ctx.User.Name)
 		ctx.Flash.Success(ctx.Tr("settings.change_password_success"))
 	}
@@ -357,9 +395,9 @@ func SettingsApplications(ctx *context.Context) {
 	ctx.Data["Title"] = ctx.Tr("settings")
 	ctx.Data["PageIsSettingsApplications"] = true
 
-	tokens, err := models.ListAccessTokens(ctx.User.ID)
+	tokens, err := models.GetAccessTokens(ctx.User.ID)
 	if err != nil {
-		ctx.Handle(500, "ListAccessTokens", err)
+		ctx.Handle(500, "GetAccessTokens", err)
 		return
 	}
 	ctx.Data["Tokens"] = tokens
@@ -372,9 +410,9 @@ func SettingsApplicationsPost(ctx *context.Context, form auth.NewAccessTokenForm
 	ctx.Data["PageIsSettingsApplications"] = true
 
 	if ctx.HasError() {
-		tokens, err := models.ListAccessTokens(ctx.User.ID)
+		tokens, err := models.GetAccessTokens(ctx.User.ID)
 		if err != nil {
-			ctx.Handle(500, "ListAccessTokens", err)
+			ctx.Handle(500, "GetAccessTokens", err)
 			return
 		}
 		ctx.Data["Tokens"] = tokens
@@ -392,14 +430,14 @@ func SettingsApplicationsPost(ctx *context.Context, form auth.NewAccessTokenForm
 	}
 
 	ctx.Flash.Success(ctx.Tr("settings.generate_token_succees"))
-	ctx.Flash.Info(t.Sha1)
+	ctx.Flash.Info(t.Token)
 
 	ctx.Redirect(setting.AppSubUrl + "/user/settings/applications")
 }
 
 func SettingsDeleteApplication(ctx *context.Context) {
-	if err := models.DeleteAccessTokenByID(ctx.QueryInt64("id")); err != nil {
-		ctx.Flash.Error("DeleteAccessTokenByID: " + err.Error())
+	if err := models.DeleteAccessToken(ctx.User.ID, ctx.QueryInt64("id")); err != nil {
+		ctx.Flash.Error("DeleteAccessToken: " + err.Error())
 	} else {
 		ctx.Flash.Success(ctx.Tr("settings.delete_token_success"))
 	}
@@ -423,7 +461,8 @@ func SettingsDelete(ctx *context.Context) {
 			return
 		}
 
-		if err := models.DeleteUser(ctx.User); err != nil {
+		name := ctx.User.Name
+		if err := models.DeleteUserAccount(ctx.User); err != nil {
 			switch {
 			case models.IsErrUserOwnRepos(err):
 				ctx.Flash.Error(ctx.Tr("form.still_own_repo"))
@@ -432,10 +471,10 @@ func SettingsDelete(ctx *context.Context) {
 				ctx.Flash.Error(ctx.Tr("form.still_has_org"))
 				ctx.Redirect(setting.AppSubUrl + "/user/settings/delete")
 			default:
-				ctx.Handle(500, "DeleteUser", err)
+				ctx.Handle(500, "DeleteUserAccount", err)
 			}
 		} else {
-			log.Trace("Account deleted: %s", ctx.User.Name)
+			log.Trace("Account deleted: %s", name)
 			ctx.Redirect(setting.AppSubUrl + "/")
 		}
 		return